@@ -1,8 +1,31 @@
 // go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m drop_copy
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m drop_copy -j journal.db
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m drop_copy -pos 10s -posm avg
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m drop_copy -l no -e - | jq .
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m drop_copy -lf compact -lv '0=none'
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m drop_copy -l file -ld logs -lraw
+// go run cmd/*.go -m journal -j journal.db -jfrom 2024-01-01 -js BTC-USD -jcsv fills.csv
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m trade_history -thfrom 2024-01-01 -tho trades.csv -j journal.db
+// go run cmd/*.go -f spec/TEST-DropCopy.cfg -a test-example-key -m positions -pa <account> -psub -j journal.db
+// go run cmd/*.go -m decode -dt 8 -dc <ClOrdID> -df compact logs/*.messages.log
 // go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry -rec
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m scenario -sc spec/scenarios/order-entry.yaml
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry -bp
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry_manual -l no
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m balances -bc USD
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry_perf -l no -puo 100 -pd 1m
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry_perf -l no -pp spec/perf/open-loop.json
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry_perf -l no -pd 1m -pr perf-new.json
+// go run cmd/*.go -m perf_compare perf-base.json perf-new.json
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry_perf -metrics :9100
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m order_entry -rate 'D=10/20,F=50,*=100'
 // go run cmd/*.go -f spec/TEST-OrderEntry.cfg -g spec/TEST-DropCopy.cfg -a test-example-key -m cancel_all
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -g spec/TEST-DropCopy.cfg -a test-example-key -m blotter -l file -ld logs
+// go run cmd/*.go -f spec/TEST-OrderEntry.cfg -g spec/TEST-DropCopy.cfg -a test-example-key -m reconcile -c addOrder,addOrderMatch,cancelOrder
 // go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m security_list -c securityListRequest
 // go run cmd/*.go -f spec/TEST-OrderEntry.cfg -a test-example-key -m security_list -c securityDefinitionRequest
+// go build -o fix-client ./cmd && ./fix-client -f TEST-OrderEntry.cfg -k /etc/powertrade/keys -a <api key> -m order_entry
 // Please create `<account_id>.api` with api_key and `<account_id>.pem` with private key
 
 // If you don't want to generate Password on each Logon, you may generate a JWT expiring in the far future
@@ -28,6 +51,11 @@ var passwordDuration = flag.Duration("d", 10*365*24*time.Hour, "Duration of JWT
 func main() {
 	var err error
 	flag.Parse()
+	defer fix.CloseLogFiles()
+	// perf stops on SIGINT/SIGTERM and returns, other modes run until they are killed
+	if *fixMode != "order_entry_perf" {
+		fix.CloseLogFilesOnSignal()
+	}
 
 	switch *fixMode {
 	case "drop_copy":
@@ -36,12 +64,30 @@ func main() {
 		err = fix.RunOrderEntry(*fixConfigPath, *apiKeyName)
 	case "order_entry_manual":
 		err = fix.RunOrderEntryManual(*fixConfigPath, *apiKeyName)
+	case "scenario":
+		err = fix.RunScenario(*fixConfigPath, *apiKeyName)
 	case "order_entry_perf":
 		err = fix.RunOrderEntryPerf(*fixConfigPath, *apiKeyName)
 	case "security_list":
 		err = fix.RunSecurityList(*fixConfigPath, *apiKeyName)
 	case "cancel_all":
 		err = fix.RunCancelAll(*fixConfigPath, *fixConfig2Path, *apiKeyName)
+	case "journal":
+		err = fix.RunJournal()
+	case "trade_history":
+		err = fix.RunTradeHistory(*fixConfigPath, *apiKeyName)
+	case "positions":
+		err = fix.RunPositions(*fixConfigPath, *apiKeyName)
+	case "balances":
+		err = fix.RunBalances(*fixConfigPath, *apiKeyName)
+	case "decode":
+		err = fix.RunDecode(flag.Args())
+	case "perf_compare":
+		err = fix.RunPerfCompare(flag.Args())
+	case "blotter":
+		err = fix.RunBlotter(*fixConfigPath, *fixConfig2Path, *apiKeyName)
+	case "reconcile":
+		err = fix.RunReconcile(*fixConfigPath, *fixConfig2Path, *apiKeyName)
 	case "gen_password":
 		err = fix.RunGeneratePassword(*fixConfigPath, *apiKeyName, *passwordDuration)
 	default:
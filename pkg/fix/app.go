@@ -18,7 +18,7 @@ import (
 )
 
 var (
-	loggerCmd = flag.String("l", "file", "Log") // file/no
+	loggerCmd = flag.String("l", "screen", "Log") // screen/file/no
 )
 
 // TradeClient implements the quickfix.Application interface
@@ -28,6 +28,9 @@ type TradeClient struct {
 	Settings     *quickfix.Settings
 	connected    bool
 	connectCond  *sync.Cond
+	events       *EventStream
+
+	RecoverOrders bool // send OrderMassStatusRequest after Logon
 }
 
 type ApplicationWithWait interface {
@@ -36,7 +39,7 @@ type ApplicationWithWait interface {
 }
 
 func NewTradeClient(cfgFilename string, keyFilename string) (*TradeClient, error) {
-	apiKey, privateKey, err := pt.ReadKeys(keyFilename)
+	apiKey, privateKey, err := pt.ReadKeysFrom(*keysDirCmd, keyFilename)
 	if err != nil {
 		return nil, err
 	}
@@ -46,27 +49,27 @@ func NewTradeClient(cfgFilename string, keyFilename string) (*TradeClient, error
 		return nil, err
 	}
 
+	events, err := DefaultEventStream()
+	if err != nil {
+		return nil, err
+	}
+
 	app := &TradeClient{
 		SenderCompID: apiKey,
 		PrivateKey:   privateKey,
 		Settings:     settings,
 		connected:    false,
 		connectCond:  sync.NewCond(&sync.Mutex{}),
+		events:       events,
 	}
 	return app, nil
 }
 
 func ReadConfig(cfgFilename string, apiKey string) (*quickfix.Settings, error) {
-	cfg, err := os.Open(cfgFilename)
+	stringData, err := ReadSpecFile(cfgFilename)
 	if err != nil {
 		return nil, fmt.Errorf("open '%v': %v", cfgFilename, err)
 	}
-	defer cfg.Close()
-
-	stringData, readErr := io.ReadAll(cfg)
-	if readErr != nil {
-		return nil, fmt.Errorf("error reading cfg: %s,", readErr)
-	}
 
 	// Quickfix doesn't allow settings without at least 1 SESSION
 	stringData = append(stringData, []byte(`
@@ -79,6 +82,13 @@ SenderCompID=`+apiKey+`
 	if err != nil {
 		return nil, fmt.Errorf("error reading cfg: %s,\n%s", err, stringData)
 	}
+	if err = resolveDataDictionary(settings); err != nil {
+		return nil, err
+	}
+	// SendingTime of order requests is a timestamp of the latency breakdown, see OrderTracker.OnSent
+	if !settings.GlobalSettings().HasSetting(config.TimeStampPrecision) {
+		settings.GlobalSettings().Set(config.TimeStampPrecision, "NANOS")
+	}
 
 	return settings, nil
 }
@@ -87,15 +97,40 @@ func StartConnection(app ApplicationWithWait, settings *quickfix.Settings) error
 	var logFactory quickfix.LogFactory
 
 	switch *loggerCmd {
+	case "screen":
+		options, err := LogOptionsFromFlags()
+		if err != nil {
+			return err
+		}
+		logFactory = NewBeautyLogFactory(quickfix.NewScreenLogFactory(), options)
 	case "file":
-		logFactory = NewBeautyLogFactory(quickfix.NewScreenLogFactory())
+		options, err := LogOptionsFromFlags()
+		if err != nil {
+			return err
+		}
+		logFactory = NewBeautyLogFactory(NewFileLogFactoryFromFlags(), options)
 	case "no":
 		logFactory = quickfix.NewNullLogFactory()
 	default:
 		panic(fmt.Sprintf("unknown log: %s", *loggerCmd))
 	}
 
-	initiator, err := quickfix.NewInitiator(app, quickfix.NewMemoryStoreFactory(), settings, logFactory)
+	var fixApp ApplicationWithWait = app
+	if *metricsAddrCmd != "" {
+		if err := StartMetricsServer(); err != nil {
+			return fmt.Errorf("metrics: %v", err)
+		}
+		fixApp = metricsApp{fixApp}
+	}
+	hasThrottle, err := registerThrottles(settings)
+	if err != nil {
+		return err
+	}
+	if hasThrottle {
+		fixApp = throttleApp{fixApp}
+	}
+
+	initiator, err := quickfix.NewInitiator(fixApp, quickfix.NewMemoryStoreFactory(), settings, logFactory)
 	if err != nil {
 		return fmt.Errorf("unable to create Initiator: %s", err)
 	}
@@ -118,7 +153,11 @@ func StartConnection(app ApplicationWithWait, settings *quickfix.Settings) error
 func (e *TradeClient) OnCreate(sessionID quickfix.SessionID) {}
 
 // OnLogon implemented as part of Application interface
-func (e *TradeClient) OnLogon(sessionID quickfix.SessionID) {}
+func (e *TradeClient) OnLogon(sessionID quickfix.SessionID) {
+	if e.RecoverOrders {
+		sendOrderMassStatus(sessionID)
+	}
+}
 
 // OnLogout implemented as part of Application interface
 func (e *TradeClient) OnLogout(sessionID quickfix.SessionID) {}
@@ -157,21 +196,56 @@ func (e *TradeClient) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionI
 		msg.Body.Set(field.NewPassword(password))
 		msg.Body.Set(field.NewResetSeqNumFlag(true))
 	}
-	fmt.Printf("\n[TO ADMIN]:\n")
+	e.printf("\n[TO ADMIN]:\n")
 }
 
 // ToApp implemented as part of Application interface
 func (e *TradeClient) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) (err error) {
-	fmt.Printf("\n[TO APP]:\n")
+	e.printf("\n[TO APP]:\n")
+	DefaultOrderTracker.OnSent(msg, time.Now())
+	if e.events != nil {
+		e.events.Publish(msg, sessionID, "out", time.Now())
+	}
 	return
 }
 
 // FromApp implemented as part of Application interface. This is the callback for all Application level messages from the counter party.
 func (e *TradeClient) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
-	fmt.Printf("[FROM APP]\n\n")
+	e.printf("[FROM APP]\n\n")
+	if e.events != nil {
+		e.events.Publish(msg, sessionID, "in", time.Now())
+	}
+
+	err := DefaultInstruments.FromApp(msg)
+	if err != nil {
+		e.printf("Instruments: %v\n", err)
+	}
+
+	if order, acked := DefaultOrderTracker.FromExecutionReport(msg); acked {
+		printLatencyBreakdown(e.out(), order)
+	}
+	DefaultOrderTracker.FromReject(msg)
+	DefaultBalances.FromApp(msg)
+
+	if exec, ok := DefaultStrategyOrders.FromExecutionReport(msg); ok {
+		e.printf("%s\n\n", exec)
+	}
 	return
 }
 
+// out is where callbacks print: stderr if events are written to stdout (`-e -`), so that stdout stays NDJSON.
+// os.Stdout is read on each call, the order entry shell swaps it.
+func (e *TradeClient) out() io.Writer {
+	if e.events != nil && e.events.stdout {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func (e *TradeClient) printf(format string, a ...any) {
+	fmt.Fprintf(e.out(), format, a...)
+}
+
 func (e *TradeClient) WaitConnect() bool {
 	e.connectCond.L.Lock()
 	defer e.connectCond.L.Unlock()
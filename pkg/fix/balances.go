@@ -0,0 +1,395 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/collateralinquiry"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	balancesTimeout = 10 * time.Second
+)
+
+var (
+	balancesAccountCmd  = flag.String("ba", "", "Balances: account of CollateralInquiry")
+	balancesCurrencyCmd = flag.String("bc", "", "Balances: currency of CollateralInquiry")
+	buyingPowerCmd      = flag.Bool("bp", false, "Order entry: load balances and skip orders exceeding buying power")
+
+	DefaultBalances = NewBalances()
+)
+
+// Balance is a decoded CollateralReport of an account and currency
+type Balance struct {
+	ReportID        string
+	Account         string
+	Currency        string
+	Status          enum.CollStatus
+	Quantity        decimal.Decimal
+	CashOutstanding decimal.Decimal
+	MarginExcess    decimal.Decimal
+	TotalNetValue   decimal.Decimal
+	StartCash       decimal.Decimal
+	EndCash         decimal.Decimal
+	hasMarginExcess bool
+	hasEndCash      bool
+	ReceivedAt      time.Time
+}
+
+// Available is the margin excess if reported, the end cash or the collateral quantity otherwise
+func (b Balance) Available() decimal.Decimal {
+	switch {
+	case b.hasMarginExcess:
+		return b.MarginExcess
+	case b.hasEndCash:
+		return b.EndCash
+	default:
+		return b.Quantity
+	}
+}
+
+// DecodeCollateralReport converts a CollateralReport
+func DecodeCollateralReport(msg *quickfix.Message, receivedAt time.Time) Balance {
+	return Balance{
+		ReportID:        getString(msg.Body, tag.CollRptID),
+		Account:         getString(msg.Body, tag.Account),
+		Currency:        getString(msg.Body, tag.Currency),
+		Status:          enum.CollStatus(getString(msg.Body, tag.CollStatus)),
+		Quantity:        getDecimal(msg.Body, tag.Quantity),
+		CashOutstanding: getDecimal(msg.Body, tag.CashOutstanding),
+		MarginExcess:    getDecimal(msg.Body, tag.MarginExcess),
+		TotalNetValue:   getDecimal(msg.Body, tag.TotalNetValue),
+		StartCash:       getDecimal(msg.Body, tag.StartCash),
+		EndCash:         getDecimal(msg.Body, tag.EndCash),
+		hasMarginExcess: msg.Body.Has(tag.MarginExcess),
+		hasEndCash:      msg.Body.Has(tag.EndCash),
+		ReceivedAt:      receivedAt,
+	}
+}
+
+// balanceInquiry collects CollateralReports of one CollateralInquiry
+type balanceInquiry struct {
+	total    int // TotNumReports, -1 until known
+	balances []Balance
+	done     chan error
+	finished bool
+}
+
+func (i *balanceInquiry) finish(err error) {
+	if !i.finished {
+		i.finished = true
+		i.done <- err
+	}
+}
+
+// Balances keeps the latest CollateralReport of each account and currency
+type Balances struct {
+	mu        sync.Mutex
+	byKey     map[string]Balance         // account/currency -> latest report
+	inquiries map[string]*balanceInquiry // CollInquiryID -> inquiry
+}
+
+func NewBalances() *Balances {
+	return &Balances{
+		byKey:     make(map[string]Balance),
+		inquiries: make(map[string]*balanceInquiry),
+	}
+}
+
+// FromApp updates balances from CollateralReport / CollateralInquiryAck, other messages are ignored
+func (b *Balances) FromApp(msg *quickfix.Message) {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_COLLATERAL_INQUIRY_ACK:
+		b.onAck(msg)
+	case enum.MsgType_COLLATERAL_REPORT:
+		b.onReport(msg)
+	}
+}
+
+func (b *Balances) onAck(msg *quickfix.Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	inquiry := b.inquiries[getString(msg.Body, tag.CollInquiryID)]
+	if inquiry == nil {
+		return
+	}
+
+	status := enum.CollInquiryStatus(getString(msg.Body, tag.CollInquiryStatus))
+	result := enum.CollInquiryResult(getString(msg.Body, tag.CollInquiryResult))
+	if status == enum.CollInquiryStatus_REJECTED || (result != "" && result != enum.CollInquiryResult_SUCCESSFUL) {
+		inquiry.finish(fmt.Errorf("CollateralInquiry rejected: CollInquiryResult=%s %s", result, getString(msg.Body, tag.Text)))
+		return
+	}
+
+	if msg.Body.Has(tag.TotNumReports) {
+		inquiry.total = getInt(msg.Body, tag.TotNumReports)
+	}
+	if inquiry.total >= 0 && len(inquiry.balances) >= inquiry.total {
+		inquiry.finish(nil)
+	}
+}
+
+func (b *Balances) onReport(msg *quickfix.Message) {
+	balance := DecodeCollateralReport(msg, time.Now())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.byKey[balance.Account+"/"+balance.Currency] = balance
+
+	inquiry := b.inquiries[getString(msg.Body, tag.CollInquiryID)]
+	if inquiry == nil {
+		return
+	}
+	inquiry.balances = append(inquiry.balances, balance)
+	if msg.Body.Has(tag.TotNumReports) {
+		inquiry.total = getInt(msg.Body, tag.TotNumReports)
+	}
+	last, _ := msg.Body.GetBool(tag.LastRptRequested)
+	if last || (inquiry.total >= 0 && len(inquiry.balances) >= inquiry.total) {
+		inquiry.finish(nil)
+	}
+}
+
+// List returns the latest balances sorted by account and currency
+func (b *Balances) List() []Balance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]Balance, 0, len(b.byKey))
+	for _, balance := range b.byKey {
+		list = append(list, balance)
+	}
+	sortBalances(list)
+	return list
+}
+
+// Available sums available balances of a currency over all accounts
+func (b *Balances) Available(currency string) (decimal.Decimal, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total, found := decimal.Zero, false
+	for _, balance := range b.byKey {
+		if balance.Currency == currency {
+			total = total.Add(balance.Available())
+			found = true
+		}
+	}
+	return total, found
+}
+
+// CheckBuyingPower fails if the amount exceeds the available balance of the currency
+func (b *Balances) CheckBuyingPower(currency string, amount decimal.Decimal) error {
+	available, found := b.Available(currency)
+	if !found {
+		return fmt.Errorf("buying power: no balance in %s", currency)
+	}
+	if amount.GreaterThan(available) {
+		return fmt.Errorf("buying power: %s %s exceeds available %s", amount, currency, available)
+	}
+	return nil
+}
+
+// CheckOrder checks a NewOrderSingle or NewOrderMultileg (per leg) against balances before sending:
+// a spot sell needs OrderQty of the base asset, other orders are charged their notional in the currency of the instrument.
+// Orders without a price pass, spot sells excepted; orders of unknown instruments are refused.
+func (b *Balances) CheckOrder(msg *quickfix.Message) error {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_ORDER_SINGLE:
+		required := make(map[string]decimal.Decimal)
+		side := enum.Side(getString(msg.Body, tag.Side))
+		qty := getDecimal(msg.Body, tag.OrderQty)
+		if err := addRequired(required, getString(msg.Body, tag.Symbol), side, qty, getDecimal(msg.Body, tag.Price)); err != nil {
+			return err
+		}
+		return b.checkRequired(required)
+	case enum.MsgType_NEW_ORDER_MULTILEG:
+		return b.checkMultileg(msg)
+	}
+	return nil
+}
+
+// checkMultileg checks each leg with its LegPrice, legs without one are charged the strategy Price of a buy
+func (b *Balances) checkMultileg(msg *quickfix.Message) error {
+	legs, err := GetMessageGroup(msg, tag.NoLegs)
+	if err != nil {
+		return err
+	}
+
+	required := make(map[string]decimal.Decimal)
+	side := enum.Side(getString(msg.Body, tag.Side))
+	qty := getDecimal(msg.Body, tag.OrderQty)
+	unpriced := ""
+	for i := 0; i < legs.Len(); i++ {
+		g := legs.Get(i)
+		symbol := getString(g, tag.LegSymbol)
+		ratio := getDecimal(g, tag.LegRatioQty)
+		legQty := qty.Mul(ratio.Abs())
+		if ratio.IsZero() {
+			legQty = getDecimal(g, tag.LegQty)
+		}
+		legSide := enum.Side(getString(g, tag.LegSide))
+		if legSide == "" {
+			legSide = side
+			if ratio.IsNegative() {
+				legSide = oppositeSide(side)
+			}
+		}
+
+		legPx := getDecimal(g, tag.LegPrice)
+		if legPx.IsZero() && unpriced == "" {
+			unpriced = symbol
+		}
+		if err := addRequired(required, symbol, legSide, legQty, legPx); err != nil {
+			return err
+		}
+	}
+
+	if unpriced != "" && side == enum.Side_BUY && msg.Body.Has(tag.Price) {
+		if err := addRequired(required, unpriced, enum.Side_BUY, qty, getDecimal(msg.Body, tag.Price)); err != nil {
+			return err
+		}
+	}
+	return b.checkRequired(required)
+}
+
+// addRequired adds what an order of symbol needs to required, by currency
+func addRequired(required map[string]decimal.Decimal, symbol string, side enum.Side, qty decimal.Decimal, price decimal.Decimal) error {
+	instrument, found := DefaultInstruments.Get(symbol)
+	if !found || instrument.Currency == "" {
+		return fmt.Errorf("buying power: unknown instrument %s", symbol)
+	}
+
+	if side == enum.Side_SELL && instrument.IsSpot() {
+		required[instrument.Underlying] = required[instrument.Underlying].Add(qty.Abs())
+		return nil
+	}
+	if price.IsZero() {
+		return nil
+	}
+
+	notional := qty.Mul(price).Abs()
+	if !instrument.ContractMultiplier.IsZero() {
+		notional = notional.Mul(instrument.ContractMultiplier)
+	}
+	required[instrument.Currency] = required[instrument.Currency].Add(notional)
+	return nil
+}
+
+func (b *Balances) checkRequired(required map[string]decimal.Decimal) error {
+	currencies := make([]string, 0, len(required))
+	for currency := range required {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		if err := b.CheckBuyingPower(currency, required[currency]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortBalances(list []Balance) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Account != list[j].Account {
+			return list[i].Account < list[j].Account
+		}
+		return list[i].Currency < list[j].Currency
+	})
+}
+
+// FormatBalances renders balances as a table per account and currency
+func FormatBalances(list []Balance) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-20s %-8s %18s %18s %18s %18s %18s\n", "Account", "Currency", "Quantity", "Available", "NetValue", "Outstanding", "EndCash")
+	for _, balance := range list {
+		fmt.Fprintf(&b, "%-20s %-8s %18s %18s %18s %18s %18s\n",
+			balance.Account, balance.Currency, balance.Quantity, balance.Available(),
+			balance.TotalNetValue, balance.CashOutstanding, balance.EndCash,
+		)
+	}
+	fmt.Fprintf(&b, "Balances: %d\n", len(list))
+	return b.String()
+}
+
+// RequestBalances sends CollateralInquiry of an account and currency (all if empty) and waits for its reports
+func RequestBalances(app *TradeClient, targetCompID string, account string, currency string, timeout time.Duration) ([]Balance, error) {
+	inquiryID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+
+	inquiry := collateralinquiry.New()
+	inquiry.SetCollInquiryID(inquiryID)
+	inquiry.SetSubscriptionRequestType(enum.SubscriptionRequestType_SNAPSHOT)
+	if account != "" {
+		inquiry.SetAccount(account)
+	}
+	if currency != "" {
+		inquiry.SetCurrency(currency)
+	}
+
+	msg := inquiry.ToMessage()
+	msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(targetCompID))
+
+	pending := &balanceInquiry{total: -1, done: make(chan error, 1)}
+	DefaultBalances.mu.Lock()
+	DefaultBalances.inquiries[inquiryID] = pending
+	DefaultBalances.mu.Unlock()
+
+	err := Send(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case err = <-pending.done:
+	case <-time.After(timeout):
+		err = fmt.Errorf("CollateralInquiry %s: no response in %v", inquiryID, timeout)
+	}
+
+	DefaultBalances.mu.Lock()
+	defer DefaultBalances.mu.Unlock()
+	delete(DefaultBalances.inquiries, inquiryID)
+	sortBalances(pending.balances)
+	return pending.balances, err
+}
+
+// LoadBalances fills DefaultBalances for buying power checks
+func LoadBalances(app *TradeClient, targetCompID string) error {
+	list, err := RequestBalances(app, targetCompID, *balancesAccountCmd, *balancesCurrencyCmd, balancesTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n%s\n", FormatBalances(list))
+	return nil
+}
+
+func RunBalances(cfgFileName string, apiKeyName string) error {
+	app, err := NewTradeClient(cfgFileName, apiKeyName)
+	if err != nil {
+		return err
+	}
+
+	err = StartConnection(app, app.Settings)
+	if err != nil {
+		return err
+	}
+	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
+
+	return LoadBalances(app, targetCompID)
+}
@@ -0,0 +1,578 @@
+package fix
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/positions"
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/ordermasscancelrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+	"github.com/quickfixgo/tag"
+	"golang.org/x/term"
+)
+
+const (
+	blotterHistory = 100 // fills and rejects kept
+
+	blotterMassCancelTimeout = 5 * time.Second // without OrderMassCancelReport, mass cancels are taken as unsupported
+
+	blotterKeyUp   = "up"
+	blotterKeyDown = "down"
+)
+
+var (
+	blotterRefreshCmd = flag.Duration("br", time.Second, "Blotter: screen refresh interval")
+)
+
+// blotterSession is the state of a session shown by the blotter
+type blotterSession struct {
+	name         string
+	id           quickfix.SessionID
+	loggedOn     bool
+	changedAt    time.Time // of loggedOn
+	heartbeatIn  time.Time
+	heartbeatOut time.Time
+	lastIn       time.Time
+	lastOut      time.Time
+}
+
+type blotterReject struct {
+	At      time.Time
+	Session string
+	Type    string
+	ID      string
+	Text    string
+}
+
+// Blotter is a terminal UI of sessions, open orders, fills, positions and rejects of the order-entry and/or drop-copy
+// sessions. The selected order can be canceled and the kill switch cancels all orders.
+type Blotter struct {
+	mu          sync.Mutex
+	sessions    []*blotterSession
+	fills       []*journal.Execution // latest first
+	rejects     []blotterReject      // latest first
+	positions   *positions.Engine
+	fillsFrom   string // session of the fills, the drop copy if any, not to count fills twice
+	selected    int    // index in the working orders
+	selectedID  string // ClOrdID of the selected order as rendered
+	confirmKill bool
+	status      string
+
+	oe         *TradeClient
+	oeTargetID string
+
+	massCancelWaiters map[string]chan bool // ClOrdID -> accepted
+}
+
+// blotterApp feeds the blotter with messages of a session
+type blotterApp struct {
+	ApplicationWithWait
+	blotter    *Blotter
+	session    *blotterSession
+	settings   *quickfix.Settings
+	applyFills bool // to the positions of the blotter, the drop copy client keeps its own
+}
+
+func (a blotterApp) OnCreate(sessionID quickfix.SessionID) {
+	a.blotter.mu.Lock()
+	a.session.id = sessionID
+	a.blotter.mu.Unlock()
+	a.ApplicationWithWait.OnCreate(sessionID)
+}
+
+func (a blotterApp) OnLogon(sessionID quickfix.SessionID) {
+	a.blotter.setLoggedOn(a.session, true)
+	a.ApplicationWithWait.OnLogon(sessionID)
+}
+
+func (a blotterApp) OnLogout(sessionID quickfix.SessionID) {
+	a.blotter.setLoggedOn(a.session, false)
+	a.ApplicationWithWait.OnLogout(sessionID)
+}
+
+func (a blotterApp) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) {
+	a.ApplicationWithWait.ToAdmin(msg, sessionID)
+	now := time.Now()
+	msgType, _ := msg.MsgType()
+	a.blotter.mu.Lock()
+	a.session.lastOut = now
+	if enum.MsgType(msgType) == enum.MsgType_HEARTBEAT {
+		a.session.heartbeatOut = now
+	}
+	a.blotter.mu.Unlock()
+}
+
+func (a blotterApp) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) error {
+	a.blotter.mu.Lock()
+	a.session.lastOut = time.Now()
+	a.blotter.mu.Unlock()
+	return a.ApplicationWithWait.ToApp(msg, sessionID)
+}
+
+func (a blotterApp) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	now := time.Now()
+	msgType, _ := msg.MsgType()
+	a.blotter.mu.Lock()
+	a.session.lastIn = now
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_HEARTBEAT:
+		a.session.heartbeatIn = now
+	case enum.MsgType_REJECT:
+		a.blotter.addRejectLocked(blotterReject{now, a.session.name, "Reject",
+			"RefSeqNum=" + getString(msg.Body, tag.RefSeqNum), getString(msg.Body, tag.Text)})
+	case enum.MsgType_LOGOUT:
+		if text := getString(msg.Body, tag.Text); text != "" {
+			a.blotter.status = fmt.Sprintf("%s: Logout: %s", a.session.name, text)
+		}
+	}
+	a.blotter.mu.Unlock()
+	return a.ApplicationWithWait.FromAdmin(msg, sessionID)
+}
+
+func (a blotterApp) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	reject := a.ApplicationWithWait.FromApp(msg, sessionID)
+
+	now := time.Now()
+	msgType, _ := msg.MsgType()
+	a.blotter.mu.Lock()
+	defer a.blotter.mu.Unlock()
+	a.session.lastIn = now
+
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_EXECUTION_REPORT:
+		exec := DecodeExecution(msg, now)
+		if exec.ExecType == enum.ExecType_REJECTED {
+			a.blotter.addRejectLocked(blotterReject{now, a.session.name, "OrderReject", "ClOrdID=" + exec.ClOrdID,
+				strings.TrimSpace(exec.OrdRejReason + " " + exec.Text)})
+		}
+		if a.session.name != a.blotter.fillsFrom || !exec.IsFill() {
+			break
+		}
+		a.blotter.fills = prependCapped(a.blotter.fills, exec)
+		if a.applyFills {
+			applyPositionFills(a.blotter.positions, exec)
+		}
+
+	case enum.MsgType_ORDER_CANCEL_REJECT:
+		a.blotter.addRejectLocked(blotterReject{now, a.session.name, "CancelReject",
+			"OrigClOrdID=" + getString(msg.Body, tag.OrigClOrdID),
+			strings.TrimSpace(getString(msg.Body, tag.CxlRejReason) + " " + getString(msg.Body, tag.Text))})
+
+	case enum.MsgType_ORDER_MASS_CANCEL_REPORT:
+		clOrdID := getString(msg.Body, tag.ClOrdID)
+		accepted := enum.MassCancelResponse(getString(msg.Body, tag.MassCancelResponse)) != enum.MassCancelResponse_CANCEL_REQUEST_REJECTED
+		if !accepted {
+			a.blotter.addRejectLocked(blotterReject{now, a.session.name, "MassCancelReject", "ClOrdID=" + clOrdID,
+				strings.TrimSpace(getString(msg.Body, tag.MassCancelRejectReason) + " " + getString(msg.Body, tag.Text))})
+		}
+		a.blotter.notifyMassCancelLocked(clOrdID, accepted)
+
+	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
+		refID := getString(msg.Body, tag.BusinessRejectRefID)
+		a.blotter.addRejectLocked(blotterReject{now, a.session.name, "BusinessReject", "RefID=" + refID,
+			strings.TrimSpace(getString(msg.Body, tag.BusinessRejectReason) + " " + getString(msg.Body, tag.Text))})
+		a.blotter.notifyMassCancelLocked(refID, false)
+	}
+	return reject
+}
+
+// prependCapped adds v first and keeps blotterHistory values
+func prependCapped[T any](values []T, v T) []T {
+	values = append([]T{v}, values...)
+	if len(values) > blotterHistory {
+		values = values[:blotterHistory]
+	}
+	return values
+}
+
+func (b *Blotter) addRejectLocked(reject blotterReject) {
+	b.rejects = prependCapped(b.rejects, reject)
+}
+
+// notifyMassCancelLocked passes the outcome of a mass cancel to the kill switch waiting for it
+func (b *Blotter) notifyMassCancelLocked(clOrdID string, accepted bool) {
+	if waiter, found := b.massCancelWaiters[clOrdID]; found {
+		delete(b.massCancelWaiters, clOrdID)
+		waiter <- accepted
+	}
+}
+
+func (b *Blotter) setLoggedOn(session *blotterSession, loggedOn bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	session.loggedOn = loggedOn
+	session.changedAt = time.Now()
+}
+
+func (b *Blotter) setStatus(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status = fmt.Sprintf(format, args...)
+}
+
+func (b *Blotter) send(msg *quickfix.Message) error {
+	if b.oe == nil {
+		return errors.New("no order-entry session, see -f")
+	}
+	msg.Header.Set(field.NewSenderCompID(b.oe.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(b.oeTargetID))
+	return Send(msg)
+}
+
+// cancelSelected cancels the selected working order
+func (b *Blotter) cancelSelected() {
+	b.mu.Lock()
+	selectedID := b.selectedID
+	b.mu.Unlock()
+	order, found := DefaultOrderTracker.Get(selectedID)
+	if !found || !order.IsWorking() {
+		b.setStatus("No working order selected")
+		return
+	}
+
+	if err := b.send(NewOrderCancel(order)); err != nil {
+		b.setStatus("Cancel %s: %v", order.ClOrdID, err)
+		return
+	}
+	b.setStatus("Cancel of %s %s sent", order.ClOrdID, order.Symbol)
+}
+
+// killSwitch cancels all orders with an OrderMassCancelRequest. Every working order is canceled one by one if the
+// mass cancel is rejected or unsupported, i.e. without OrderMassCancelReport in blotterMassCancelTimeout.
+func (b *Blotter) killSwitch() {
+	clOrdID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	massCancel := ordermasscancelrequest.New(
+		field.NewClOrdID(clOrdID),
+		field.NewMassCancelRequestType(enum.MassCancelRequestType_CANCEL_ALL_ORDERS),
+		field.NewTransactTime(time.Now()),
+	)
+
+	waiter := make(chan bool, 1)
+	b.mu.Lock()
+	b.massCancelWaiters[clOrdID] = waiter
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.massCancelWaiters, clOrdID)
+		b.mu.Unlock()
+	}()
+
+	if err := b.send(massCancel.ToMessage()); err != nil {
+		b.setStatus("Kill switch: %v", err)
+		return
+	}
+	b.setStatus("Kill switch: mass cancel sent")
+
+	reason := "rejected"
+	select {
+	case accepted := <-waiter:
+		if accepted {
+			b.setStatus("Kill switch: mass cancel accepted")
+			return
+		}
+	case <-time.After(blotterMassCancelTimeout):
+		reason = fmt.Sprintf("no report in %v", blotterMassCancelTimeout)
+	}
+
+	orders := DefaultOrderTracker.Working()
+	for _, order := range orders {
+		if err := b.send(NewOrderCancel(order)); err != nil {
+			b.setStatus("Kill switch: mass cancel %s, cancel %s: %v", reason, order.ClOrdID, err)
+			return
+		}
+	}
+	b.setStatus("Kill switch: mass cancel %s, %d cancels sent", reason, len(orders))
+}
+
+// onKey handles a key, returns false to quit
+func (b *Blotter) onKey(key string) bool {
+	b.mu.Lock()
+	confirmKill := b.confirmKill
+	b.confirmKill = false
+	b.mu.Unlock()
+
+	if confirmKill {
+		if key == "y" || key == "Y" {
+			go b.killSwitch()
+		} else {
+			b.setStatus("Kill switch aborted")
+		}
+		return true
+	}
+
+	switch key {
+	case "q", "\x03":
+		return false
+	case blotterKeyUp, "k":
+		b.mu.Lock()
+		b.selected = max(0, b.selected-1)
+		b.mu.Unlock()
+	case blotterKeyDown, "j":
+		b.mu.Lock()
+		b.selected++ // clamped by render
+		b.mu.Unlock()
+	case "c":
+		go b.cancelSelected()
+	case "K":
+		b.mu.Lock()
+		b.confirmKill = true
+		b.status = "Kill switch: cancel ALL orders? [y/N]"
+		b.mu.Unlock()
+	}
+	return true
+}
+
+// blotterAge is the time since t in seconds, '-' if never
+func blotterAge(now time.Time, t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return now.Sub(t).Round(time.Second).String()
+}
+
+// render draws the screen of width x height, lists are cut to fit
+func (b *Blotter) render(width int, height int) string {
+	now := time.Now()
+	orders := DefaultOrderTracker.Working()
+	snapshot := b.positions.Snapshot()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.selected = max(0, min(b.selected, len(orders)-1))
+	b.selectedID = ""
+	if len(orders) > 0 {
+		b.selectedID = orders[b.selected].ClOrdID
+	}
+
+	lines := make([]string, 0, height)
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	add("PowerTrade blotter %s   Up/Down select  c cancel  K kill switch  q quit", now.Format("15:04:05"))
+	add("")
+	add("SESSIONS")
+	for _, s := range b.sessions {
+		state := "connecting"
+		if !s.changedAt.IsZero() {
+			state = "logged out " + blotterAge(now, s.changedAt)
+			if s.loggedOn {
+				state = "logged on " + blotterAge(now, s.changedAt)
+			}
+		}
+		add("  %-3s %-40s %-24s heartbeat in %-6s out %-6s last in %-6s out %s", s.name, s.id, state,
+			blotterAge(now, s.heartbeatIn), blotterAge(now, s.heartbeatOut), blotterAge(now, s.lastIn), blotterAge(now, s.lastOut))
+	}
+
+	// the rows left are shared by the lists: orders get 2/5, others 1/5
+	rows := max(0, height-len(lines)-2-4*2)
+	orderRows, otherRows := rows*2/5, rows/5
+
+	add("")
+	add("OPEN ORDERS (%d)", len(orders))
+	first := max(0, b.selected-orderRows+1)
+	for i := first; i < len(orders) && i < first+orderRows; i++ {
+		o := orders[i]
+		line := fmt.Sprintf("  %-20s %-20s %-24s %-4s %14s @ %-14s cum %-12s leaves %-12s %s", o.ClOrdID, o.OrderID,
+			o.Symbol, codeName(scenarioSides, string(o.Side)), o.OrderQty, o.Price, o.CumQty, o.LeavesQty,
+			codeName(replOrdStatuses, string(o.OrdStatus)))
+		if i == b.selected {
+			line = "\x1b[7m" + truncateLine(line, width) + "\x1b[0m"
+		}
+		add("%s", line)
+	}
+
+	add("")
+	add("FILLS (%s)", b.fillsFrom)
+	for i := 0; i < len(b.fills) && i < otherRows; i++ {
+		f := b.fills[i]
+		add("  %s %-20s %-24s %-4s %14s @ %-14s fee %s %s %s", f.ReceivedAt.Format("15:04:05.000"), f.ClOrdID, f.Symbol,
+			codeName(scenarioSides, string(f.Side)), f.LastQty, f.LastPx, f.Fee, f.FeeCurrency, journal.FormatOtherFees(f.OtherFees))
+	}
+
+	add("")
+	unrealized := snapshot.UnrealizedPnL.StringFixed(2)
+	if snapshot.Unmarked > 0 {
+		unrealized += fmt.Sprintf(" (%d unmarked)", snapshot.Unmarked)
+	}
+	add("POSITIONS (%s) realized %s unrealized %s fees %s", snapshot.Method, snapshot.RealizedPnL.StringFixed(2),
+		unrealized, snapshot.Fees.StringFixed(2))
+	for i := 0; i < len(snapshot.Positions) && i < otherRows; i++ {
+		p := snapshot.Positions[i]
+		mark, unrealized := "-", "-"
+		if p.HasMark {
+			mark = p.Mark.String()
+		}
+		if p.HasUnrealized() {
+			unrealized = p.UnrealizedPnL.StringFixed(2)
+		}
+		add("  %-24s %14s avg %-14s mark %-14s realized %-12s unrealized %s", p.Symbol, p.Qty, p.AvgPx.StringFixed(4),
+			mark, p.RealizedPnL.StringFixed(2), unrealized)
+	}
+
+	add("")
+	add("REJECTS")
+	for i := 0; i < len(b.rejects) && i < otherRows; i++ {
+		r := b.rejects[i]
+		add("  %s %-3s %-15s %-28s %s", r.At.Format("15:04:05.000"), r.Session, r.Type, r.ID, r.Text)
+	}
+
+	height = max(height, 2)
+	for len(lines) < height-1 {
+		add("")
+	}
+	lines = append(lines[:height-1], b.status)
+
+	var res strings.Builder
+	res.WriteString("\x1b[H")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "\x1b[7m") {
+			line = truncateLine(line, width)
+		}
+		res.WriteString(line)
+		res.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			res.WriteString("\r\n")
+		}
+	}
+	return res.String()
+}
+
+func truncateLine(line string, width int) string {
+	if len(line) > width {
+		return line[:width]
+	}
+	return line
+}
+
+// readKeys sends keys of the terminal in raw mode, arrows as blotterKeyUp/Down
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for i := 0; i < n; i++ {
+			if buf[i] == 0x1b && i+2 < n && buf[i+1] == '[' {
+				switch buf[i+2] {
+				case 'A':
+					keys <- blotterKeyUp
+				case 'B':
+					keys <- blotterKeyDown
+				}
+				i += 2
+				continue
+			}
+			keys <- string(buf[i])
+		}
+	}
+}
+
+// RunBlotter runs the order-entry session of cfgFileNameOE and/or the drop-copy session of cfgFileNameDC in a terminal UI
+func RunBlotter(cfgFileNameOE string, cfgFileNameDC string, apiKeyName string) error {
+	if cfgFileNameOE == "" && cfgFileNameDC == "" {
+		return errors.New("blotter: an order-entry (-f) and/or a drop-copy (-g) config is required")
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("blotter: stdin is not a terminal")
+	}
+
+	blotter := &Blotter{massCancelWaiters: make(map[string]chan bool)}
+	var apps []blotterApp
+	if cfgFileNameOE != "" {
+		oe, err := NewTradeClient(cfgFileNameOE, apiKeyName)
+		if err != nil {
+			return err
+		}
+		if *recoverOrdersCmd {
+			EnableOrderRecovery(oe)
+		}
+		blotter.oe = oe
+		blotter.oeTargetID, _ = oe.Settings.GlobalSettings().Setting(config.TargetCompID)
+		method, err := positions.ParseMethod(*positionsMethodCmd)
+		if err != nil {
+			return err
+		}
+		blotter.positions = positions.NewEngine(method, DefaultMarks)
+		blotter.fillsFrom = "OE"
+		session := &blotterSession{name: "OE"}
+		blotter.sessions = append(blotter.sessions, session)
+		apps = append(apps, blotterApp{oe, blotter, session, oe.Settings, true})
+	}
+	if cfgFileNameDC != "" {
+		dc, err := NewDropCopyClient(cfgFileNameDC, apiKeyName)
+		if err != nil {
+			return err
+		}
+		if dc.journal != nil {
+			defer dc.journal.Close()
+		}
+		blotter.positions = dc.Positions
+		blotter.fillsFrom = "DC"
+		session := &blotterSession{name: "DC"}
+		blotter.sessions = append(blotter.sessions, session)
+		apps = append(apps, blotterApp{dc, blotter, session, dc.Settings, false})
+	}
+	if len(apps) == 2 {
+		apps[0].applyFills = false
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	// the alternate screen without cursor, the output of sessions is dropped: see -l file
+	screen := os.Stdout
+	fmt.Fprint(screen, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(screen, "\x1b[?25h\x1b[?1049l")
+	restore, err := redirectStdout(io.Discard)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	for _, app := range apps {
+		go func(app blotterApp) {
+			if err := StartConnection(app, app.settings); err != nil {
+				blotter.setStatus("%s: %v", app.session.name, err)
+			}
+		}(app)
+	}
+
+	keys := make(chan string, 16)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(*blotterRefreshCmd)
+	defer ticker.Stop()
+
+	for {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(screen, blotter.render(width, height))
+
+		select {
+		case <-ticker.C:
+		case key, ok := <-keys:
+			if !ok || !blotter.onKey(key) {
+				return nil
+			}
+		}
+	}
+}
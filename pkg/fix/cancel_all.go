@@ -48,7 +48,10 @@ func (e *Canceller) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID)
 
 		fmt.Printf("Sending: %s\n", msg.String())
 
-		quickfix.Send(msg)
+		// in the callback of the drop copy session, not to wait for the throttle
+		if err := SendAsync(msg); err != nil {
+			fmt.Printf("Send: %v\n", err)
+		}
 	}
 	return
 }
@@ -0,0 +1,227 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/datadictionary"
+	"github.com/quickfixgo/tag"
+)
+
+var (
+	decodeDictionaryCmd = flag.String("dd", "", "Decode: dictionary XML (default of -dict)")
+	decodeFormatCmd     = flag.String("df", "pretty", "Decode: output format: pretty/compact/json")
+	decodeMsgTypesCmd   = flag.String("dt", "", "Decode: MsgTypes to print, separated by ','")
+	decodeClOrdIDCmd    = flag.String("dc", "", "Decode: messages of ClOrdID / OrigClOrdID")
+	decodeOrderIDCmd    = flag.String("do", "", "Decode: messages of OrderID")
+	decodeFromCmd       = flag.String("dfrom", "", "Decode: messages sent since (RFC3339 or 2006-01-02)")
+	decodeToCmd         = flag.String("dto", "", "Decode: messages sent before (RFC3339 or 2006-01-02)")
+
+	fixMessageStart = []byte("8=FIX")
+)
+
+// DecodeFilter selects decoded messages, zero values match everything
+type DecodeFilter struct {
+	MsgTypes map[string]bool
+	ClOrdID  string
+	OrderID  string
+	From     time.Time
+	To       time.Time
+}
+
+func (f DecodeFilter) Match(msg *quickfix.Message) bool {
+	if len(f.MsgTypes) > 0 {
+		msgType, _ := msg.MsgType()
+		if !f.MsgTypes[msgType] {
+			return false
+		}
+	}
+	if f.ClOrdID != "" && getString(msg.Body, tag.ClOrdID) != f.ClOrdID && getString(msg.Body, tag.OrigClOrdID) != f.ClOrdID {
+		return false
+	}
+	if f.OrderID != "" && getString(msg.Body, tag.OrderID) != f.OrderID {
+		return false
+	}
+	if !f.From.IsZero() || !f.To.IsZero() {
+		sendingTime, err := msg.Header.GetTime(tag.SendingTime)
+		if err != nil {
+			return false
+		}
+		if !f.From.IsZero() && sendingTime.Before(f.From) {
+			return false
+		}
+		if !f.To.IsZero() && !sendingTime.Before(f.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// ExtractFIX finds raw messages in a line of text (e.g. a log line), fields may be separated by SOH or '|'.
+// BodyLength is recalculated so that messages with masked fields still parse.
+func ExtractFIX(line []byte) [][]byte {
+	messages := make([][]byte, 0)
+	for {
+		start := bytes.Index(line, fixMessageStart)
+		if start < 0 {
+			return messages
+		}
+		line = line[start:]
+
+		delimiter := byte('\x01')
+		if bytes.IndexByte(line, delimiter) < 0 {
+			delimiter = '|'
+		}
+
+		end := bytes.Index(line, []byte{delimiter, '1', '0', '='})
+		if end < 0 {
+			return messages
+		}
+		trailerEnd := bytes.IndexByte(line[end+1:], delimiter)
+		if trailerEnd < 0 {
+			trailerEnd = len(line[end+1:])
+		}
+		raw := line[:end+1+trailerEnd]
+		line = line[end+1+trailerEnd:]
+
+		if delimiter != '\x01' {
+			raw = bytes.ReplaceAll(raw, []byte{delimiter}, []byte{'\x01'})
+		}
+		messages = append(messages, fixBodyLength(raw))
+	}
+}
+
+// fixBodyLength rewrites BodyLength(9) of a raw message ending with CheckSum(10)
+func fixBodyLength(raw []byte) []byte {
+	fields := bytes.SplitN(raw, []byte{'\x01'}, 3)
+	if len(fields) < 3 || !bytes.HasPrefix(fields[1], []byte("9=")) {
+		return raw
+	}
+	rest := fields[2]
+	trailer := bytes.LastIndex(rest, []byte("\x0110="))
+	if trailer < 0 {
+		return raw
+	}
+	trailer++
+
+	fixed := make([]byte, 0, len(raw)+8)
+	fixed = append(fixed, fields[0]...)
+	fixed = append(fixed, fmt.Sprintf("\x019=%d\x01", trailer)...)
+	fixed = append(fixed, rest...)
+	if !bytes.HasSuffix(fixed, []byte{'\x01'}) {
+		fixed = append(fixed, '\x01')
+	}
+	return fixed
+}
+
+// Decoder prints raw messages of any text input with a dictionary
+type Decoder struct {
+	log    BeautyLog
+	filter DecodeFilter
+	out    io.Writer
+}
+
+func NewDecoder(dictionary *datadictionary.DataDictionary, options LogOptions, filter DecodeFilter, out io.Writer) *Decoder {
+	return &Decoder{
+		log:    BeautyLog{sessionName: "decode", dictionary: dictionary, options: options},
+		filter: filter,
+		out:    out,
+	}
+}
+
+// Decode prints matching messages of an input, returns the number of messages found and printed
+func (d *Decoder) Decode(in io.Reader) (found int, printed int, err error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		for _, raw := range ExtractFIX(scanner.Bytes()) {
+			found++
+
+			msg := quickfix.NewMessage()
+			if err := quickfix.ParseMessage(msg, bytes.NewBuffer(raw)); err != nil {
+				fmt.Fprintf(d.out, "Error: %v\n%s\n", err, d.log.BeautifyFIXString(string(raw)))
+				continue
+			}
+			if !d.filter.Match(msg) {
+				continue
+			}
+			// a MsgType missing from the dictionary has no MessageDesc to beautify the body with
+			if msgType, _ := msg.MsgType(); d.log.dictionary.Messages[msgType] == nil {
+				fmt.Fprintf(d.out, "Error: unknown MsgType '%s'\n%s\n", msgType, d.log.BeautifyFIXString(string(raw)))
+				continue
+			}
+
+			text := d.log.BeautifyFIX(raw)
+			if text == nil {
+				continue
+			}
+			printed++
+			fmt.Fprintf(d.out, "%s\n", text)
+		}
+	}
+	return found, printed, scanner.Err()
+}
+
+// RunDecode prints FIX messages found in files given as arguments, or in stdin
+func RunDecode(files []string) error {
+	dictionary, err := AppDictionary()
+	if *decodeDictionaryCmd != "" {
+		var data []byte
+		if data, err = ReadSpecFile(*decodeDictionaryCmd); err == nil {
+			dictionary, err = datadictionary.ParseSrc(bytes.NewReader(data))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	options, err := ParseLogOptions(dictionary, *decodeFormatCmd, *logRedactCmd, "")
+	if err != nil {
+		return err
+	}
+
+	filter := DecodeFilter{
+		MsgTypes: make(map[string]bool),
+		ClOrdID:  *decodeClOrdIDCmd,
+		OrderID:  *decodeOrderIDCmd,
+	}
+	for _, msgType := range strings.Split(*decodeMsgTypesCmd, ",") {
+		if msgType = strings.TrimSpace(msgType); msgType != "" {
+			filter.MsgTypes[msgType] = true
+		}
+	}
+	filter.From, err = parseTimeFlag(*decodeFromCmd)
+	if err != nil {
+		return err
+	}
+	filter.To, err = parseTimeFlag(*decodeToCmd)
+	if err != nil {
+		return err
+	}
+
+	decoder := NewDecoder(dictionary, options, filter, os.Stdout)
+	if len(files) == 0 {
+		_, _, err = decoder.Decode(os.Stdin)
+		return err
+	}
+
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, _, err = decoder.Decode(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package fix
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+func bodyLength(fields ...string) int {
+	return len(strings.Join(fields, "\x01")) + 1
+}
+
+// rawFIX is a FIX 4.4 message of body fields with BodyLength and CheckSum
+func rawFIX(fields ...string) string {
+	msg := fmt.Sprintf("8=FIX.4.4\x019=%d\x01%s\x01", bodyLength(fields...), strings.Join(fields, "\x01"))
+	sum := 0
+	for i := 0; i < len(msg); i++ {
+		sum += int(msg[i])
+	}
+	return fmt.Sprintf("%s10=%03d\x01", msg, sum%256)
+}
+
+func pipes(raw string) string {
+	return strings.ReplaceAll(raw, "\x01", "|")
+}
+
+func TestExtractFIX(t *testing.T) {
+	heartbeat := rawFIX("35=0", "49=CLIENT", "56=PT", "34=2", "52=20240101-00:00:00.000")
+	testRequest := rawFIX("35=1", "49=PT", "56=CLIENT", "34=3", "52=20240101-00:00:01.000", "112=TEST")
+
+	tests := []struct {
+		name string
+		line string
+		want []string
+	}{
+		{"SOH", heartbeat, []string{heartbeat}},
+		{"pipes", pipes(heartbeat), []string{heartbeat}},
+		{"no trailing delimiter", strings.TrimSuffix(pipes(heartbeat), "|"), []string{heartbeat}},
+		{"log prefix", "2024/01/01 00:00:00.000000 FIX.4.4:CLIENT->PT outgoing: " + heartbeat, []string{heartbeat}},
+		{"log prefix and pipes", "<20240101-00:00:00.000, FIX.4.4:CLIENT->PT, outgoing>\n  (" + pipes(heartbeat) + ")", []string{heartbeat}},
+		{"messages on one line", heartbeat + testRequest, []string{heartbeat, testRequest}},
+		{"messages on one line with pipes", pipes(heartbeat) + " " + pipes(testRequest), []string{heartbeat, testRequest}},
+		{"no message", "Logon sent", nil},
+		{"truncated message", "8=FIX.4.4|9=5|35=0|49=CLIENT", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ExtractFIX([]byte(test.line))
+			if len(got) != len(test.want) {
+				t.Fatalf("%d messages %q, want %d", len(got), got, len(test.want))
+			}
+			for i := range got {
+				if string(got[i]) != test.want[i] {
+					t.Errorf("message %d %q, want %q", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractFIXMaskedFields(t *testing.T) {
+	const password = "eyJhbGciOiJFUzI1NiJ9.e30.c2ln"
+	fields := []string{"35=A", "49=CLIENT", "56=PT", "34=1", "52=20240101-00:00:00.000", "98=0", "108=30", "554=" + password}
+	length := bodyLength(fields...)
+	// a masked log line: the field is shorter than in the message BodyLength was computed for
+	masked := strings.Replace(rawFIX(fields...), password, "********", 1)
+	fields[len(fields)-1] = "554=********"
+	want := strings.Replace(masked, fmt.Sprintf("\x019=%d\x01", length), fmt.Sprintf("\x019=%d\x01", bodyLength(fields...)), 1)
+
+	for _, line := range []string{masked, pipes(masked)} {
+		got := ExtractFIX([]byte(line))
+		if len(got) != 1 {
+			t.Fatalf("%d messages in %q", len(got), line)
+		}
+		if string(got[0]) != want {
+			t.Errorf("message %q, want %q", got[0], want)
+		}
+
+		msg := quickfix.NewMessage()
+		if err := quickfix.ParseMessage(msg, bytes.NewBuffer(got[0])); err != nil {
+			t.Fatal(err)
+		}
+		if password := getString(msg.Body, tag.Password); password != "********" {
+			t.Errorf("Password %q", password)
+		}
+	}
+}
+
+func TestFixBodyLength(t *testing.T) {
+	fields := []string{"35=0", "49=CLIENT", "56=PT", "34=2", "52=20240101-00:00:00.000"}
+	heartbeat := rawFIX(fields...)
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"correct", heartbeat, heartbeat},
+		{"wrong", strings.Replace(heartbeat, fmt.Sprintf("9=%d", bodyLength(fields...)), "9=7", 1), heartbeat},
+		{"trailing delimiter added", strings.TrimSuffix(heartbeat, "\x01"), heartbeat},
+		{"no BodyLength", "8=FIX.4.4\x0135=0\x0110=000\x01", "8=FIX.4.4\x0135=0\x0110=000\x01"},
+		{"no CheckSum", "8=FIX.4.4\x019=5\x0135=0\x01", "8=FIX.4.4\x019=5\x0135=0\x01"},
+	}
+	for _, test := range tests {
+		if got := string(fixBodyLength([]byte(test.raw))); got != test.want {
+			t.Errorf("%s: %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package fix
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/datadictionary"
+)
+
+var (
+	appDictionary     *datadictionary.DataDictionary
+	appDictionaryErr  error
+	appDictionaryOnce sync.Once
+)
+
+// AppDictionary returns the PowerTrade dictionary of `-dict`, parsed on first use and shared
+func AppDictionary() (*datadictionary.DataDictionary, error) {
+	appDictionaryOnce.Do(func() {
+		var data []byte
+		data, appDictionaryErr = ReadSpecFile(dictionaryName())
+		if appDictionaryErr == nil {
+			appDictionary, appDictionaryErr = datadictionary.ParseSrc(bytes.NewReader(data))
+		}
+	})
+	return appDictionary, appDictionaryErr
+}
+
+// GroupTemplate builds a template for a repeating group, including nested groups.
+// Templates generated in fix44 packages follow plain FIX 4.4 and miss PowerTrade-specific fields (e.g. PutOrCall).
+func GroupTemplate(groupDef *datadictionary.FieldDef) quickfix.GroupTemplate {
+	template := quickfix.GroupTemplate{}
+	for _, child := range groupDef.Fields {
+		if child.IsGroup() {
+			template = append(template, quickfix.NewRepeatingGroup(quickfix.Tag(child.Tag()), GroupTemplate(child)))
+		} else {
+			template = append(template, quickfix.GroupElement(quickfix.Tag(child.Tag())))
+		}
+	}
+	return template
+}
+
+type groupReader interface {
+	Has(tag quickfix.Tag) bool
+	GetGroup(parser quickfix.FieldGroupReader) quickfix.MessageRejectError
+}
+
+// MessageGroupDef finds a repeating group of a message in the PowerTrade dictionary
+func MessageGroupDef(msgType string, groupTag quickfix.Tag) (*datadictionary.FieldDef, error) {
+	dd, err := AppDictionary()
+	if err != nil {
+		return nil, err
+	}
+
+	msgDef := dd.Messages[msgType]
+	if msgDef == nil {
+		return nil, fmt.Errorf("unknown MsgType '%s'", msgType)
+	}
+
+	groupDef := msgDef.Fields[int(groupTag)]
+	if groupDef == nil || !groupDef.IsGroup() {
+		return nil, fmt.Errorf("no group %d in MsgType '%s'", groupTag, msgType)
+	}
+	return groupDef, nil
+}
+
+// SubGroupDef finds a repeating group nested into another one
+func SubGroupDef(groupDef *datadictionary.FieldDef, groupTag quickfix.Tag) *datadictionary.FieldDef {
+	for _, child := range groupDef.Fields {
+		if child.Tag() == int(groupTag) && child.IsGroup() {
+			return child
+		}
+	}
+	return nil
+}
+
+// ReadGroup parses a repeating group out of a message body or a parent group; a missing group is empty
+func ReadGroup(fm groupReader, groupDef *datadictionary.FieldDef) (*quickfix.RepeatingGroup, error) {
+	groupTag := quickfix.Tag(groupDef.Tag())
+	group := quickfix.NewRepeatingGroup(groupTag, GroupTemplate(groupDef))
+	if !fm.Has(groupTag) {
+		return group, nil
+	}
+	if err := fm.GetGroup(group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// GetMessageGroup reads a top-level repeating group of a message using the PowerTrade dictionary
+func GetMessageGroup(msg *quickfix.Message, groupTag quickfix.Tag) (*quickfix.RepeatingGroup, error) {
+	msgType, rejErr := msg.MsgType()
+	if rejErr != nil {
+		return nil, rejErr
+	}
+
+	groupDef, err := MessageGroupDef(msgType, groupTag)
+	if err != nil {
+		return nil, err
+	}
+	return ReadGroup(msg.Body, groupDef)
+}
+
+// MessageVisitor receives fields of a message in dictionary order
+type MessageVisitor interface {
+	OnField(fieldType *datadictionary.FieldType, value []byte)
+	OnUnknownField(tag quickfix.Tag, value []byte)
+	OnComponentStart(name string)
+	OnComponentEnd(name string)
+	OnGroupStart(fieldType *datadictionary.FieldType, count int)
+	OnGroupEntryStart(index int)
+	OnGroupEntryEnd(index int)
+	OnGroupEnd(fieldType *datadictionary.FieldType)
+}
+
+type fieldMapReader interface {
+	groupReader
+	GetBytes(tag quickfix.Tag) ([]byte, quickfix.MessageRejectError)
+	Tags() []quickfix.Tag
+}
+
+// WalkFieldMap visits fields of parts (components and nested groups included), then fields unknown to the dictionary
+func WalkFieldMap(fm fieldMapReader, parts []datadictionary.MessagePart, v MessageVisitor) error {
+	known := make(map[quickfix.Tag]bool)
+	err := walkParts(fm, parts, v, known)
+	if err != nil {
+		return err
+	}
+
+	unknown := make([]quickfix.Tag, 0)
+	for _, t := range fm.Tags() {
+		if !known[t] {
+			unknown = append(unknown, t)
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i] < unknown[j] })
+	for _, t := range unknown {
+		value, _ := fm.GetBytes(t)
+		v.OnUnknownField(t, value)
+	}
+	return nil
+}
+
+func walkParts(fm fieldMapReader, parts []datadictionary.MessagePart, v MessageVisitor, known map[quickfix.Tag]bool) error {
+	for _, part := range parts {
+		switch p := part.(type) {
+		case datadictionary.Component:
+			if !hasAnyField(fm, p.Fields()) {
+				markKnown(p.Fields(), known)
+				continue
+			}
+			v.OnComponentStart(p.Name())
+			if err := walkParts(fm, p.Parts(), v, known); err != nil {
+				return err
+			}
+			v.OnComponentEnd(p.Name())
+
+		case *datadictionary.FieldDef:
+			tag := quickfix.Tag(p.Tag())
+			known[tag] = true
+			if !fm.Has(tag) {
+				markKnown(p.Fields, known)
+				continue
+			}
+
+			if !p.IsGroup() {
+				value, _ := fm.GetBytes(tag)
+				v.OnField(p.FieldType, value)
+				continue
+			}
+
+			markKnown(p.Fields, known)
+			group, err := ReadGroup(fm, p)
+			if err != nil {
+				return fmt.Errorf("group %s[%d]: %v", p.Name(), p.Tag(), err)
+			}
+			v.OnGroupStart(p.FieldType, group.Len())
+			for i := 0; i < group.Len(); i++ {
+				v.OnGroupEntryStart(i)
+				if err := WalkFieldMap(group.Get(i), p.Parts, v); err != nil {
+					return err
+				}
+				v.OnGroupEntryEnd(i)
+			}
+			v.OnGroupEnd(p.FieldType)
+		}
+	}
+	return nil
+}
+
+func hasAnyField(fm fieldMapReader, fieldDefs []*datadictionary.FieldDef) bool {
+	for _, fieldDef := range fieldDefs {
+		if fm.Has(quickfix.Tag(fieldDef.Tag())) {
+			return true
+		}
+	}
+	return false
+}
+
+func markKnown(fieldDefs []*datadictionary.FieldDef, known map[quickfix.Tag]bool) {
+	for _, fieldDef := range fieldDefs {
+		known[quickfix.Tag(fieldDef.Tag())] = true
+		markKnown(fieldDef.Fields, known)
+	}
+}
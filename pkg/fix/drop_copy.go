@@ -1,17 +1,150 @@
 package fix
 
 import (
+	"flag"
+	"fmt"
 	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/positions"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
 )
 
+var (
+	positionsCmd       = flag.Duration("pos", 0, "Drop copy: print positions every interval, e.g. '10s' (0 = off)")
+	positionsMethodCmd = flag.String("posm", "fifo", "Drop copy: realized PnL method: fifo/avg")
+
+	// DefaultMarks are marks for unrealized PnL: the last price of fills applied to positions,
+	// a market-data client or an external price feed may Set them too
+	DefaultMarks = positions.NewMarks()
+)
+
+// DropCopyClient journals ExecutionReports of the drop-copy session and keeps positions
+type DropCopyClient struct {
+	*TradeClient
+	journal   *journal.Journal
+	Positions *positions.Engine
+}
+
+func NewDropCopyClient(cfgFileName string, apiKeyName string) (*DropCopyClient, error) {
+	tapp, err := NewTradeClient(cfgFileName, apiKeyName)
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := positions.ParseMethod(*positionsMethodCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &DropCopyClient{
+		TradeClient: tapp,
+		Positions:   positions.NewEngine(method, DefaultMarks),
+	}
+	if *journalCmd != "" {
+		app.journal, err = journal.Open(*journalCmd)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Journal: %s\n", *journalCmd)
+	}
+	return app, nil
+}
+
+func (e *DropCopyClient) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	reject = e.TradeClient.FromApp(msg, sessionID)
+
+	msgType, _ := msg.MsgType()
+	if enum.MsgType(msgType) != enum.MsgType_EXECUTION_REPORT {
+		return
+	}
+
+	exec := DecodeExecution(msg, time.Now())
+	if e.journal != nil {
+		inserted, err := e.journal.Record(exec)
+		if err != nil {
+			e.printf("Journal: %v\n", err)
+		} else if !inserted {
+			e.printf("Journal: duplicated ExecID %s\n", exec.ExecID)
+			return
+		}
+	}
+
+	applyPositionFills(e.Positions, exec)
+	return
+}
+
+// applyPositionFills applies the fills of exec to engine and marks their symbols at the fill price
+func applyPositionFills(engine *positions.Engine, exec *journal.Execution) {
+	for _, fill := range PositionFills(exec) {
+		engine.Apply(fill)
+		if fill.Px.IsPositive() {
+			DefaultMarks.Set(fill.Symbol, fill.Px)
+		}
+	}
+}
+
+// PositionFills splits a fill of a multileg order into fills of its legs
+func PositionFills(exec *journal.Execution) []positions.Fill {
+	if !exec.IsFill() {
+		return nil
+	}
+
+	transactTime := exec.TransactTime
+	if transactTime.IsZero() {
+		transactTime = exec.ReceivedAt
+	}
+
+	if len(exec.Legs) == 0 {
+		return []positions.Fill{{
+			ExecID: exec.ExecID,
+			Symbol: exec.Symbol,
+			Buy:    exec.Side == enum.Side_BUY,
+			Qty:    exec.LastQty,
+			Px:     exec.LastPx,
+			Fee:    exec.Fee,
+			Time:   transactTime,
+		}}
+	}
+
+	fills := make([]positions.Fill, 0, len(exec.Legs))
+	for i, leg := range exec.Legs {
+		fill := positions.Fill{
+			ExecID: fmt.Sprintf("%s/%d", exec.ExecID, i),
+			Symbol: leg.Symbol,
+			Buy:    leg.Side == enum.Side_BUY,
+			Qty:    leg.Qty,
+			Px:     leg.Px,
+			Time:   transactTime,
+		}
+		// Fees are charged for the whole strategy
+		if i == 0 {
+			fill.Fee = exec.Fee
+		}
+		fills = append(fills, fill)
+	}
+	return fills
+}
+
 func RunDropCopy(cfgFileName string, apiKeyName string) error {
-	app, err := NewTradeClient(cfgFileName, apiKeyName)
+	app, err := NewDropCopyClient(cfgFileName, apiKeyName)
 	if err != nil {
 		return err
 	}
+	if app.journal != nil {
+		defer app.journal.Close()
+	}
 
 	StartConnection(app, app.Settings)
 
+	if *positionsCmd > 0 {
+		for {
+			time.Sleep(*positionsCmd)
+			fmt.Printf("\n%s\n", app.Positions.Snapshot())
+		}
+	}
+
 	for {
 		time.Sleep(time.Second)
 	}
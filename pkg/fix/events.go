@@ -0,0 +1,120 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/quickfix"
+)
+
+var (
+	eventsCmd = flag.String("e", "", "Events: NDJSON of application messages to '-' (stdout), a file or 'unix:<socket>'")
+
+	defaultEvents     *EventStream
+	defaultEventsErr  error
+	defaultEventsOnce sync.Once
+)
+
+// EventStream writes one JSON object per application message
+type EventStream struct {
+	mu     sync.Mutex
+	target string // file or socket reopened after errors, empty for an injected writer
+	w      io.Writer
+	stdout bool // events own the stdout of the process, see TradeClient.out
+}
+
+// DefaultEventStream is shared by all sessions of the process, nil if events are off
+func DefaultEventStream() (*EventStream, error) {
+	defaultEventsOnce.Do(func() {
+		if *eventsCmd != "" {
+			defaultEvents, defaultEventsErr = NewEventStream(*eventsCmd, os.Stdout)
+		}
+	})
+	return defaultEvents, defaultEventsErr
+}
+
+// NewEventStream writes events to stdout for '-', else to a file or 'unix:<socket>'
+func NewEventStream(target string, stdout io.Writer) (*EventStream, error) {
+	if target == "-" {
+		s := NewEventWriter(stdout)
+		s.stdout = true
+		return s, nil
+	}
+
+	s := &EventStream{target: target}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewEventWriter writes events to w, which is written a whole line at a time
+func NewEventWriter(w io.Writer) *EventStream {
+	return &EventStream{w: w}
+}
+
+func (s *EventStream) open() error {
+	if path, isSocket := strings.CutPrefix(s.target, "unix:"); isSocket {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return fmt.Errorf("events: %v", err)
+		}
+		s.w = conn
+		return nil
+	}
+
+	file, err := os.OpenFile(s.target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("events: %v", err)
+	}
+	s.w = file
+	return nil
+}
+
+func (s *EventStream) printError(err error) {
+	if s.stdout {
+		fmt.Fprintf(os.Stderr, "Events: %v\n", err)
+		return
+	}
+	fmt.Printf("Events: %v\n", err)
+}
+
+// Publish writes a message, a dropped socket is reopened on the next message
+func (s *EventStream) Publish(msg *quickfix.Message, sessionID quickfix.SessionID, direction string, at time.Time) {
+	dictionary, err := AppDictionary()
+	if err != nil {
+		s.printError(err)
+		return
+	}
+
+	line, err := MessageEvent(dictionary, msg, sessionID.String(), direction, at)
+	if err != nil {
+		s.printError(err)
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w == nil {
+		if err := s.open(); err != nil {
+			return
+		}
+	}
+
+	_, err = s.w.Write(line)
+	if err != nil {
+		s.printError(err)
+		if closer, ok := s.w.(io.Closer); ok && s.target != "" {
+			closer.Close()
+			s.w = nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package fix
+
+import (
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+type fieldReader interface {
+	Has(tag quickfix.Tag) bool
+	GetString(tag quickfix.Tag) (string, quickfix.MessageRejectError)
+	GetInt(tag quickfix.Tag) (int, quickfix.MessageRejectError)
+	GetBytes(tag quickfix.Tag) ([]byte, quickfix.MessageRejectError)
+}
+
+// getString returns an empty string for a missing field
+func getString(fm fieldReader, tag quickfix.Tag) string {
+	value, _ := fm.GetString(tag)
+	return value
+}
+
+// getBytes returns the value of a field without a copy, nil for a missing field
+func getBytes(fm fieldReader, tag quickfix.Tag) []byte {
+	value, _ := fm.GetBytes(tag)
+	return value
+}
+
+// isZeroDecimal is true for a decimal value like "0", "-0.00" or "", parsing it without allocations
+func isZeroDecimal(value []byte) bool {
+	for i, c := range value {
+		switch {
+		case c == '0' || c == '.':
+		case (c == '-' || c == '+') && i == 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// getDecimal returns zero for a missing or malformed field
+func getDecimal(fm fieldReader, tag quickfix.Tag) decimal.Decimal {
+	value, err := fm.GetString(tag)
+	if err != nil {
+		return decimal.Zero
+	}
+	d, _ := decimal.NewFromString(value)
+	return d
+}
+
+// getInt returns -1 for a missing or malformed field
+func getInt(fm fieldReader, tag quickfix.Tag) int {
+	value, err := fm.GetInt(tag)
+	if err != nil {
+		return -1
+	}
+	return value
+}
+
+// scaleOf is the count of decimal places of a value, e.g. to keep "0.01" as is in a field
+func scaleOf(d decimal.Decimal) int32 {
+	if d.Exponent() >= 0 {
+		return 0
+	}
+	return -d.Exponent()
+}
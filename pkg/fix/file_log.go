@@ -0,0 +1,177 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/logfile"
+	"github.com/quickfixgo/quickfix"
+)
+
+var (
+	logDirCmd      = flag.String("ld", "logs", "Log: directory of '-l file' logs")
+	logMaxSizeCmd  = flag.Int64("lsize", 100, "Log: rotate files larger than this many MB (0 = no limit)")
+	logIntervalCmd = flag.Duration("lrot", 24*time.Hour, "Log: rotate files older than this (0 = never)")
+	logGzipCmd     = flag.Bool("lgz", true, "Log: gzip rotated files")
+	logKeepCmd     = flag.Int("lkeep", 10, "Log: rotated files to keep per log (0 = all)")
+	logMaxAgeCmd   = flag.Duration("lage", 0, "Log: remove rotated files older than this (0 = never)")
+	logRawCmd      = flag.Bool("lraw", false, "Log: also write raw FIX messages to separate files")
+
+	logWriters   = make(map[string]*logfile.Writer) // path -> writer shared by all initiators
+	logWritersMu sync.Mutex
+)
+
+// rawLog is implemented by logs writing raw messages next to beautified ones
+type rawLog interface {
+	OnIncomingRaw(raw []byte)
+	OnOutgoingRaw(raw []byte)
+}
+
+// FileLogFactory writes messages and events of each session to `<dir>/<session>.messages.log` / `.event.log`,
+// raw messages optionally to `<session>.raw.log`
+type FileLogFactory struct {
+	dir     string
+	options logfile.Options
+	raw     bool
+}
+
+func NewFileLogFactory(dir string, options logfile.Options, raw bool) FileLogFactory {
+	return FileLogFactory{dir: dir, options: options, raw: raw}
+}
+
+// NewFileLogFactoryFromFlags uses `-ld`, `-lsize`, `-lrot`, `-lgz`, `-lkeep`, `-lage` and `-lraw`
+func NewFileLogFactoryFromFlags() FileLogFactory {
+	return NewFileLogFactory(*logDirCmd, logfile.Options{
+		MaxSize:    *logMaxSizeCmd * 1024 * 1024,
+		Interval:   *logIntervalCmd,
+		Compress:   *logGzipCmd,
+		MaxBackups: *logKeepCmd,
+		MaxAge:     *logMaxAgeCmd,
+	}, *logRawCmd)
+}
+
+func (f FileLogFactory) Create() (quickfix.Log, error) {
+	return f.create("GLOBAL")
+}
+
+func (f FileLogFactory) CreateSessionLog(sessionID quickfix.SessionID) (quickfix.Log, error) {
+	return f.create(sessionFileName(sessionID))
+}
+
+// sessionFileName is e.g. `FIX.4.4-SENDER-TARGET`
+func sessionFileName(sessionID quickfix.SessionID) string {
+	return strings.NewReplacer(":", "-", "->", "-", "/", "_").Replace(sessionID.String())
+}
+
+func (f FileLogFactory) create(name string) (quickfix.Log, error) {
+	var err error
+	log := &fileLog{}
+
+	log.messages, err = f.writer(name + ".messages.log")
+	if err != nil {
+		return nil, err
+	}
+	log.events, err = f.writer(name + ".event.log")
+	if err != nil {
+		return nil, err
+	}
+	if f.raw {
+		log.raw, err = f.writer(name + ".raw.log")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return log, nil
+}
+
+func (f FileLogFactory) writer(name string) (*logfile.Writer, error) {
+	path := filepath.Join(f.dir, name)
+
+	logWritersMu.Lock()
+	defer logWritersMu.Unlock()
+
+	if w, found := logWriters[path]; found {
+		return w, nil
+	}
+	w, err := logfile.Open(path, f.options)
+	if err != nil {
+		return nil, fmt.Errorf("log: %v", err)
+	}
+	logWriters[path] = w
+	return w, nil
+}
+
+// CloseLogFiles flushes and closes all log files, rotated files are compressed before it returns
+func CloseLogFiles() {
+	logWritersMu.Lock()
+	defer logWritersMu.Unlock()
+
+	for path, w := range logWriters {
+		w.Close()
+		delete(logWriters, path)
+	}
+}
+
+// CloseLogFilesOnSignal closes log files and exits on SIGINT/SIGTERM, for modes running until they are killed
+func CloseLogFilesOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		CloseLogFiles()
+		fmt.Fprintf(os.Stderr, "%v: log files closed\n", sig)
+		os.Exit(1)
+	}()
+}
+
+type fileLog struct {
+	messages *logfile.Writer
+	events   *logfile.Writer
+	raw      *logfile.Writer // nil if raw messages are not logged
+}
+
+func writeLogLine(w *logfile.Writer, direction string, s []byte) {
+	line := make([]byte, 0, len(s)+48)
+	line = append(line, time.Now().UTC().Format("2006-01-02T15:04:05.000000Z")...)
+	line = append(line, ' ')
+	line = append(line, direction...)
+	line = append(line, ' ')
+	line = append(line, s...)
+	line = append(line, '\n')
+	w.Write(line)
+}
+
+func (l *fileLog) OnIncoming(s []byte) {
+	writeLogLine(l.messages, "incoming", s)
+}
+
+func (l *fileLog) OnOutgoing(s []byte) {
+	writeLogLine(l.messages, "outgoing", s)
+}
+
+func (l *fileLog) OnEvent(s string) {
+	writeLogLine(l.events, "event", []byte(s))
+}
+
+func (l *fileLog) OnEventf(format string, a ...interface{}) {
+	l.OnEvent(fmt.Sprintf(format, a...))
+}
+
+func (l *fileLog) OnIncomingRaw(raw []byte) {
+	if l.raw != nil {
+		writeLogLine(l.raw, "incoming", raw)
+	}
+}
+
+func (l *fileLog) OnOutgoingRaw(raw []byte) {
+	if l.raw != nil {
+		writeLogLine(l.raw, "outgoing", raw)
+	}
+}
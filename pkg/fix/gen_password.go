@@ -9,7 +9,7 @@ import (
 )
 
 func RunGeneratePassword(cfgFilename string, apiKeyName string, dur time.Duration) error {
-	apiKey, privateKey, err := pt.ReadKeys(apiKeyName)
+	apiKey, privateKey, err := pt.ReadKeysFrom(*keysDirCmd, apiKeyName)
 	if err != nil {
 		return err
 	}
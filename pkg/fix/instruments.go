@@ -0,0 +1,180 @@
+package fix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	DefaultInstruments = NewInstruments()
+)
+
+// Instrument is a tradable symbol as reported by SecurityList / SecurityDefinition
+type Instrument struct {
+	Symbol             string
+	SecurityType       enum.SecurityType
+	Underlying         string
+	Currency           string
+	PutOrCall          enum.PutOrCall // empty if not an option
+	StrikePrice        decimal.Decimal
+	MaturityDate       string
+	ContractMultiplier decimal.Decimal
+	RoundLot           decimal.Decimal
+	MinTradeVol        decimal.Decimal
+}
+
+func (i *Instrument) IsOption() bool {
+	return i.SecurityType == enum.SecurityType_OPTION || i.PutOrCall != ""
+}
+
+// IsSpot is true unless the instrument is an option, a future or a perpetual
+func (i *Instrument) IsSpot() bool {
+	switch {
+	case i.IsOption(), i.SecurityType == enum.SecurityType_FUTURE, i.MaturityDate != "", strings.HasSuffix(i.Symbol, "-PERPETUAL"):
+		return false
+	}
+	return true
+}
+
+func (i *Instrument) IsCall() bool {
+	return i.PutOrCall == enum.PutOrCall_CALL
+}
+
+func (i *Instrument) IsPut() bool {
+	return i.PutOrCall == enum.PutOrCall_PUT
+}
+
+// Instruments is a cache of instruments filled from SecurityList / SecurityDefinition messages
+type Instruments struct {
+	mu       sync.RWMutex
+	bySymbol map[string]*Instrument
+	loaded   chan struct{} // closed on the last fragment of the first SecurityList
+	loadOnce sync.Once
+}
+
+func NewInstruments() *Instruments {
+	return &Instruments{
+		bySymbol: make(map[string]*Instrument),
+		loaded:   make(chan struct{}),
+	}
+}
+
+func (i *Instruments) Get(symbol string) (*Instrument, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	instrument, found := i.bySymbol[symbol]
+	return instrument, found
+}
+
+func (i *Instruments) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.bySymbol)
+}
+
+// Symbols returns all known symbols sorted alphabetically
+func (i *Instruments) Symbols() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	symbols := make([]string, 0, len(i.bySymbol))
+	for symbol := range i.bySymbol {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (i *Instruments) Add(instrument *Instrument) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.bySymbol[instrument.Symbol] = instrument
+}
+
+// WaitLoaded waits for the last fragment of a SecurityList
+func (i *Instruments) WaitLoaded(timeout time.Duration) bool {
+	select {
+	case <-i.loaded:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// FromApp updates the cache from SecurityList / SecurityDefinition, other messages are ignored
+func (i *Instruments) FromApp(msg *quickfix.Message) error {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_SECURITY_LIST:
+		return i.onSecurityList(msg)
+	case enum.MsgType_SECURITY_DEFINITION:
+		instrument := instrumentFromFields(msg.Body, nil)
+		if instrument.Symbol != "" {
+			i.Add(instrument)
+		}
+	}
+	return nil
+}
+
+func (i *Instruments) onSecurityList(msg *quickfix.Message) error {
+	groupDef, err := MessageGroupDef(string(enum.MsgType_SECURITY_LIST), tag.NoRelatedSym)
+	if err != nil {
+		return err
+	}
+	group, err := ReadGroup(msg.Body, groupDef)
+	if err != nil {
+		return fmt.Errorf("SecurityList: %v", err)
+	}
+
+	underlyingsDef := SubGroupDef(groupDef, tag.NoUnderlyings)
+	for idx := 0; idx < group.Len(); idx++ {
+		g := group.Get(idx)
+
+		var underlyings *quickfix.RepeatingGroup
+		if underlyingsDef != nil {
+			underlyings, _ = ReadGroup(g, underlyingsDef)
+		}
+
+		instrument := instrumentFromFields(g, underlyings)
+		if instrument.Symbol != "" {
+			i.Add(instrument)
+		}
+	}
+
+	// Absent LastFragment means the list is not fragmented
+	lastFragment, err := msg.Body.GetBool(tag.LastFragment)
+	if err != nil || lastFragment {
+		i.loadOnce.Do(func() { close(i.loaded) })
+	}
+	return nil
+}
+
+func instrumentFromFields(fm fieldReader, underlyings *quickfix.RepeatingGroup) *Instrument {
+	instrument := &Instrument{
+		Symbol:             getString(fm, tag.Symbol),
+		SecurityType:       enum.SecurityType(getString(fm, tag.SecurityType)),
+		Currency:           getString(fm, tag.Currency),
+		PutOrCall:          enum.PutOrCall(getString(fm, tag.PutOrCall)),
+		StrikePrice:        getDecimal(fm, tag.StrikePrice),
+		MaturityDate:       getString(fm, tag.MaturityDate),
+		ContractMultiplier: getDecimal(fm, tag.ContractMultiplier),
+		RoundLot:           getDecimal(fm, tag.RoundLot),
+		MinTradeVol:        getDecimal(fm, tag.MinTradeVol),
+	}
+
+	if underlyings != nil && underlyings.Len() > 0 {
+		instrument.Underlying = getString(underlyings.Get(0), tag.UnderlyingSymbol)
+	}
+	if instrument.Underlying == "" {
+		// e.g. BTC-USD-PERPETUAL -> BTC
+		instrument.Underlying = strings.Split(instrument.Symbol, "-")[0]
+	}
+	return instrument
+}
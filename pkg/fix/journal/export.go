@@ -0,0 +1,69 @@
+package journal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var csvHeader = []string{
+	"exec_id", "order_id", "cl_ord_id", "symbol", "side", "last_qty", "last_px", "fee", "fee_currency", "transact_time", "legs", "other_fees",
+}
+
+// FormatOtherFees packs fees in other currencies as `currency:amount` separated by `;`, sorted by currency
+func FormatOtherFees(fees map[string]decimal.Decimal) string {
+	res := make([]string, 0, len(fees))
+	for currency, amount := range fees {
+		res = append(res, currency+":"+amount.String())
+	}
+	sort.Strings(res)
+	return strings.Join(res, ";")
+}
+
+// WriteCSV exports fills, legs are packed as `symbol:side:qty@px` separated by `;`, other fees as FormatOtherFees
+func WriteCSV(w io.Writer, fills []*Fill) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, fill := range fills {
+		legs := make([]string, 0, len(fill.Legs))
+		for _, leg := range fill.Legs {
+			legs = append(legs, leg.Symbol+":"+string(leg.Side)+":"+leg.Qty.String()+"@"+leg.Px.String())
+		}
+
+		err := writer.Write([]string{
+			fill.ExecID,
+			fill.OrderID,
+			fill.ClOrdID,
+			fill.Symbol,
+			string(fill.Side),
+			fill.LastQty.String(),
+			fill.LastPx.String(),
+			fill.Fee.String(),
+			fill.FeeCurrency,
+			fill.TransactTime.Format(time.RFC3339Nano),
+			strings.Join(legs, ";"),
+			FormatOtherFees(fill.OtherFees),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON exports fills as a JSON array
+func WriteJSON(w io.Writer, fills []*Fill) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(fills)
+}
@@ -0,0 +1,399 @@
+// Package journal persists ExecutionReports of the drop-copy session into an embedded SQLite database
+package journal
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS executions (
+	exec_id       TEXT PRIMARY KEY,
+	order_id      TEXT NOT NULL,
+	cl_ord_id     TEXT NOT NULL,
+	exec_type     TEXT NOT NULL,
+	ord_status    TEXT NOT NULL,
+	symbol        TEXT NOT NULL,
+	transact_time INTEGER NOT NULL,
+	received_at   INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS orders (
+	order_id      TEXT PRIMARY KEY,
+	cl_ord_id     TEXT NOT NULL,
+	symbol        TEXT NOT NULL,
+	side          TEXT NOT NULL,
+	ord_type      TEXT NOT NULL,
+	order_qty     TEXT NOT NULL,
+	price         TEXT NOT NULL,
+	ord_status    TEXT NOT NULL,
+	cum_qty       TEXT NOT NULL,
+	leaves_qty    TEXT NOT NULL,
+	avg_px        TEXT NOT NULL,
+	created_at    INTEGER NOT NULL,
+	updated_at    INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS fills (
+	exec_id       TEXT PRIMARY KEY,
+	order_id      TEXT NOT NULL,
+	cl_ord_id     TEXT NOT NULL,
+	symbol        TEXT NOT NULL,
+	side          TEXT NOT NULL,
+	last_qty      TEXT NOT NULL,
+	last_px       TEXT NOT NULL,
+	fee           TEXT NOT NULL,
+	fee_currency  TEXT NOT NULL,
+	transact_time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS fills_time ON fills (transact_time);
+CREATE INDEX IF NOT EXISTS fills_symbol ON fills (symbol, transact_time);
+CREATE INDEX IF NOT EXISTS fills_order ON fills (order_id);
+CREATE TABLE IF NOT EXISTS fill_legs (
+	exec_id       TEXT NOT NULL,
+	leg_index     INTEGER NOT NULL,
+	symbol        TEXT NOT NULL,
+	side          TEXT NOT NULL,
+	qty           TEXT NOT NULL,
+	px            TEXT NOT NULL,
+	PRIMARY KEY (exec_id, leg_index)
+);
+CREATE TABLE IF NOT EXISTS fill_fees (
+	exec_id       TEXT NOT NULL,
+	currency      TEXT NOT NULL,
+	amount        TEXT NOT NULL,
+	PRIMARY KEY (exec_id, currency)
+);
+CREATE TABLE IF NOT EXISTS cancels (
+	exec_id       TEXT PRIMARY KEY,
+	order_id      TEXT NOT NULL,
+	cl_ord_id     TEXT NOT NULL,
+	symbol        TEXT NOT NULL,
+	text          TEXT NOT NULL,
+	transact_time INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS rejects (
+	exec_id       TEXT PRIMARY KEY,
+	order_id      TEXT NOT NULL,
+	cl_ord_id     TEXT NOT NULL,
+	symbol        TEXT NOT NULL,
+	reason        TEXT NOT NULL,
+	text          TEXT NOT NULL,
+	transact_time INTEGER NOT NULL
+);
+`
+
+// LegFill is a fill of one leg of a multileg order
+type LegFill struct {
+	Symbol string          `json:"symbol"`
+	Side   enum.Side       `json:"side"`
+	Qty    decimal.Decimal `json:"qty"`
+	Px     decimal.Decimal `json:"px"`
+}
+
+// Execution is a decoded ExecutionReport
+type Execution struct {
+	ExecID       string
+	OrderID      string
+	ClOrdID      string
+	ExecType     enum.ExecType
+	OrdStatus    enum.OrdStatus
+	Symbol       string
+	Side         enum.Side
+	OrdType      enum.OrdType
+	OrderQty     decimal.Decimal
+	Price        decimal.Decimal
+	LastQty      decimal.Decimal
+	LastPx       decimal.Decimal
+	CumQty       decimal.Decimal
+	LeavesQty    decimal.Decimal
+	AvgPx        decimal.Decimal
+	Fee          decimal.Decimal
+	FeeCurrency  string
+	OtherFees    map[string]decimal.Decimal // by currency, fees charged in other currencies than FeeCurrency
+	OrdRejReason string
+	Text         string
+	TransactTime time.Time
+	ReceivedAt   time.Time
+	Legs         []LegFill
+
+	TradeCapture bool // from a TradeCaptureReport, which carries no order state
+}
+
+func (e *Execution) IsFill() bool {
+	return e.ExecType == enum.ExecType_TRADE || e.LastQty.IsPositive()
+}
+
+// Fill is a journaled fill
+type Fill struct {
+	ExecID       string          `json:"exec_id"`
+	OrderID      string          `json:"order_id"`
+	ClOrdID      string          `json:"cl_ord_id"`
+	Symbol       string          `json:"symbol"`
+	Side         enum.Side       `json:"side"`
+	LastQty      decimal.Decimal `json:"last_qty"`
+	LastPx       decimal.Decimal `json:"last_px"`
+	Fee          decimal.Decimal `json:"fee"`
+	FeeCurrency  string          `json:"fee_currency"`
+	TransactTime time.Time       `json:"transact_time"`
+	Legs         []LegFill       `json:"legs"`
+
+	OtherFees map[string]decimal.Decimal `json:"other_fees,omitempty"` // by currency
+}
+
+// Fill returns the fill part of an execution
+func (e *Execution) Fill() *Fill {
+	return &Fill{
+		ExecID:       e.ExecID,
+		OrderID:      e.OrderID,
+		ClOrdID:      e.ClOrdID,
+		Symbol:       e.Symbol,
+		Side:         e.Side,
+		LastQty:      e.LastQty,
+		LastPx:       e.LastPx,
+		Fee:          e.Fee,
+		FeeCurrency:  e.FeeCurrency,
+		TransactTime: e.TransactTime,
+		Legs:         e.Legs,
+		OtherFees:    e.OtherFees,
+	}
+}
+
+// FillFilter selects fills, zero values match everything
+type FillFilter struct {
+	From    time.Time
+	To      time.Time
+	Symbol  string
+	OrderID string // OrderID or ClOrdID
+}
+
+type Journal struct {
+	db *sql.DB
+}
+
+func Open(path string) (*Journal, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open journal '%s': %v", path, err)
+	}
+	// SQLite allows a single writer anyway
+	db.SetMaxOpenConns(1)
+
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("journal '%s': %v", path, err)
+		}
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("journal '%s' schema: %v", path, err)
+	}
+	return &Journal{db: db}, nil
+}
+
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+// Record stores an execution, returns false if its ExecID was already journaled (resend, reconnect)
+func (j *Journal) Record(e *Execution) (bool, error) {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	transactTime := e.TransactTime.UnixMicro()
+	if e.TransactTime.IsZero() {
+		transactTime = e.ReceivedAt.UnixMicro()
+	}
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO executions VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ExecID, e.OrderID, e.ClOrdID, string(e.ExecType), string(e.OrdStatus), e.Symbol, transactTime, e.ReceivedAt.UnixMicro(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("journal execution %s: %v", e.ExecID, err)
+	}
+	if inserted, _ := res.RowsAffected(); inserted == 0 {
+		return false, nil
+	}
+
+	// a trade capture has no order state, it would overwrite the order journaled from drop copy with blanks
+	if !e.TradeCapture {
+		_, err = tx.Exec(`INSERT INTO orders VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (order_id) DO UPDATE SET
+				ord_status = excluded.ord_status,
+				cum_qty    = excluded.cum_qty,
+				leaves_qty = excluded.leaves_qty,
+				avg_px     = excluded.avg_px,
+				price      = CASE WHEN excluded.price = '0' THEN price ELSE excluded.price END,
+				order_qty  = CASE WHEN excluded.order_qty = '0' THEN order_qty ELSE excluded.order_qty END,
+				updated_at = excluded.updated_at
+			WHERE excluded.updated_at >= updated_at`,
+			e.OrderID, e.ClOrdID, e.Symbol, string(e.Side), string(e.OrdType), e.OrderQty.String(), e.Price.String(),
+			string(e.OrdStatus), e.CumQty.String(), e.LeavesQty.String(), e.AvgPx.String(), transactTime, transactTime,
+		)
+		if err != nil {
+			return false, fmt.Errorf("journal order %s: %v", e.OrderID, err)
+		}
+	}
+
+	switch {
+	case e.IsFill():
+		_, err = tx.Exec(`INSERT INTO fills VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.ExecID, e.OrderID, e.ClOrdID, e.Symbol, string(e.Side), e.LastQty.String(), e.LastPx.String(),
+			e.Fee.String(), e.FeeCurrency, transactTime,
+		)
+		for i, leg := range e.Legs {
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec(`INSERT INTO fill_legs VALUES (?, ?, ?, ?, ?, ?)`,
+				e.ExecID, i, leg.Symbol, string(leg.Side), leg.Qty.String(), leg.Px.String(),
+			)
+		}
+		for currency, amount := range e.OtherFees {
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec(`INSERT INTO fill_fees VALUES (?, ?, ?)`, e.ExecID, currency, amount.String())
+		}
+	case e.ExecType == enum.ExecType_CANCELED:
+		_, err = tx.Exec(`INSERT INTO cancels VALUES (?, ?, ?, ?, ?, ?)`,
+			e.ExecID, e.OrderID, e.ClOrdID, e.Symbol, e.Text, transactTime,
+		)
+	case e.ExecType == enum.ExecType_REJECTED:
+		_, err = tx.Exec(`INSERT INTO rejects VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			e.ExecID, e.OrderID, e.ClOrdID, e.Symbol, e.OrdRejReason, e.Text, transactTime,
+		)
+	}
+	if err != nil {
+		return false, fmt.Errorf("journal execution %s: %v", e.ExecID, err)
+	}
+
+	return true, tx.Commit()
+}
+
+// Fills returns fills matching the filter ordered by TransactTime
+func (j *Journal) Fills(filter FillFilter) ([]*Fill, error) {
+	conds := []string{"1=1"}
+	args := []any{}
+	if !filter.From.IsZero() {
+		conds = append(conds, "transact_time >= ?")
+		args = append(args, filter.From.UnixMicro())
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "transact_time < ?")
+		args = append(args, filter.To.UnixMicro())
+	}
+	if filter.Symbol != "" {
+		conds = append(conds, "symbol = ?")
+		args = append(args, filter.Symbol)
+	}
+	if filter.OrderID != "" {
+		conds = append(conds, "(order_id = ? OR cl_ord_id = ?)")
+		args = append(args, filter.OrderID, filter.OrderID)
+	}
+
+	where := strings.Join(conds, " AND ")
+	rows, err := j.db.Query(`SELECT exec_id, order_id, cl_ord_id, symbol, side, last_qty, last_px, fee, fee_currency, transact_time
+		FROM fills WHERE `+where+` ORDER BY transact_time, exec_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fills := make([]*Fill, 0)
+	byExecID := make(map[string]*Fill)
+	for rows.Next() {
+		var fill Fill
+		var side, lastQty, lastPx, fee string
+		var transactTime int64
+		err := rows.Scan(&fill.ExecID, &fill.OrderID, &fill.ClOrdID, &fill.Symbol, &side, &lastQty, &lastPx, &fee, &fill.FeeCurrency, &transactTime)
+		if err != nil {
+			return nil, err
+		}
+		fill.Side = enum.Side(side)
+		fill.LastQty, _ = decimal.NewFromString(lastQty)
+		fill.LastPx, _ = decimal.NewFromString(lastPx)
+		fill.Fee, _ = decimal.NewFromString(fee)
+		fill.TransactTime = time.UnixMicro(transactTime).UTC()
+		fills = append(fills, &fill)
+		byExecID[fill.ExecID] = &fill
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := j.loadLegs(where, args, byExecID); err != nil {
+		return nil, err
+	}
+	return fills, j.loadOtherFees(where, args, byExecID)
+}
+
+// loadLegs adds legs of the fills selected by where
+func (j *Journal) loadLegs(where string, args []any, byExecID map[string]*Fill) error {
+	if len(byExecID) == 0 {
+		return nil
+	}
+
+	rows, err := j.db.Query(`SELECT exec_id, symbol, side, qty, px FROM fill_legs
+		WHERE exec_id IN (SELECT exec_id FROM fills WHERE `+where+`) ORDER BY exec_id, leg_index`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var execID, side, qty, px string
+		var leg LegFill
+		if err := rows.Scan(&execID, &leg.Symbol, &side, &qty, &px); err != nil {
+			return err
+		}
+		fill := byExecID[execID]
+		if fill == nil {
+			continue
+		}
+		leg.Side = enum.Side(side)
+		leg.Qty, _ = decimal.NewFromString(qty)
+		leg.Px, _ = decimal.NewFromString(px)
+		fill.Legs = append(fill.Legs, leg)
+	}
+	return rows.Err()
+}
+
+// loadOtherFees adds fees in other currencies of the fills selected by where
+func (j *Journal) loadOtherFees(where string, args []any, byExecID map[string]*Fill) error {
+	if len(byExecID) == 0 {
+		return nil
+	}
+
+	rows, err := j.db.Query(`SELECT exec_id, currency, amount FROM fill_fees
+		WHERE exec_id IN (SELECT exec_id FROM fills WHERE `+where+`)`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var execID, currency, amount string
+		if err := rows.Scan(&execID, &currency, &amount); err != nil {
+			return err
+		}
+		fill := byExecID[execID]
+		if fill == nil {
+			continue
+		}
+		if fill.OtherFees == nil {
+			fill.OtherFees = make(map[string]decimal.Decimal)
+		}
+		fill.OtherFees[currency], _ = decimal.NewFromString(amount)
+	}
+	return rows.Err()
+}
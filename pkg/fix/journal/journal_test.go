@@ -0,0 +1,214 @@
+package journal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+)
+
+func openTestJournal(t *testing.T) *Journal {
+	j, err := Open(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func record(t *testing.T, j *Journal, e *Execution) bool {
+	ok, err := j.Record(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+type orderRow struct {
+	ordStatus, orderQty, price, cumQty, leavesQty, avgPx string
+	updatedAt                                            int64
+}
+
+func readOrder(t *testing.T, j *Journal, orderID string) orderRow {
+	var row orderRow
+	err := j.db.QueryRow(`SELECT ord_status, order_qty, price, cum_qty, leaves_qty, avg_px, updated_at FROM orders WHERE order_id = ?`, orderID).
+		Scan(&row.ordStatus, &row.orderQty, &row.price, &row.cumQty, &row.leavesQty, &row.avgPx, &row.updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+func TestRecordTradeCaptureKeepsOrder(t *testing.T) {
+	j := openTestJournal(t)
+	d := decimal.RequireFromString
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	record(t, j, &Execution{
+		ExecID: "1", OrderID: "100", ClOrdID: "c1", ExecType: enum.ExecType_TRADE, OrdStatus: enum.OrdStatus_PARTIALLY_FILLED,
+		Symbol: "BTC-USD", Side: enum.Side_BUY, OrdType: enum.OrdType_LIMIT, OrderQty: d("2"), Price: d("100"),
+		LastQty: d("1"), LastPx: d("100"), CumQty: d("1"), LeavesQty: d("1"), AvgPx: d("100"),
+		TransactTime: at, ReceivedAt: at,
+	})
+	before := readOrder(t, j, "100")
+
+	// the same fill from trade history with a new ExecID and a later time, as DecodeTradeCapture returns it
+	if !record(t, j, &Execution{
+		ExecID: "T1", OrderID: "100", ClOrdID: "c1", ExecType: enum.ExecType_TRADE, Symbol: "BTC-USD", Side: enum.Side_BUY,
+		LastQty: d("1"), LastPx: d("100"), TransactTime: at.Add(time.Second), ReceivedAt: at.Add(time.Minute),
+		TradeCapture: true,
+	}) {
+		t.Fatal("trade capture not journaled")
+	}
+
+	if after := readOrder(t, j, "100"); after != before {
+		t.Errorf("order changed by trade capture: %+v, was %+v", after, before)
+	}
+	fills, err := j.Fills(FillFilter{OrderID: "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 2 {
+		t.Errorf("%d fills, want 2", len(fills))
+	}
+}
+
+func TestRecordExecIDOnce(t *testing.T) {
+	j := openTestJournal(t)
+	d := decimal.RequireFromString
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fill := &Execution{
+		ExecID: "1", OrderID: "100", ClOrdID: "c1", ExecType: enum.ExecType_TRADE, OrdStatus: enum.OrdStatus_FILLED,
+		Symbol: "BTC-USD", Side: enum.Side_BUY, LastQty: d("1"), LastPx: d("100"), TransactTime: at, ReceivedAt: at,
+	}
+
+	if !record(t, j, fill) {
+		t.Fatal("execution not journaled")
+	}
+	// a resent report, possibly with another order state
+	resent := *fill
+	resent.OrdStatus = enum.OrdStatus_CANCELED
+	if record(t, j, &resent) {
+		t.Error("resent execution journaled twice")
+	}
+
+	fills, err := j.Fills(FillFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 1 {
+		t.Errorf("%d fills, want 1", len(fills))
+	}
+	if row := readOrder(t, j, "100"); row.ordStatus != string(enum.OrdStatus_FILLED) {
+		t.Errorf("order status %s, want filled", row.ordStatus)
+	}
+}
+
+func TestFillsFilter(t *testing.T) {
+	j := openTestJournal(t)
+	d := decimal.RequireFromString
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, f := range []struct {
+		orderID, clOrdID, symbol string
+		at                       time.Time
+	}{
+		{"100", "c1", "BTC-USD", at},
+		{"100", "c1", "BTC-USD", at.Add(time.Hour)},
+		{"200", "c2", "ETH-USD", at.Add(2 * time.Hour)},
+		{"300", "c3", "BTC-USD", at.Add(3 * time.Hour)},
+	} {
+		record(t, j, &Execution{
+			ExecID: fmt.Sprint(i + 1), OrderID: f.orderID, ClOrdID: f.clOrdID, ExecType: enum.ExecType_TRADE,
+			Symbol: f.symbol, Side: enum.Side_SELL, LastQty: d("1"), LastPx: d("10"), TransactTime: f.at, ReceivedAt: f.at,
+		})
+	}
+	// an order without fills
+	record(t, j, &Execution{
+		ExecID: "5", OrderID: "400", ClOrdID: "c4", ExecType: enum.ExecType_NEW, Symbol: "BTC-USD", TransactTime: at, ReceivedAt: at,
+	})
+
+	tests := []struct {
+		name    string
+		filter  FillFilter
+		execIDs string
+	}{
+		{"all", FillFilter{}, "1,2,3,4"},
+		{"from", FillFilter{From: at.Add(time.Hour)}, "2,3,4"},
+		{"to is exclusive", FillFilter{To: at.Add(2 * time.Hour)}, "1,2"},
+		{"from and to", FillFilter{From: at.Add(time.Hour), To: at.Add(3 * time.Hour)}, "2,3"},
+		{"symbol", FillFilter{Symbol: "BTC-USD"}, "1,2,4"},
+		{"OrderID", FillFilter{OrderID: "100"}, "1,2"},
+		{"ClOrdID", FillFilter{OrderID: "c2"}, "3"},
+		{"symbol and time", FillFilter{Symbol: "BTC-USD", From: at.Add(time.Minute)}, "2,4"},
+		{"no match", FillFilter{Symbol: "SOL-USD"}, ""},
+		{"order without fills", FillFilter{OrderID: "400"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fills, err := j.Fills(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			execIDs := make([]string, len(fills))
+			for i, fill := range fills {
+				execIDs[i] = fill.ExecID
+			}
+			if got := strings.Join(execIDs, ","); got != test.execIDs {
+				t.Errorf("fills %s, want %s", got, test.execIDs)
+			}
+		})
+	}
+}
+
+func TestFillsLegsAndOtherFees(t *testing.T) {
+	j := openTestJournal(t)
+	d := decimal.RequireFromString
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	legs := []LegFill{
+		{Symbol: "BTC-20240329-50000C", Side: enum.Side_BUY, Qty: d("1"), Px: d("1000")},
+		{Symbol: "BTC-20240628-50000C", Side: enum.Side_SELL, Qty: d("1"), Px: d("1150")},
+	}
+	record(t, j, &Execution{
+		ExecID: "1", OrderID: "100", ClOrdID: "c1", ExecType: enum.ExecType_TRADE, Symbol: "BTC-CAL", Side: enum.Side_SELL,
+		LastQty: d("1"), LastPx: d("150"), Fee: d("0.5"), FeeCurrency: "USD",
+		OtherFees:    map[string]decimal.Decimal{"PTF": d("2.25"), "BTC": d("0.0001")},
+		TransactTime: at, ReceivedAt: at, Legs: legs,
+	})
+	// a fill of another order, its legs and fees are not mixed in
+	record(t, j, &Execution{
+		ExecID: "2", OrderID: "200", ClOrdID: "c2", ExecType: enum.ExecType_TRADE, Symbol: "BTC-USD", Side: enum.Side_BUY,
+		LastQty: d("1"), LastPx: d("100"), OtherFees: map[string]decimal.Decimal{"PTF": d("1")},
+		TransactTime: at, ReceivedAt: at, Legs: legs[:1],
+	})
+
+	fills, err := j.Fills(FillFilter{OrderID: "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 1 {
+		t.Fatalf("%d fills, want 1", len(fills))
+	}
+	fill := fills[0]
+	if !fill.Fee.Equal(d("0.5")) || fill.FeeCurrency != "USD" {
+		t.Errorf("fee %s %s, want 0.5 USD", fill.Fee, fill.FeeCurrency)
+	}
+
+	if len(fill.Legs) != len(legs) {
+		t.Fatalf("legs %+v, want %+v", fill.Legs, legs)
+	}
+	for i, leg := range fill.Legs {
+		if leg.Symbol != legs[i].Symbol || leg.Side != legs[i].Side || !leg.Qty.Equal(legs[i].Qty) || !leg.Px.Equal(legs[i].Px) {
+			t.Errorf("leg %d %+v, want %+v", i, leg, legs[i])
+		}
+	}
+
+	if len(fill.OtherFees) != 2 || !fill.OtherFees["PTF"].Equal(d("2.25")) || !fill.OtherFees["BTC"].Equal(d("0.0001")) {
+		t.Errorf("other fees %v", fill.OtherFees)
+	}
+}
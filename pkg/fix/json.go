@@ -0,0 +1,158 @@
+package fix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/datadictionary"
+)
+
+type jsonField struct {
+	Key   string
+	Value any
+}
+
+// jsonObject keeps fields in FIX order when marshalled
+type jsonObject []jsonField
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(f.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonEnum is a value of a field with enumerated values
+type jsonEnum struct {
+	Value string `json:"value"`
+	Desc  string `json:"desc"`
+}
+
+// jsonBuilder is a MessageVisitor building nested objects, repeating groups become arrays
+type jsonBuilder struct {
+	objects []*jsonObject
+	groups  []*[]jsonObject
+}
+
+func newJSONBuilder() *jsonBuilder {
+	root := jsonObject{}
+	return &jsonBuilder{objects: []*jsonObject{&root}}
+}
+
+func (b *jsonBuilder) current() *jsonObject {
+	return b.objects[len(b.objects)-1]
+}
+
+func (b *jsonBuilder) result() jsonObject {
+	return *b.objects[0]
+}
+
+func (b *jsonBuilder) OnField(fieldType *datadictionary.FieldType, value []byte) {
+	var v any = string(value)
+	if enum, found := fieldType.Enums[string(value)]; found {
+		v = jsonEnum{Value: string(value), Desc: enum.Description}
+	}
+	*b.current() = append(*b.current(), jsonField{fieldType.Name(), v})
+}
+
+func (b *jsonBuilder) OnUnknownField(tag quickfix.Tag, value []byte) {
+	*b.current() = append(*b.current(), jsonField{fmt.Sprint(int(tag)), string(value)})
+}
+
+// Components are flattened, their fields are unique within a message
+func (b *jsonBuilder) OnComponentStart(name string) {}
+func (b *jsonBuilder) OnComponentEnd(name string)   {}
+
+func (b *jsonBuilder) OnGroupStart(fieldType *datadictionary.FieldType, count int) {
+	entries := make([]jsonObject, 0, count)
+	b.groups = append(b.groups, &entries)
+}
+
+func (b *jsonBuilder) OnGroupEntryStart(index int) {
+	entry := jsonObject{}
+	b.objects = append(b.objects, &entry)
+}
+
+func (b *jsonBuilder) OnGroupEntryEnd(index int) {
+	entry := b.objects[len(b.objects)-1]
+	b.objects = b.objects[:len(b.objects)-1]
+	entries := b.groups[len(b.groups)-1]
+	*entries = append(*entries, *entry)
+}
+
+func (b *jsonBuilder) OnGroupEnd(fieldType *datadictionary.FieldType) {
+	entries := b.groups[len(b.groups)-1]
+	b.groups = b.groups[:len(b.groups)-1]
+	*b.current() = append(*b.current(), jsonField{fieldType.Name(), *entries})
+}
+
+// MessageJSON decodes a message into an object of header, body and trailer with field names of the dictionary
+func MessageJSON(dictionary *datadictionary.DataDictionary, msg *quickfix.Message) (jsonObject, error) {
+	msgType, _ := msg.MsgType()
+	msgDef := dictionary.Messages[msgType]
+	if msgDef == nil {
+		return nil, fmt.Errorf("unknown MsgType '%s'", msgType)
+	}
+
+	header := newJSONBuilder()
+	if err := WalkFieldMap(msg.Header, dictionary.Header.Parts, header); err != nil {
+		return nil, err
+	}
+	body := newJSONBuilder()
+	if err := WalkFieldMap(msg.Body, msgDef.Parts, body); err != nil {
+		return nil, err
+	}
+	trailer := newJSONBuilder()
+	if err := WalkFieldMap(msg.Trailer, dictionary.Trailer.Parts, trailer); err != nil {
+		return nil, err
+	}
+
+	return jsonObject{
+		{"msgType", msgType},
+		{"msgName", msgDef.Name},
+		{"header", header.result()},
+		{"body", body.result()},
+		{"trailer", trailer.result()},
+	}, nil
+}
+
+// MessageEvent is a line of NDJSON output
+func MessageEvent(dictionary *datadictionary.DataDictionary, msg *quickfix.Message, sessionID string, direction string, at time.Time) ([]byte, error) {
+	decoded, err := MessageJSON(dictionary, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	timeKey := "receivedAt"
+	if direction == "out" {
+		timeKey = "sentAt"
+	}
+	event := append(jsonObject{
+		{"session", sessionID},
+		{"direction", direction},
+		{timeKey, at.UTC().Format(time.RFC3339Nano)},
+	}, decoded...)
+	event = append(event, jsonField{"raw", strings.ReplaceAll(msg.String(), "\x01", "|")})
+
+	return json.Marshal(event)
+}
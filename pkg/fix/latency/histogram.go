@@ -0,0 +1,196 @@
+// Package latency is an HDR-style histogram of durations: buckets are log-linear with a relative error below 1%,
+// values are recorded lock-free from any goroutine
+package latency
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subBucketBits = 7 // 128 linear sub-buckets per power of 2
+	subBuckets    = 1 << subBucketBits
+	maxValueBits  = 42 // ~73 minutes in nanoseconds, larger values are clamped
+
+	bucketCount = (maxValueBits-subBucketBits)*subBuckets + subBuckets
+	maxValue    = int64(1)<<maxValueBits - 1
+)
+
+// Histogram records durations in nanoseconds
+type Histogram struct {
+	counts [bucketCount]atomic.Int64
+	total  atomic.Int64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+func New() *Histogram {
+	h := &Histogram{}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+func bucketOf(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits - 1
+	return shift*subBuckets + int(v>>shift)
+}
+
+// bucketRange returns the lowest and highest values counted by a bucket
+func bucketRange(i int) (int64, int64) {
+	if i < subBuckets {
+		return int64(i), int64(i)
+	}
+	shift := i/subBuckets - 1
+	m := int64(i - shift*subBuckets)
+	return m << shift, (m+1)<<shift - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordValue(int64(d))
+}
+
+func (h *Histogram) RecordValue(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if v > maxValue {
+		v = maxValue
+	}
+	h.counts[bucketOf(v)].Add(1)
+	h.total.Add(1)
+	h.sum.Add(v)
+
+	for cur := h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+	for cur := h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+}
+
+func (h *Histogram) Count() int64 {
+	return h.total.Load()
+}
+
+// Snapshot copies the counts; values recorded meanwhile may be partially included
+func (h *Histogram) Snapshot() *Snapshot {
+	s := &Snapshot{Counts: make([]int64, bucketCount)}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Total += s.Counts[i]
+	}
+	s.Sum = h.sum.Load()
+	s.Max = h.max.Load()
+	s.Min = h.min.Load()
+	if s.Total == 0 {
+		s.Min = 0
+	}
+	return s
+}
+
+// Snapshot is a histogram at a point in time
+type Snapshot struct {
+	Counts []int64
+	Total  int64
+	Sum    int64
+	Min    int64
+	Max    int64
+}
+
+// Sub returns values recorded since a previous snapshot of the same histogram, min and max are bucket bounds
+func (s *Snapshot) Sub(prev *Snapshot) *Snapshot {
+	d := &Snapshot{Counts: make([]int64, len(s.Counts)), Sum: s.Sum - prev.Sum}
+	for i := range s.Counts {
+		d.Counts[i] = s.Counts[i] - prev.Counts[i]
+		d.Total += d.Counts[i]
+		if d.Counts[i] > 0 {
+			low, high := bucketRange(i)
+			if d.Total == d.Counts[i] {
+				d.Min = low
+			}
+			d.Max = high
+		}
+	}
+	if d.Total > 0 {
+		d.Max = min(d.Max, s.Max)
+		d.Min = max(d.Min, s.Min)
+	}
+	return d
+}
+
+// Merge adds counts of another snapshot
+func (s *Snapshot) Merge(other *Snapshot) {
+	if other.Total == 0 {
+		return
+	}
+	if s.Total == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	s.Max = max(s.Max, other.Max)
+	s.Total += other.Total
+	s.Sum += other.Sum
+	for i := range other.Counts {
+		s.Counts[i] += other.Counts[i]
+	}
+}
+
+func (s *Snapshot) Mean() time.Duration {
+	if s.Total == 0 {
+		return 0
+	}
+	return time.Duration(s.Sum / s.Total)
+}
+
+// Percentile returns the highest value of the bucket holding the quantile q (0-100), bounded by the max
+func (s *Snapshot) Percentile(q float64) time.Duration {
+	if s.Total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q / 100 * float64(s.Total)))
+	rank = max(rank, 1)
+
+	var seen int64
+	for i, count := range s.Counts {
+		seen += count
+		if seen >= rank {
+			_, high := bucketRange(i)
+			return time.Duration(min(high, s.Max))
+		}
+	}
+	return time.Duration(s.Max)
+}
+
+// Percentiles reported by String
+var Percentiles = []float64{50, 90, 99, 99.9}
+
+// String is e.g. `n=100 p50=1.2ms p90=... max=3ms`
+func (s *Snapshot) String() string {
+	res := fmt.Sprintf("n=%d", s.Total)
+	for _, q := range Percentiles {
+		res += fmt.Sprintf(" p%g=%v", q, s.Percentile(q))
+	}
+	return res + fmt.Sprintf(" max=%v", time.Duration(s.Max))
+}
+
+// Bucket is a non-empty bucket of a snapshot, Value is its highest value
+type Bucket struct {
+	Value int64 `json:"value_ns"`
+	Count int64 `json:"count"`
+}
+
+// Buckets returns non-empty buckets in increasing order of values
+func (s *Snapshot) Buckets() []Bucket {
+	buckets := make([]Bucket, 0)
+	for i, count := range s.Counts {
+		if count > 0 {
+			_, high := bucketRange(i)
+			buckets = append(buckets, Bucket{Value: high, Count: count})
+		}
+	}
+	return buckets
+}
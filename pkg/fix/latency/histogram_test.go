@@ -0,0 +1,140 @@
+package latency
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBucketOf(t *testing.T) {
+	tests := []struct {
+		value  int64
+		bucket int
+	}{
+		{0, 0},
+		{1, 1},
+		{subBuckets - 1, subBuckets - 1},
+		{subBuckets, subBuckets},
+		{2*subBuckets - 1, 2*subBuckets - 1},
+		// from 256 on, a bucket counts 2 values, then 4 from 512 and so on
+		{2 * subBuckets, 2 * subBuckets},
+		{2*subBuckets + 1, 2 * subBuckets},
+		{2*subBuckets + 2, 2*subBuckets + 1},
+		{4 * subBuckets, 3 * subBuckets},
+		{4*subBuckets + 3, 3 * subBuckets},
+		{4*subBuckets + 4, 3*subBuckets + 1},
+		{maxValue, bucketCount - 1},
+	}
+	for _, test := range tests {
+		if got := bucketOf(test.value); got != test.bucket {
+			t.Errorf("bucketOf(%d) = %d, want %d", test.value, got, test.bucket)
+		}
+	}
+}
+
+func TestBucketRange(t *testing.T) {
+	next := int64(0)
+	for i := 0; i < bucketCount; i++ {
+		low, high := bucketRange(i)
+		// buckets are contiguous and hold the values they are computed for
+		if low != next || high < low {
+			t.Fatalf("bucket %d is [%d, %d], want it to start at %d", i, low, high, next)
+		}
+		if bucketOf(low) != i || bucketOf(high) != i {
+			t.Fatalf("bucket %d is [%d, %d], bucketOf gives %d and %d", i, low, high, bucketOf(low), bucketOf(high))
+		}
+		// the width of a bucket is below 1% of its values
+		if low > 0 && float64(high-low) >= 0.01*float64(low) {
+			t.Fatalf("bucket %d [%d, %d] is wider than 1%%", i, low, high)
+		}
+		next = high + 1
+	}
+	if next-1 != maxValue {
+		t.Errorf("last bucket ends at %d, want %d", next-1, maxValue)
+	}
+}
+
+func TestRecordClamps(t *testing.T) {
+	h := New()
+	h.RecordValue(-1)
+	h.RecordValue(maxValue + 1000)
+	s := h.Snapshot()
+	if s.Min != 0 || s.Max != maxValue || s.Counts[0] != 1 || s.Counts[bucketCount-1] != 1 {
+		t.Errorf("min %d max %d, want 0 and %d", s.Min, s.Max, maxValue)
+	}
+}
+
+// sample is a reproducible sample of latencies from 10µs to about a second
+func sample(seed int64, n int) []int64 {
+	r := rand.New(rand.NewSource(seed))
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = int64(10*time.Microsecond) + r.Int63n(int64(time.Millisecond))
+		if r.Intn(100) == 0 {
+			values[i] += r.Int63n(int64(time.Second))
+		}
+	}
+	return values
+}
+
+func TestPercentile(t *testing.T) {
+	values := sample(1, 10000)
+	h := New()
+	for _, v := range values {
+		h.RecordValue(v)
+	}
+	s := h.Snapshot()
+	slices.Sort(values)
+
+	for _, q := range []float64{0, 1, 50, 90, 99, 99.9, 99.99, 100} {
+		rank := max(int(math.Ceil(q/100*float64(len(values)))), 1)
+		exact := values[rank-1]
+		got := int64(s.Percentile(q))
+		// at most the width of the bucket above the exact value, never beyond the max
+		if got < exact || float64(got-exact) > 0.01*float64(exact) || got > values[len(values)-1] {
+			t.Errorf("p%g = %d, exact %d", q, got, exact)
+		}
+	}
+	if s.Percentile(100) != time.Duration(values[len(values)-1]) {
+		t.Errorf("p100 = %v, want the max %v", s.Percentile(100), time.Duration(values[len(values)-1]))
+	}
+	if empty := New().Snapshot(); empty.Percentile(50) != 0 {
+		t.Errorf("p50 of an empty histogram %v", empty.Percentile(50))
+	}
+}
+
+func TestSnapshotSub(t *testing.T) {
+	h := New()
+	for _, v := range sample(1, 1000) {
+		h.RecordValue(v)
+	}
+	prev := h.Snapshot()
+
+	if d := h.Snapshot().Sub(prev); d.Total != 0 || d.Sum != 0 || d.Min != 0 || d.Max != 0 {
+		t.Errorf("nothing recorded, got %+v", d)
+	}
+
+	interval := New()
+	values := sample(2, 1000)
+	for _, v := range values {
+		h.RecordValue(v)
+		interval.RecordValue(v)
+	}
+	d := h.Snapshot().Sub(prev)
+	want := interval.Snapshot()
+
+	if d.Total != want.Total || d.Sum != want.Sum || !slices.Equal(d.Counts, want.Counts) {
+		t.Fatalf("interval of %d values summing %d, want %d summing %d", d.Total, d.Sum, want.Total, want.Sum)
+	}
+	// min and max of an interval are known to their buckets only
+	if bucketOf(d.Min) != bucketOf(want.Min) || bucketOf(d.Max) != bucketOf(want.Max) {
+		t.Errorf("interval min %d max %d, want in the buckets of %d and %d", d.Min, d.Max, want.Min, want.Max)
+	}
+	for _, q := range Percentiles {
+		if bucketOf(int64(d.Percentile(q))) != bucketOf(int64(want.Percentile(q))) {
+			t.Errorf("interval p%g %v, want %v", q, d.Percentile(q), want.Percentile(q))
+		}
+	}
+}
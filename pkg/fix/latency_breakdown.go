@@ -0,0 +1,170 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/latency"
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/testrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+const (
+	clockOffsetWindow  = 32 // round trips kept to pick the fastest one
+	clockTestReqPrefix = "clk-"
+)
+
+var (
+	clockProbeCmd = flag.Duration("pclk", time.Second, "Perf: send TestRequest to estimate the venue clock offset this often (0 = off)")
+
+	DefaultClockOffset = NewClockOffset()
+)
+
+// splitLatency splits a round trip into our send -> venue TransactTime, venue TransactTime -> venue SendingTime
+// and venue SendingTime -> our receipt. Venue times are converted to the local clock with the offset.
+func splitLatency(sentAt time.Time, venueTransactTime time.Time, venueSendingTime time.Time, receivedAt time.Time, offset time.Duration) (outbound, venue, inbound time.Duration) {
+	transactTime := venueTransactTime.Add(-offset)
+	sendingTime := venueSendingTime.Add(-offset)
+	return transactTime.Sub(sentAt), venueSendingTime.Sub(venueTransactTime), receivedAt.Sub(sendingTime)
+}
+
+type clockSample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// ClockOffset estimates venue clock - local clock from Heartbeats answering TestRequests,
+// assuming the venue stamps its SendingTime half way through the round trip. The fastest recent round trip is used.
+type ClockOffset struct {
+	mu      sync.Mutex
+	samples []clockSample
+	next    int
+	probes  map[string]time.Time // TestReqID -> sent at
+}
+
+func NewClockOffset() *ClockOffset {
+	return &ClockOffset{probes: make(map[string]time.Time)}
+}
+
+func (c *ClockOffset) Observe(sentAt time.Time, venueTime time.Time, receivedAt time.Time) {
+	sample := clockSample{
+		offset: venueTime.Sub(sentAt.Add(receivedAt.Sub(sentAt) / 2)),
+		rtt:    receivedAt.Sub(sentAt),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) < clockOffsetWindow {
+		c.samples = append(c.samples, sample)
+		return
+	}
+	c.samples[c.next] = sample
+	c.next = (c.next + 1) % clockOffsetWindow
+}
+
+// Offset returns the offset of the fastest recent round trip and its RTT, the error of the offset is below RTT/2
+func (c *ClockOffset) Offset() (offset time.Duration, rtt time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, sample := range c.samples {
+		if i == 0 || sample.rtt < rtt {
+			offset, rtt, ok = sample.offset, sample.rtt, true
+		}
+	}
+	return
+}
+
+// Probe sends a TestRequest, its Heartbeat is handled by FromAdmin
+func (c *ClockOffset) Probe(sessionID quickfix.SessionID) error {
+	testReqID := fmt.Sprint(clockTestReqPrefix, pt.DefaultTokenGenerator.Next())
+
+	c.mu.Lock()
+	if len(c.probes) > clockOffsetWindow {
+		// unanswered probes
+		c.probes = make(map[string]time.Time)
+	}
+	c.probes[testReqID] = time.Now()
+	c.mu.Unlock()
+
+	return quickfix.SendToTarget(testrequest.New(field.NewTestReqID(testReqID)), sessionID)
+}
+
+// FromAdmin observes Heartbeats answering probes, other messages are ignored
+func (c *ClockOffset) FromAdmin(msg *quickfix.Message) {
+	receivedAt := time.Now()
+	msgType, _ := msg.MsgType()
+	testReqID := getString(msg.Body, tag.TestReqID)
+	if enum.MsgType(msgType) != enum.MsgType_HEARTBEAT || !strings.HasPrefix(testReqID, clockTestReqPrefix) {
+		return
+	}
+
+	c.mu.Lock()
+	sentAt, found := c.probes[testReqID]
+	delete(c.probes, testReqID)
+	c.mu.Unlock()
+
+	venueTime, err := msg.Header.GetTime(tag.SendingTime)
+	if found && err == nil {
+		c.Observe(sentAt, venueTime, receivedAt)
+	}
+}
+
+// ProbeEvery probes a session until stop is closed
+func (c *ClockOffset) ProbeEvery(sessionID quickfix.SessionID, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Probe(sessionID); err != nil {
+				fmt.Printf("Clock offset: %v\n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// LatencyBreakdown records one-way components of round trips
+type LatencyBreakdown struct {
+	Outbound *latency.Histogram // our SendingTime -> venue TransactTime
+	Venue    *latency.Histogram // venue TransactTime -> venue SendingTime
+	Inbound  *latency.Histogram // venue SendingTime -> receipt
+}
+
+func NewLatencyBreakdown() *LatencyBreakdown {
+	return &LatencyBreakdown{
+		Outbound: latency.New(),
+		Venue:    latency.New(),
+		Inbound:  latency.New(),
+	}
+}
+
+// Record splits a round trip, negative components (clock offset error) are recorded as 0
+func (b *LatencyBreakdown) Record(sentAt time.Time, venueTransactTime time.Time, venueSendingTime time.Time, receivedAt time.Time, offset time.Duration) {
+	outbound, venue, inbound := splitLatency(sentAt, venueTransactTime, venueSendingTime, receivedAt, offset)
+	b.Outbound.Record(outbound)
+	b.Venue.Record(venue)
+	b.Inbound.Record(inbound)
+}
+
+// String is a table of components per percentile
+func (b *LatencyBreakdown) String() string {
+	outbound, venue, inbound := b.Outbound.Snapshot(), b.Venue.Snapshot(), b.Inbound.Snapshot()
+
+	var res strings.Builder
+	fmt.Fprintf(&res, "    %-8s %14s %14s %14s\n", "", "Outbound", "Venue", "Inbound")
+	for _, q := range latency.Percentiles {
+		fmt.Fprintf(&res, "    p%-7g %14v %14v %14v\n", q, outbound.Percentile(q), venue.Percentile(q), inbound.Percentile(q))
+	}
+	fmt.Fprintf(&res, "    %-8s %14v %14v %14v\n", "max", time.Duration(outbound.Max), time.Duration(venue.Max), time.Duration(inbound.Max))
+	return res.String()
+}
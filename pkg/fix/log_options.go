@@ -0,0 +1,214 @@
+package fix
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/datadictionary"
+	"github.com/quickfixgo/tag"
+)
+
+type LogFormat string
+
+const (
+	LogFormat_PRETTY  LogFormat = "pretty"
+	LogFormat_COMPACT LogFormat = "compact"
+	LogFormat_JSON    LogFormat = "json"
+	LogFormat_NONE    LogFormat = "none" // message is not logged
+
+	redactedValue = "****"
+)
+
+var (
+	logFormatCmd    = flag.String("lf", "pretty", "Log: message format: pretty/compact/json")
+	logRedactCmd    = flag.String("lr", "Password", "Log: fields to mask, tags or names separated by ','")
+	logVerbosityCmd = flag.String("lv", "", "Log: format per MsgType, e.g. '0=none,8=compact' hides heartbeats")
+)
+
+// LogOptions select how BeautyLog prints messages
+type LogOptions struct {
+	Format    LogFormat
+	Redact    map[quickfix.Tag]bool
+	Verbosity map[string]LogFormat // MsgType -> format
+}
+
+func parseLogFormat(value string) (LogFormat, error) {
+	switch format := LogFormat(strings.ToLower(strings.TrimSpace(value))); format {
+	case LogFormat_PRETTY, LogFormat_COMPACT, LogFormat_JSON, LogFormat_NONE:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown log format '%s': pretty/compact/json/none", value)
+	}
+}
+
+// ParseLogOptions parses the format, fields to redact (tags or dictionary names) and per-MsgType formats
+func ParseLogOptions(dictionary *datadictionary.DataDictionary, format string, redact string, verbosity string) (LogOptions, error) {
+	options := LogOptions{
+		Redact:    make(map[quickfix.Tag]bool),
+		Verbosity: make(map[string]LogFormat),
+	}
+
+	var err error
+	options.Format, err = parseLogFormat(format)
+	if err != nil {
+		return options, err
+	}
+
+	for _, name := range strings.Split(redact, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if t, err := strconv.Atoi(name); err == nil {
+			options.Redact[quickfix.Tag(t)] = true
+			continue
+		}
+		fieldType, found := dictionary.FieldTypeByName[name]
+		if !found {
+			return options, fmt.Errorf("unknown field to redact '%s'", name)
+		}
+		options.Redact[quickfix.Tag(fieldType.Tag())] = true
+	}
+
+	for _, item := range strings.Split(verbosity, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		msgType, value, found := strings.Cut(item, "=")
+		if !found {
+			return options, fmt.Errorf("bad log verbosity '%s': expected MsgType=format", item)
+		}
+		options.Verbosity[strings.TrimSpace(msgType)], err = parseLogFormat(value)
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// LogOptionsFromFlags parses `-lf`, `-lr` and `-lv`
+func LogOptionsFromFlags() (LogOptions, error) {
+	dictionary, err := AppDictionary()
+	if err != nil {
+		return LogOptions{}, err
+	}
+	return ParseLogOptions(dictionary, *logFormatCmd, *logRedactCmd, *logVerbosityCmd)
+}
+
+// FormatOf returns the format of a MsgType
+func (o LogOptions) FormatOf(msgType string) LogFormat {
+	if format, found := o.Verbosity[msgType]; found {
+		return format
+	}
+	if o.Format == "" {
+		return LogFormat_PRETTY
+	}
+	return o.Format
+}
+
+// RedactRaw masks values of redacted fields in a raw message
+func (o LogOptions) RedactRaw(raw []byte) []byte {
+	if len(o.Redact) == 0 {
+		return raw
+	}
+
+	fields := bytes.Split(raw, []byte{'\x01'})
+	for i, f := range fields {
+		tagValue, value, found := bytes.Cut(f, []byte{'='})
+		if !found {
+			continue
+		}
+		t, err := strconv.Atoi(string(tagValue))
+		if err != nil || !o.Redact[quickfix.Tag(t)] || len(value) == 0 {
+			continue
+		}
+		fields[i] = []byte(string(tagValue) + "=" + redactedValue)
+	}
+	return bytes.Join(fields, []byte{'\x01'})
+}
+
+// RedactMessage masks redacted fields of header, body and trailer
+func (o LogOptions) RedactMessage(msg *quickfix.Message) {
+	for t := range o.Redact {
+		for _, fm := range []*quickfix.FieldMap{&msg.Header.FieldMap, &msg.Body.FieldMap, &msg.Trailer.FieldMap} {
+			if fm.Has(t) {
+				fm.SetString(t, redactedValue)
+			}
+		}
+	}
+}
+
+// compactBuilder is a MessageVisitor printing fields on a single line, groups are in brackets
+type compactBuilder struct {
+	b strings.Builder
+}
+
+func (c *compactBuilder) write(name string, value string) {
+	if c.b.Len() > 0 && !strings.HasSuffix(c.b.String(), "{") {
+		c.b.WriteByte(' ')
+	}
+	c.b.WriteString(name)
+	c.b.WriteByte('=')
+	c.b.WriteString(value)
+}
+
+func (c *compactBuilder) OnField(fieldType *datadictionary.FieldType, value []byte) {
+	switch quickfix.Tag(fieldType.Tag()) {
+	case tag.BeginString, tag.BodyLength, tag.MsgType:
+		return
+	}
+	v := string(value)
+	if enum, found := fieldType.Enums[v]; found {
+		v += "(" + enum.Description + ")"
+	}
+	c.write(fieldType.Name(), v)
+}
+
+func (c *compactBuilder) OnUnknownField(t quickfix.Tag, value []byte) {
+	c.write(strconv.Itoa(int(t)), string(value))
+}
+
+func (c *compactBuilder) OnComponentStart(name string) {}
+func (c *compactBuilder) OnComponentEnd(name string)   {}
+
+func (c *compactBuilder) OnGroupStart(fieldType *datadictionary.FieldType, count int) {
+	c.write(fieldType.Name(), strconv.Itoa(count)+" [")
+}
+
+func (c *compactBuilder) OnGroupEntryStart(index int) {
+	if index > 0 {
+		c.b.WriteByte(' ')
+	}
+	c.b.WriteByte('{')
+}
+
+func (c *compactBuilder) OnGroupEntryEnd(index int) {
+	c.b.WriteByte('}')
+}
+
+func (c *compactBuilder) OnGroupEnd(fieldType *datadictionary.FieldType) {
+	c.b.WriteByte(']')
+}
+
+// CompactMessage renders a message on one line with field names of the dictionary, checksum and lengths are left out
+func CompactMessage(dictionary *datadictionary.DataDictionary, msg *quickfix.Message) (string, error) {
+	msgType, _ := msg.MsgType()
+	msgDef := dictionary.Messages[msgType]
+	if msgDef == nil {
+		return "", fmt.Errorf("unknown MsgType '%s'", msgType)
+	}
+
+	c := &compactBuilder{}
+	c.b.WriteString(msgDef.Name + "(" + msgType + ")")
+	if err := WalkFieldMap(msg.Header, dictionary.Header.Parts, c); err != nil {
+		return "", err
+	}
+	if err := WalkFieldMap(msg.Body, msgDef.Parts, c); err != nil {
+		return "", err
+	}
+	return c.b.String(), nil
+}
@@ -0,0 +1,224 @@
+// Package logfile is a log file rotated by size and time, rotated files are optionally gzipped and pruned
+package logfile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "20060102T150405.000"
+	backupSeqFormat  = "%03d" // rotations within a millisecond
+)
+
+type Options struct {
+	MaxSize    int64         // rotate when the file would exceed it, 0 = no limit
+	Interval   time.Duration // rotate when the file is older, 0 = never
+	Compress   bool          // gzip rotated files
+	MaxBackups int           // rotated files to keep, 0 = all
+	MaxAge     time.Duration // remove rotated files older than this, 0 = never
+}
+
+// Writer appends to a file and rotates it to `<path>.<time>-<seq>[.gz]`
+type Writer struct {
+	mu       sync.Mutex
+	path     string
+	options  Options
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	pending  sync.WaitGroup // compression of rotated files
+}
+
+func Open(path string, options Options) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{path: path, options: options}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	tooBig := w.options.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.options.MaxSize
+	tooOld := w.options.Interval > 0 && time.Since(w.openedAt) >= w.options.Interval
+	if tooBig || tooOld {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file now
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	backup := w.backupName(time.Now().UTC())
+	if err := os.Rename(w.path, backup); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		if w.options.Compress {
+			if err := compress(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "logfile: %v\n", err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+// backupName is the first free name of a file rotated at, whether compressed or not
+func (w *Writer) backupName(at time.Time) string {
+	prefix := w.path + "." + at.Format(backupTimeFormat) + "-"
+	for seq := 0; ; seq++ {
+		backup := prefix + fmt.Sprintf(backupSeqFormat, seq)
+		if !exists(backup) && !exists(backup+".gz") {
+			return backup
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// backupTime parses the rotation time of a backup suffix `<time>-<seq>[.gz]`, files without seq are of older versions
+func backupTime(suffix string) (time.Time, error) {
+	suffix = strings.TrimSuffix(suffix, ".gz")
+	if at, seq, found := strings.Cut(suffix, "-"); found {
+		var n int
+		if _, err := fmt.Sscanf(seq, backupSeqFormat, &n); err != nil {
+			return time.Time{}, err
+		}
+		suffix = at
+	}
+	return time.Parse(backupTimeFormat, suffix)
+}
+
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Backups returns rotated files, newest first
+func (w *Writer) Backups() ([]string, error) {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if _, err := backupTime(strings.TrimPrefix(match, w.path+".")); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	// the timestamp and seq sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+func (w *Writer) prune() {
+	backups, err := w.Backups()
+	if err != nil {
+		return
+	}
+
+	for i, backup := range backups {
+		expired := w.options.MaxBackups > 0 && i >= w.options.MaxBackups
+		if !expired && w.options.MaxAge > 0 {
+			rotatedAt, _ := backupTime(strings.TrimPrefix(backup, w.path+"."))
+			expired = time.Since(rotatedAt) > w.options.MaxAge
+		}
+		if expired {
+			os.Remove(backup)
+		}
+	}
+}
+
+// Close waits for rotated files to be compressed
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending.Wait()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
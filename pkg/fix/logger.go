@@ -2,6 +2,7 @@ package fix
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -11,77 +12,101 @@ import (
 )
 
 type BeautyLogFactory struct {
-	parent quickfix.LogFactory
+	parent  quickfix.LogFactory
+	options LogOptions
 }
 
 const (
-	fixMessagePart_Header  = 0
-	fixMessagePart_Body    = 1
-	fixMessagePart_Trailer = 2
-	fixMessagePart_Group   = 3
-
 	printPrefix         = 32
 	printPrefixInc      = 4
 	printPrefixIndexInc = 2
-	printArrayRepeat    = 8
 
 	FIX_XML_PATH = "spec/FIX44-PT.xml"
 )
 
 func (b BeautyLogFactory) Create() (quickfix.Log, error) {
 	plog, _ := b.parent.Create()
-	dictionary, err := datadictionary.Parse(FIX_XML_PATH)
+	dictionary, err := AppDictionary()
 	if err != nil {
-		fmt.Printf("datadictionary.Parse() -> ERROR: %v", err)
+		fmt.Printf("AppDictionary() -> ERROR: %v", err)
 		return nil, err
 	}
-	log := BeautyLog{plog, "GLOBAL", dictionary}
+	log := BeautyLog{plog, "GLOBAL", dictionary, b.options}
 	return log, nil
 }
 
 func (b BeautyLogFactory) CreateSessionLog(sessionID quickfix.SessionID) (quickfix.Log, error) {
 	plog, _ := b.parent.CreateSessionLog(sessionID)
-	dictionary, err := datadictionary.Parse(FIX_XML_PATH)
+	dictionary, err := AppDictionary()
 	if err != nil {
-		fmt.Printf("datadictionary.Parse() -> ERROR: %v", err)
+		fmt.Printf("AppDictionary() -> ERROR: %v", err)
 		return nil, err
 	}
-	log := BeautyLog{plog, sessionID.String(), dictionary}
+	log := BeautyLog{plog, sessionID.String(), dictionary, b.options}
 	return log, nil
 }
 
 // NewScreenLogFactory creates an instance of LogFactory that writes messages and events to stdout.
-func NewBeautyLogFactory(parent quickfix.LogFactory) quickfix.LogFactory {
-	return BeautyLogFactory{parent}
+func NewBeautyLogFactory(parent quickfix.LogFactory, options LogOptions) quickfix.LogFactory {
+	return BeautyLogFactory{parent, options}
 }
 
 type BeautyLog struct {
 	quickfix.Log
 	sessionName string
 	dictionary  *datadictionary.DataDictionary
+	options     LogOptions
 }
 
+// BeautifyFIX formats a message as selected by options for its MsgType, nil if it is not to be logged
 func (b BeautyLog) BeautifyFIX(raw []byte) []byte {
 
 	msg := quickfix.NewMessage()
 
 	err := quickfix.ParseMessage(msg, bytes.NewBuffer(raw))
 	if err != nil {
-		return []byte(fmt.Sprintf("Error: %v\n%s", err, raw))
+		return []byte(fmt.Sprintf("Error: %v\n%s", err, b.options.RedactRaw(raw)))
 	}
+	b.options.RedactMessage(msg)
+	raw = b.options.RedactRaw(raw)
 
 	var msgType field.MsgTypeField
 	msg.Header.Get(&msgType)
 
+	switch b.options.FormatOf(string(msgType.Value())) {
+	case LogFormat_NONE:
+		return nil
+	case LogFormat_COMPACT:
+		line, err := CompactMessage(b.dictionary, msg)
+		if err != nil {
+			return []byte(fmt.Sprintf("Error: %v\n%s", err, b.BeautifyFIXString(string(raw))))
+		}
+		return []byte(line)
+	case LogFormat_JSON:
+		decoded, err := MessageJSON(b.dictionary, msg)
+		if err == nil {
+			decoded = append(decoded, jsonField{"raw", b.BeautifyFIXString(string(raw))})
+			var line []byte
+			line, err = json.Marshal(decoded)
+			if err == nil {
+				return line
+			}
+		}
+		return []byte(fmt.Sprintf("Error: %v\n%s", err, b.BeautifyFIXString(string(raw))))
+	}
+
 	MessageDesc := b.dictionary.Messages[string(msgType.Value())]
 	humanReadableFIX := b.BeautifyFIXString(string(raw))
+	if MessageDesc == nil {
+		return []byte(fmt.Sprintf("Error: unknown MsgType '%s'\n%s", msgType.Value(), humanReadableFIX))
+	}
 
 	// Ideal result using Java: https://stackoverflow.com/questions/6453879/how-to-log-quickfix-message-in-human-readable-format
 	return []byte(fmt.Sprintf("\nORIG:\n%s\n\nHEADER:\n%s\nBODY:\n%s\nTRAILER:\n%s\n",
 		humanReadableFIX,
-		b.BeautifyFieldMap(msg.Header.FieldMap, NewFieldDefs(b.dictionary.Header.Parts, b.dictionary.Header.Fields), fixMessagePart_Header, printPrefix, -1),
-		b.BeautifyFieldMap(msg.Body.FieldMap, NewFieldDefs(MessageDesc.Parts, MessageDesc.Fields), fixMessagePart_Body, printPrefix, -1),
-		b.BeautifyFieldMap(msg.Trailer.FieldMap, NewFieldDefs(b.dictionary.Trailer.Parts, b.dictionary.Trailer.Fields), fixMessagePart_Trailer, printPrefix, -1),
+		b.BeautifyFieldMap(msg.Header, b.dictionary.Header.Parts),
+		b.BeautifyFieldMap(msg.Body, MessageDesc.Parts),
+		b.BeautifyFieldMap(msg.Trailer, b.dictionary.Trailer.Parts),
 	))
 }
 
@@ -89,47 +114,13 @@ func (b BeautyLog) BeautifyFIXString(event string) string {
 	return strings.ReplaceAll(event, "\x01", "|")
 }
 
-type FieldDefs []*datadictionary.FieldDef
-
-func (t FieldDefs) Find(tag quickfix.Tag) *datadictionary.FieldDef {
-	for _, fieldDef := range t {
-		if fieldDef.Tag() == int(tag) {
-			return fieldDef
-		}
-	}
-	return nil
-}
-
-func NewFieldDefs(Parts []datadictionary.MessagePart, MapFields map[int]*datadictionary.FieldDef) FieldDefs {
-	values := make([]*datadictionary.FieldDef, 0)
-	for _, part := range Parts {
-		// fmt.Printf("Looking for %s\n", part.Name())
-		switch rpart := part.(type) {
-		case datadictionary.Component:
-			{ // e.g. SecListGrp
-				values = append(values, rpart.Fields()...)
-			}
-		default:
-			{
-				for _, v := range MapFields {
-					if v.Name() == part.Name() {
-						values = append(values, v)
-						break
-					}
-				}
-			}
-		}
-	}
-	return values
-}
-
-func NewFieldDefsFromArr(MapFields []*datadictionary.FieldDef) FieldDefs {
-	return MapFields
+func (b BeautyLog) BeautifyField(tag quickfix.Tag, name string, value quickfix.FIXBytes, desc string, prefix int, index int) string {
+	return b.beautifyLine(fmt.Sprintf("[%4d]", tag), name, fmt.Sprintf(": %s [%s]", value, desc), prefix, index)
 }
 
-func (b BeautyLog) BeautifyField(tag quickfix.Tag, name string, value quickfix.FIXBytes, desc string, prefix int, index int) string {
+func (b BeautyLog) beautifyLine(tag string, name string, value string, prefix int, index int) string {
 
-	res := fmt.Sprintf("\t[%4d]", tag)
+	res := "\t" + tag
 	if index >= 0 {
 		// 1st field of an array
 		idxOffset := (prefix - printPrefix) * printPrefixIndexInc / printPrefixInc
@@ -148,101 +139,99 @@ func (b BeautyLog) BeautifyField(tag quickfix.Tag, name string, value quickfix.F
 			name,
 		)
 	}
-	res += fmt.Sprintf(": %s [%s]", value, desc)
+	res += value
 
 	res += "\n"
 	return res
 }
 
-func (b BeautyLog) BeautifyFieldMap(fm quickfix.FieldMap, fieldDefs FieldDefs, messagePart int, prefix int, index int) string {
-
-	var res string
-	if messagePart != fixMessagePart_Group {
-		res = "--------------------------------------------------------------------\n"
+// BeautifyFieldMap renders fields of parts in dictionary order, components and groups are indented
+func (b BeautyLog) BeautifyFieldMap(fm fieldMapReader, parts []datadictionary.MessagePart) string {
+	p := &prettyBuilder{log: b, prefix: printPrefix, index: -1}
+	p.res.WriteString("--------------------------------------------------------------------\n")
+	if err := WalkFieldMap(fm, parts, p); err != nil {
+		p.res.WriteString(fmt.Sprintf("ERROR: %v\n", err))
 	}
+	return p.res.String()
+}
 
-	for _, gFieldDef := range fieldDefs {
-		var value quickfix.FIXBytes
-		tag := quickfix.Tag(gFieldDef.Tag())
-		err := fm.GetField(tag, &value)
-		if err != nil {
-			continue
-		}
+// prettyBuilder is a MessageVisitor rendering a field per line
+type prettyBuilder struct {
+	log        BeautyLog
+	res        strings.Builder
+	prefix     int
+	index      int      // index of the group entry, printed with its 1st field
+	counts     []int    // entries of open groups
+	components []string // open components
+}
 
-		fieldDesc, found := b.dictionary.FieldTypeByTag[int(tag)]
-		if found {
-			switch fieldDesc.Type {
-			case "NUMINGROUP":
-				res += b.BeautifyField(tag, fieldDesc.Name(), value, "", prefix, index) // ToDo: array = ${component}
-
-				var getChildFieldTags func(parent quickfix.Tag, sFieldDefs FieldDefs) quickfix.GroupTemplate
-				getChildFieldTags = func(parent quickfix.Tag, sFieldDefs FieldDefs) quickfix.GroupTemplate {
-					template := quickfix.GroupTemplate{}
-
-					groupField := sFieldDefs.Find(parent)
-					if groupField == nil {
-						fmt.Printf("ERROR GROUP[%d]\n", tag)
-						return template
-					}
-
-					for _, subField := range groupField.Fields {
-						fieldDesc := b.dictionary.FieldTypeByTag[subField.Tag()]
-						if subField.Tag() == int(parent) {
-							template = append(template, quickfix.GroupElement(quickfix.Tag(subField.Tag())))
-						}
-						if fieldDesc.Type == "NUMINGROUP" {
-							template = append(template, quickfix.GroupElement(quickfix.Tag(subField.Tag())))
-							subTemplate := getChildFieldTags(quickfix.Tag(subField.Tag()), NewFieldDefsFromArr(groupField.Fields))
-							template = append(template, subTemplate...)
-						} else {
-							template = append(template, quickfix.GroupElement(quickfix.Tag(subField.Tag())))
-						}
-					}
-
-					return template
-				}
-				template := getChildFieldTags(tag, fieldDefs)
-
-				groupField := fieldDefs.Find(tag)
-				if groupField == nil {
-					fmt.Printf("ERROR GROUP[%d]\n", tag)
-					continue
-				}
-				group := quickfix.NewRepeatingGroup(tag, template)
-				err := fm.GetGroup(group)
-				if err != nil {
-					res += fmt.Sprintf("ERROR REPEATING GROUP[%d] of [%v]\n", tag, template)
-					continue
-				}
-
-				for i := 0; i < group.Len(); i++ {
-					g := group.Get(i)
-					idx := i
-					if group.Len() == 1 {
-						idx = -1
-					}
-					res += b.BeautifyFieldMap(g.FieldMap, NewFieldDefsFromArr(groupField.Fields), fixMessagePart_Group, prefix+printPrefixInc, idx)
-				}
-
-			default:
-				strValue := fieldDesc.Enums[string(value)]
-				res += b.BeautifyField(tag, fieldDesc.Name(), value, strValue.Description, prefix, index)
-			}
-		} else {
-			res += fmt.Sprintf("ERROR: TAG[%d]=VALUE[%s]\n", tag, value)
-		}
-		index = -1
+func (p *prettyBuilder) write(line string) {
+	p.res.WriteString(line)
+	p.index = -1
+}
+
+func (p *prettyBuilder) OnField(fieldType *datadictionary.FieldType, value []byte) {
+	desc := fieldType.Enums[string(value)].Description
+	p.write(p.log.BeautifyField(quickfix.Tag(fieldType.Tag()), fieldType.Name(), value, desc, p.prefix, p.index))
+}
+
+func (p *prettyBuilder) OnUnknownField(tag quickfix.Tag, value []byte) {
+	p.write(fmt.Sprintf("ERROR: TAG[%d]=VALUE[%s]\n", tag, value))
+}
+
+func (p *prettyBuilder) OnComponentStart(name string) {
+	p.write(p.log.beautifyLine("[    ]", "<"+name+">", "", p.prefix, p.index))
+	p.components = append(p.components, name)
+	p.prefix += printPrefixInc
+}
+
+func (p *prettyBuilder) OnComponentEnd(name string) {
+	p.components = p.components[:len(p.components)-1]
+	p.prefix -= printPrefixInc
+}
+
+func (p *prettyBuilder) OnGroupStart(fieldType *datadictionary.FieldType, count int) {
+	// a group is described by its component, e.g. NoLegs of InstrmtLegExecGrp
+	desc := ""
+	if len(p.components) > 0 {
+		desc = p.components[len(p.components)-1]
 	}
+	p.write(p.log.BeautifyField(quickfix.Tag(fieldType.Tag()), fieldType.Name(), quickfix.FIXBytes(fmt.Sprint(count)), desc, p.prefix, p.index))
+	p.counts = append(p.counts, count)
+	p.prefix += printPrefixInc
+}
 
-	return res
+func (p *prettyBuilder) OnGroupEntryStart(index int) {
+	if p.counts[len(p.counts)-1] > 1 {
+		p.index = index
+	}
+}
+
+func (p *prettyBuilder) OnGroupEntryEnd(index int) {
+	p.index = -1
+}
+
+func (p *prettyBuilder) OnGroupEnd(fieldType *datadictionary.FieldType) {
+	p.counts = p.counts[:len(p.counts)-1]
+	p.prefix -= printPrefixInc
 }
 
 // log incoming fix message
 func (b BeautyLog) OnIncoming(raw []byte) {
-	b.Log.OnIncoming(b.BeautifyFIX(raw))
+	if r, ok := b.Log.(rawLog); ok {
+		r.OnIncomingRaw(b.options.RedactRaw(raw))
+	}
+	if text := b.BeautifyFIX(raw); text != nil {
+		b.Log.OnIncoming(text)
+	}
 }
 
 // log outgoing fix message
 func (b BeautyLog) OnOutgoing(raw []byte) {
-	b.Log.OnOutgoing(b.BeautifyFIX(raw))
+	if r, ok := b.Log.(rawLog); ok {
+		r.OnOutgoingRaw(b.options.RedactRaw(raw))
+	}
+	if text := b.BeautifyFIX(raw); text != nil {
+		b.Log.OnOutgoing(text)
+	}
 }
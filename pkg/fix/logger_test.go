@@ -0,0 +1,60 @@
+package fix
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/*.golden with the current output")
+
+// TestBeautifyFIXGolden renders testdata/*.fix (a message with `|` separators) in the pretty format and compares it to
+// its .golden file
+func TestBeautifyFIXGolden(t *testing.T) {
+	dictionary, err := AppDictionary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := ParseLogOptions(dictionary, string(LogFormat_PRETTY), "Password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := BeautyLog{sessionName: "test", dictionary: dictionary, options: options}
+
+	files, err := filepath.Glob("testdata/*.fix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no testdata/*.fix")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".fix")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw = bytes.ReplaceAll(bytes.TrimSpace(raw), []byte("|"), []byte("\x01"))
+			got := log.BeautifyFIX(raw)
+
+			golden := strings.TrimSuffix(file, ".fix") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s, run with -update if intended:\n%s", golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/testrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+const (
+	metricsAckTimeout = time.Minute // unacknowledged requests are forgotten after it
+	rttTestReqPrefix  = "rtt-"
+)
+
+var (
+	metricsAddrCmd = flag.String("metrics", "", "Metrics: serve Prometheus /metrics on this address, e.g. ':9100'")
+	metricsRTTCmd  = flag.Duration("mrtt", 30*time.Second, "Metrics: send TestRequest to measure heartbeat RTT this often (0 = only TestRequests of the session)")
+
+	DefaultMetrics = NewMetrics()
+
+	metricsServerOnce sync.Once
+	metricsServerErr  error
+)
+
+// Metrics are Prometheus metrics of FIX sessions and their orders
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	connected    *prometheus.GaugeVec
+	logons       *prometheus.CounterVec
+	messages     *prometheus.CounterVec
+	rejects      *prometheus.CounterVec
+	orderAck     *prometheus.HistogramVec
+	openOrders   *prometheus.GaugeVec
+	heartbeatRTT *prometheus.HistogramVec
+
+	mu         sync.Mutex
+	pending    map[string]pendingRequest              // ClOrdID -> request waiting for its ack
+	testReqs   map[string]time.Time                   // TestReqID -> sent at
+	open       map[quickfix.SessionID]map[string]bool // session -> OrderIDs of working orders
+	loggedOn   map[quickfix.SessionID]bool
+	lastPruned time.Time
+}
+
+type pendingRequest struct {
+	msgType string
+	sentAt  time.Time
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		connected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "fix_session_connected",
+			Help: "1 if the session is logged on",
+		}, []string{"session"}),
+		logons: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fix_session_logons_total",
+			Help: "Logons of the session",
+		}, []string{"session"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fix_messages_total",
+			Help: "Messages by direction (in/out) and MsgType",
+		}, []string{"session", "direction", "msg_type"}),
+		rejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fix_rejects_total",
+			Help: "Rejects received by MsgType and reason (SessionRejectReason, BusinessRejectReason, OrdRejReason or CxlRejReason)",
+		}, []string{"session", "msg_type", "reason"}),
+		orderAck: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fix_order_ack_seconds",
+			Help:    "Latency from sending an order request (D/F/G) to its first ExecutionReport or OrderCancelReject",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+		}, []string{"session", "msg_type"}),
+		openOrders: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "fix_open_orders",
+			Help: "Working orders reported by ExecutionReports",
+		}, []string{"session"}),
+		heartbeatRTT: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fix_heartbeat_rtt_seconds",
+			Help:    "Round trip from TestRequest to its Heartbeat",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+		}, []string{"session"}),
+		pending:  make(map[string]pendingRequest),
+		testReqs: make(map[string]time.Time),
+		open:     make(map[quickfix.SessionID]map[string]bool),
+		loggedOn: make(map[quickfix.SessionID]bool),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connected, m.logons, m.messages, m.rejects, m.orderAck, m.openOrders, m.heartbeatRTT,
+	)
+	return m
+}
+
+// StartMetricsServer serves DefaultMetrics on `-metrics`, once per process. Nothing is served without the flag.
+func StartMetricsServer() error {
+	if *metricsAddrCmd == "" {
+		return nil
+	}
+	metricsServerOnce.Do(func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(DefaultMetrics.Registry, promhttp.HandlerOpts{}))
+
+		server := &http.Server{Addr: *metricsAddrCmd, Handler: mux}
+		ready := make(chan error, 1)
+		go func() {
+			ready <- server.ListenAndServe()
+		}()
+		// bind errors (e.g. address in use) are returned immediately
+		select {
+		case metricsServerErr = <-ready:
+		case <-time.After(100 * time.Millisecond):
+			fmt.Printf("Metrics: http://%s/metrics\n", *metricsAddrCmd)
+		}
+
+		if *metricsRTTCmd > 0 {
+			go DefaultMetrics.probeRTT(*metricsRTTCmd)
+		}
+	})
+	return metricsServerErr
+}
+
+func (m *Metrics) OnLogon(sessionID quickfix.SessionID) {
+	session := sessionID.String()
+	m.connected.WithLabelValues(session).Set(1)
+	m.logons.WithLabelValues(session).Inc()
+
+	m.mu.Lock()
+	m.loggedOn[sessionID] = true
+	m.mu.Unlock()
+}
+
+func (m *Metrics) OnLogout(sessionID quickfix.SessionID) {
+	m.connected.WithLabelValues(sessionID.String()).Set(0)
+
+	m.mu.Lock()
+	delete(m.loggedOn, sessionID)
+	m.mu.Unlock()
+}
+
+// OnOutgoing counts a message sent and starts timing order requests and TestRequests
+func (m *Metrics) OnOutgoing(msg *quickfix.Message, sessionID quickfix.SessionID) {
+	msgType, _ := msg.MsgType()
+	m.messages.WithLabelValues(sessionID.String(), "out", msgType).Inc()
+
+	now := time.Now()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_ORDER_SINGLE, enum.MsgType_ORDER_CANCEL_REQUEST, enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST:
+		clOrdID := getString(msg.Body, tag.ClOrdID)
+		if clOrdID == "" {
+			return
+		}
+		m.mu.Lock()
+		m.pending[clOrdID] = pendingRequest{msgType: msgType, sentAt: now}
+		m.pruneLocked(now)
+		m.mu.Unlock()
+
+	case enum.MsgType_TEST_REQUEST:
+		testReqID := getString(msg.Body, tag.TestReqID)
+		m.mu.Lock()
+		m.testReqs[testReqID] = now
+		m.mu.Unlock()
+	}
+}
+
+// pruneLocked forgets requests which were never acknowledged
+func (m *Metrics) pruneLocked(now time.Time) {
+	if now.Sub(m.lastPruned) < metricsAckTimeout {
+		return
+	}
+	m.lastPruned = now
+	for clOrdID, request := range m.pending {
+		if now.Sub(request.sentAt) > metricsAckTimeout {
+			delete(m.pending, clOrdID)
+		}
+	}
+	for testReqID, sentAt := range m.testReqs {
+		if now.Sub(sentAt) > metricsAckTimeout {
+			delete(m.testReqs, testReqID)
+		}
+	}
+}
+
+// OnIncoming counts a message received, rejects, acks, heartbeat RTT and open orders
+func (m *Metrics) OnIncoming(msg *quickfix.Message, sessionID quickfix.SessionID) {
+	now := time.Now()
+	session := sessionID.String()
+	msgType, _ := msg.MsgType()
+	m.messages.WithLabelValues(session, "in", msgType).Inc()
+
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_REJECT:
+		m.rejects.WithLabelValues(session, msgType, getString(msg.Body, tag.SessionRejectReason)).Inc()
+
+	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
+		m.rejects.WithLabelValues(session, msgType, getString(msg.Body, tag.BusinessRejectReason)).Inc()
+
+	case enum.MsgType_ORDER_CANCEL_REJECT:
+		m.rejects.WithLabelValues(session, msgType, getString(msg.Body, tag.CxlRejReason)).Inc()
+		m.observeAck(session, getString(msg.Body, tag.ClOrdID), now)
+
+	case enum.MsgType_EXECUTION_REPORT:
+		if enum.ExecType(getString(msg.Body, tag.ExecType)) == enum.ExecType_REJECTED {
+			m.rejects.WithLabelValues(session, msgType, getString(msg.Body, tag.OrdRejReason)).Inc()
+		}
+		m.observeAck(session, getString(msg.Body, tag.ClOrdID), now)
+		m.updateOpenOrders(msg, sessionID)
+
+	case enum.MsgType_HEARTBEAT:
+		testReqID := getString(msg.Body, tag.TestReqID)
+		if testReqID == "" {
+			return
+		}
+		m.mu.Lock()
+		sentAt, found := m.testReqs[testReqID]
+		delete(m.testReqs, testReqID)
+		m.mu.Unlock()
+		if found {
+			m.heartbeatRTT.WithLabelValues(session).Observe(now.Sub(sentAt).Seconds())
+		}
+	}
+}
+
+func (m *Metrics) observeAck(session string, clOrdID string, now time.Time) {
+	m.mu.Lock()
+	request, found := m.pending[clOrdID]
+	delete(m.pending, clOrdID)
+	m.mu.Unlock()
+
+	if found {
+		m.orderAck.WithLabelValues(session, request.msgType).Observe(now.Sub(request.sentAt).Seconds())
+	}
+}
+
+func (m *Metrics) updateOpenOrders(msg *quickfix.Message, sessionID quickfix.SessionID) {
+	orderID := getString(msg.Body, tag.OrderID)
+	if orderID == "" || orderID == "NONE" {
+		return
+	}
+	order := TrackedOrder{OrdStatus: enum.OrdStatus(getString(msg.Body, tag.OrdStatus))}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	open := m.open[sessionID]
+	if open == nil {
+		open = make(map[string]bool)
+		m.open[sessionID] = open
+	}
+	if order.IsWorking() {
+		open[orderID] = true
+	} else {
+		delete(open, orderID)
+	}
+	m.openOrders.WithLabelValues(sessionID.String()).Set(float64(len(open)))
+}
+
+// probeRTT sends TestRequests to logged on sessions, their Heartbeats give the RTT
+func (m *Metrics) probeRTT(interval time.Duration) {
+	for range time.Tick(interval) {
+		m.mu.Lock()
+		sessions := make([]quickfix.SessionID, 0, len(m.loggedOn))
+		for sessionID := range m.loggedOn {
+			sessions = append(sessions, sessionID)
+		}
+		m.mu.Unlock()
+
+		for _, sessionID := range sessions {
+			testReqID := fmt.Sprint(rttTestReqPrefix, pt.DefaultTokenGenerator.Next())
+			request := testrequest.New(field.NewTestReqID(testReqID))
+			if err := quickfix.SendToTarget(request, sessionID); err != nil {
+				fmt.Printf("Metrics: TestRequest: %v\n", err)
+			}
+		}
+	}
+}
+
+// metricsApp feeds DefaultMetrics from the callbacks of an application
+type metricsApp struct {
+	ApplicationWithWait
+}
+
+func (a metricsApp) OnLogon(sessionID quickfix.SessionID) {
+	DefaultMetrics.OnLogon(sessionID)
+	a.ApplicationWithWait.OnLogon(sessionID)
+}
+
+func (a metricsApp) OnLogout(sessionID quickfix.SessionID) {
+	DefaultMetrics.OnLogout(sessionID)
+	a.ApplicationWithWait.OnLogout(sessionID)
+}
+
+func (a metricsApp) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) {
+	a.ApplicationWithWait.ToAdmin(msg, sessionID)
+	DefaultMetrics.OnOutgoing(msg, sessionID)
+}
+
+func (a metricsApp) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) error {
+	err := a.ApplicationWithWait.ToApp(msg, sessionID)
+	if err == nil {
+		DefaultMetrics.OnOutgoing(msg, sessionID)
+	}
+	return err
+}
+
+func (a metricsApp) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	DefaultMetrics.OnIncoming(msg, sessionID)
+	return a.ApplicationWithWait.FromAdmin(msg, sessionID)
+}
+
+func (a metricsApp) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	DefaultMetrics.OnIncoming(msg, sessionID)
+	return a.ApplicationWithWait.FromApp(msg, sessionID)
+}
@@ -3,8 +3,6 @@ package fix
 import (
 	"flag"
 	"fmt"
-	"reflect"
-	"runtime"
 	"strings"
 	"time"
 
@@ -12,7 +10,6 @@ import (
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/field"
 	"github.com/quickfixgo/fix44/heartbeat"
-	"github.com/quickfixgo/fix44/newordermultileg"
 	"github.com/quickfixgo/fix44/newordersingle"
 	"github.com/quickfixgo/fix44/ordercancelrequest"
 	"github.com/quickfixgo/quickfix"
@@ -22,20 +19,26 @@ import (
 
 type FIXExampleAction = func() *quickfix.Message
 
+// FIXNamedAction is an action selectable by name with `-c`
+type FIXNamedAction struct {
+	Name   string
+	Action FIXExampleAction
+}
+
 var (
 	lastMessageClOrdId = ""
 
-	possibleActionsOE = []FIXExampleAction{
-		addOrder,
-		addOrderMatch,
-		cancelOrder, // Cancel existing order
-		cancelOrder, // Cancel unknown order
-		addOrderMultiLeg,
-		addOrderExecInst,
-		sendHB,
+	possibleActionsOE = []FIXNamedAction{
+		{"addOrder", addOrder},
+		{"addOrderMatch", addOrderMatch},
+		{"cancelOrder", cancelOrder}, // Cancel existing order
+		{"cancelOrder", cancelOrder}, // Cancel unknown order
+		{"addOrderMultiLeg", addOrderMultiLeg},
+		{"addOrderExecInst", addOrderExecInst},
+		{"sendHB", sendHB},
 	}
 
-	actionsCmd = flag.String("c", "", "Action list") // addOrder,cancelOrder,addOrderTrigger
+	actionsCmd = flag.String("c", "", "Action list, e.g. 'addOrder,cancelOrder' (order_entry: addOrder, addOrderMatch, cancelOrder, addOrderMultiLeg, addOrderExecInst, sendHB; security_list: securityListRequest, securityDefinitionRequest). See -sc for scripted scenarios")
 )
 
 func addOrder() *quickfix.Message {
@@ -100,29 +103,26 @@ func cancelOrder() *quickfix.Message {
 func addOrderMultiLeg() *quickfix.Message {
 	clOrdId := fmt.Sprint(pt.DefaultTokenGenerator.Next())
 
-	lastMessageClOrdId = clOrdId // Store for cancel
-
-	order := newordermultileg.New(
-		field.NewClOrdID(clOrdId), // ToDo: FIX server should allow a non-duplicate char[19], not only increasing int56
-		field.NewSide(enum.Side_BUY),
-		field.NewTransactTime(time.Now()),
-		field.NewOrdType(enum.OrdType_LIMIT),
+	builder := NewStrategyBuilder(DefaultInstruments)
+	strategy, err := builder.Custom(
+		StrategyLeg{Symbol: "BTC-USD", Ratio: decimal.NewFromInt(1)},
+		StrategyLeg{Symbol: "ETH-USD", Ratio: decimal.NewFromInt(-1)},
 	)
+	if err != nil {
+		fmt.Printf("addOrderMultiLeg: %v\n", err)
+		return nil
+	}
+
+	lastMessageClOrdId = clOrdId // Store for cancel
 
-	// !! ExecutionReport may have Legs sorted in different way (and reverted Side to have 1st Leg's Ratio > 0)
-	Legs := newordermultileg.NewNoLegsRepeatingGroup()
-	Legs.Add()
-	Legs.Get(0).Set(field.NewLegSymbol("BTC-USD"))
-	Legs.Get(0).Set(field.NewLegRatioQty(decimal.NewFromInt(1), 0))
-	Legs.Add()
-	Legs.Get(1).Set(field.NewLegSymbol("ETH-USD"))
-	Legs.Get(1).Set(field.NewLegRatioQty(decimal.NewFromInt(-1), 0))
-	order.SetGroup(Legs)
+	// ExecutionReport has Legs sorted in different way (and reverted Side to have 1st Leg's Ratio > 0),
+	// so the canonical form is sent and reports are mapped back by DefaultStrategyOrders
+	strategyOrder := builder.NewOrder(clOrdId, strategy, enum.Side_BUY, decimal.NewFromFloat(0.1), decimal.NewFromInt(16))
+	DefaultStrategyOrders.Add(strategyOrder)
 
+	order := strategyOrder.NewOrderMultileg()
 	order.SetSymbolSfx("none") // `market_id=none`, i.e. it is an RFQ order
 
-	order.Set(field.NewOrderQty(decimal.NewFromFloat(0.1), 4))
-	order.Set(field.NewPrice(decimal.NewFromInt(16), 2))
 	order.Set(field.NewTimeInForce(enum.TimeInForce_GOOD_TILL_DATE))
 	order.Set(field.NewExpireTime(time.Now().AddDate(0, 0, 1)))
 
@@ -157,29 +157,41 @@ func sendHB() *quickfix.Message {
 	return hrtbt.ToMessage()
 }
 
-func getActions(possibleActions []FIXExampleAction) []FIXExampleAction {
-	actionCmds := strings.Split(*actionsCmd, ",")
-	if len(actionCmds) == 0 || actionCmds[0] == "" {
-		return possibleActions
+func getActions(possibleActions []FIXNamedAction) ([]FIXExampleAction, error) {
+	actions := make([]FIXExampleAction, 0)
+	if *actionsCmd == "" {
+		for _, action := range possibleActions {
+			actions = append(actions, action.Action)
+		}
+		return actions, nil
 	}
 
 	possibleActionMap := make(map[string]FIXExampleAction)
 	for _, action := range possibleActions {
-		fullname := runtime.FuncForPC(reflect.ValueOf(action).Pointer()).Name()
-		fullnames := strings.Split(fullname, ".")
-		name := fullnames[len(fullnames)-1]
-		possibleActionMap[name] = action
+		possibleActionMap[action.Name] = action.Action
 	}
 
-	actions := make([]FIXExampleAction, 0)
-	for _, actionCmd := range actionCmds {
-		action := possibleActionMap[actionCmd]
+	for _, actionCmd := range strings.Split(*actionsCmd, ",") {
+		action := possibleActionMap[strings.TrimSpace(actionCmd)]
 		if action == nil {
-			panic(fmt.Sprintf("Unknown action: '%s'", actionCmd))
+			return nil, fmt.Errorf("unknown action: '%s'", actionCmd)
 		}
 		actions = append(actions, action)
 	}
-	return actions
+	return actions, nil
+}
+
+// usesAction is true if `-c` selects the action, all actions are used without `-c`
+func usesAction(name string) bool {
+	if *actionsCmd == "" {
+		return true
+	}
+	for _, actionCmd := range strings.Split(*actionsCmd, ",") {
+		if strings.TrimSpace(actionCmd) == name {
+			return true
+		}
+	}
+	return false
 }
 
 func RunOrderEntry(cfgFileName string, apiKeyName string) error {
@@ -187,6 +199,9 @@ func RunOrderEntry(cfgFileName string, apiKeyName string) error {
 	if err != nil {
 		return err
 	}
+	if *recoverOrdersCmd {
+		EnableOrderRecovery(app)
+	}
 
 	err = StartConnection(app, app.Settings)
 	if err != nil {
@@ -194,72 +209,61 @@ func RunOrderEntry(cfgFileName string, apiKeyName string) error {
 	}
 	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
 
-	actions := getActions(possibleActionsOE)
-	for {
-		for _, action := range actions {
-			time.Sleep(time.Second)
-
-			msg := action()
-			msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
-			msg.Header.Set(field.NewTargetCompID(targetCompID))
-
-			fmt.Printf("Sending: %s\n", msg.String())
-
-			err := quickfix.Send(msg)
+	// strategies are canonicalized and buying power is checked with instruments
+	if usesAction("addOrderMultiLeg") || *buyingPowerCmd {
+		err = LoadInstruments(app, targetCompID)
+		if err != nil {
+			return err
+		}
+	}
 
-			if err != nil {
-				return err
-			}
+	if app.RecoverOrders {
+		err = WaitOrderRecovery()
+		if err != nil {
+			return err
 		}
 	}
+
+	return runOrderEntryActions(app, targetCompID)
 }
 
-func RunOrderEntryManual(cfgFileName string, apiKeyName string) error {
-	app, err := NewTradeClient(cfgFileName, apiKeyName)
-	if err != nil {
-		return err
+// runOrderEntryActions sends actions selected by `-c` in a loop, one per second
+func runOrderEntryActions(app *TradeClient, targetCompID string) error {
+	if *buyingPowerCmd {
+		err := LoadBalances(app, targetCompID)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = StartConnection(app, app.Settings)
+	actions, err := getActions(possibleActionsOE)
 	if err != nil {
 		return err
 	}
-	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
-
 	for {
-		time.Sleep(time.Second)
-
-		//clOrdId := fmt.Sprint(pt.DefaultTokenGenerator.Next())
-		clOrdId := "123321"
-
-		lastMessageClOrdId = clOrdId // Store for cancel
-
-		order := newordersingle.New(
-			field.NewClOrdID(clOrdId), // ToDo: FIX server should allow a non-duplicate char[19], not only increasing int56
-			field.NewSide(enum.Side_BUY),
-			field.NewTransactTimeWithPrecision(time.Now(), quickfix.Nanos),
-			field.NewOrdType(enum.OrdType_LIMIT),
-		)
-		//order.Set(field.NewSendingTime(time.Now()))
-		order.Set(field.NewSymbol("BTC-USD-PERPETUAL"))
-		order.Set(field.NewOrderQty(decimal.NewFromFloat(0.01), 2))
-
-		order.Set(field.NewPrice(decimal.NewFromFloat(1), 0))
-
-		order.Set(field.NewTimeInForce(enum.TimeInForce_GOOD_TILL_CANCEL))
-		//order.Set(field.NewExpireTimeWithPrecision(time.Now().AddDate(0, 0, 1), quickfix.Nanos))
-
-		msg := order.ToMessage()
+		for _, action := range actions {
+			time.Sleep(time.Second)
 
-		msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
-		msg.Header.Set(field.NewTargetCompID(targetCompID))
+			msg := action()
+			if msg == nil {
+				continue
+			}
+			if *buyingPowerCmd {
+				if err := DefaultBalances.CheckOrder(msg); err != nil {
+					fmt.Printf("Pre-trade: %v\n", err)
+					continue
+				}
+			}
+			msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
+			msg.Header.Set(field.NewTargetCompID(targetCompID))
 
-		fmt.Printf("Sending: %s\n", msg.String())
+			fmt.Printf("Sending: %s\n", msg.String())
 
-		err := quickfix.Send(msg)
+			err := Send(msg)
 
-		if err != nil {
-			return err
+			if err != nil {
+				return err
+			}
 		}
 	}
 }
@@ -0,0 +1,426 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/ordercancelrequest"
+	"github.com/quickfixgo/fix44/ordermassstatusrequest"
+	"github.com/quickfixgo/fix44/orderstatusrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	orderRecoveryTimeout = 30 * time.Second
+	orderStatusTimeout   = 10 * time.Second
+
+	// requests unanswered for this long are forgotten once more than sentAtPruneSize are pending
+	sentAtTimeout   = time.Minute
+	sentAtPruneSize = 1024
+
+	// orders no longer working are forgotten this long after their last ExecutionReport,
+	// drop copy sessions see every order of the account
+	closedOrderTimeout = 10 * time.Minute
+)
+
+var (
+	recoverOrdersCmd = flag.Bool("rec", false, "Order entry: recover working orders with OrderMassStatusRequest after Logon, before trading")
+
+	DefaultOrderTracker = NewOrderTracker()
+)
+
+// TrackedOrder is the latest known state of an order
+type TrackedOrder struct {
+	OrderID   string
+	ClOrdID   string
+	Symbol    string
+	Side      enum.Side
+	OrdType   enum.OrdType
+	OrderQty  decimal.Decimal
+	Price     decimal.Decimal
+	CumQty    decimal.Decimal
+	LeavesQty decimal.Decimal
+	AvgPx     decimal.Decimal
+	OrdStatus enum.OrdStatus
+	UpdatedAt time.Time
+
+	// timestamps of the last request of the order and of its 1st ExecutionReport
+	SentAt            time.Time
+	VenueTransactTime time.Time
+	VenueSendingTime  time.Time
+}
+
+// LatencyBreakdown splits the round trip of the last request given the venue clock offset, see ClockOffset
+func (o *TrackedOrder) LatencyBreakdown(offset time.Duration) (outbound, venue, inbound time.Duration, ok bool) {
+	if o.SentAt.IsZero() || o.VenueTransactTime.IsZero() || o.VenueSendingTime.IsZero() {
+		return 0, 0, 0, false
+	}
+	outbound, venue, inbound = splitLatency(o.SentAt, o.VenueTransactTime, o.VenueSendingTime, o.UpdatedAt, offset)
+	return outbound, venue, inbound, true
+}
+
+// IsWorking is false once no more executions are expected
+func (o *TrackedOrder) IsWorking() bool {
+	switch o.OrdStatus {
+	case enum.OrdStatus_FILLED, enum.OrdStatus_CANCELED, enum.OrdStatus_REJECTED,
+		enum.OrdStatus_EXPIRED, enum.OrdStatus_DONE_FOR_DAY:
+		return false
+	}
+	return true
+}
+
+// NewOrderCancel is an OrderCancelRequest of a tracked order with a new ClOrdID, without session headers
+func NewOrderCancel(order TrackedOrder) *quickfix.Message {
+	cancel := ordercancelrequest.New(
+		field.NewOrigClOrdID(order.ClOrdID),
+		field.NewClOrdID(fmt.Sprint(pt.DefaultTokenGenerator.Next())),
+		field.NewSide(order.Side),
+		field.NewTransactTime(time.Now()),
+	)
+	cancel.Set(field.NewSymbol(order.Symbol))
+	if order.OrderID != "" {
+		cancel.Set(field.NewOrderID(order.OrderID))
+	}
+	return cancel.ToMessage()
+}
+
+// OrderTracker keeps orders of the session up to date from ExecutionReports, including status reports (ExecType=I)
+type OrderTracker struct {
+	mu            sync.RWMutex
+	byOrderID     map[string]*TrackedOrder
+	byClOrdID     map[string]*TrackedOrder
+	massStatusID  string // MassStatusReqID of the recovery in progress
+	statusReports int
+	recovered     chan struct{}                // closed once the recovery snapshot is complete
+	statusWaiters map[string]chan TrackedOrder // OrdStatusReqID -> waiter
+	sentAt        map[string]time.Time         // ClOrdID -> request sent, until its ExecutionReport
+	prunedAt      time.Time
+}
+
+func NewOrderTracker() *OrderTracker {
+	t := &OrderTracker{
+		byOrderID:     make(map[string]*TrackedOrder),
+		byClOrdID:     make(map[string]*TrackedOrder),
+		recovered:     make(chan struct{}),
+		statusWaiters: make(map[string]chan TrackedOrder),
+		sentAt:        make(map[string]time.Time),
+	}
+	close(t.recovered)
+	return t
+}
+
+// Get finds an order by OrderID or ClOrdID
+func (t *OrderTracker) Get(id string) (TrackedOrder, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	order := t.byOrderID[id]
+	if order == nil {
+		order = t.byClOrdID[id]
+	}
+	if order == nil {
+		return TrackedOrder{}, false
+	}
+	return *order, true
+}
+
+// Working returns orders still working sorted by OrderID
+func (t *OrderTracker) Working() []TrackedOrder {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	orders := make([]TrackedOrder, 0)
+	for _, order := range t.byOrderID {
+		if order.IsWorking() {
+			orders = append(orders, *order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].OrderID < orders[j].OrderID
+	})
+	return orders
+}
+
+// printLatencyBreakdown prints the split of the round trip of the last request of an order,
+// with the venue clock offset if estimated (-pclk of perf sessions)
+func printLatencyBreakdown(w io.Writer, order TrackedOrder) {
+	offset, rtt, hasOffset := DefaultClockOffset.Offset()
+	outbound, venue, inbound, ok := order.LatencyBreakdown(offset)
+	if !ok {
+		return
+	}
+	clock := "venue clock offset unknown"
+	if hasOffset {
+		clock = fmt.Sprintf("venue clock offset %v, RTT %v", offset, rtt)
+	}
+	fmt.Fprintf(w, "Latency ClOrdID=%s: outbound %v venue %v inbound %v (%s)\n", order.ClOrdID, outbound, venue, inbound, clock)
+}
+
+// OnSent keeps the SendingTime of an order request (D/F/G/AB) for the latency breakdown, now if the header has none.
+// Other messages are ignored.
+func (t *OrderTracker) OnSent(msg *quickfix.Message, now time.Time) {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_ORDER_SINGLE, enum.MsgType_ORDER_CANCEL_REQUEST, enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST, enum.MsgType_NEW_ORDER_MULTILEG:
+	default:
+		return
+	}
+	clOrdID := getString(msg.Body, tag.ClOrdID)
+	sentAt, err := msg.Header.GetTime(tag.SendingTime)
+	if err != nil {
+		sentAt = now
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.sentAt) >= sentAtPruneSize {
+		for id, at := range t.sentAt {
+			if now.Sub(at) > sentAtTimeout {
+				delete(t.sentAt, id)
+			}
+		}
+	}
+	t.sentAt[clOrdID] = sentAt
+}
+
+// FromReject forgets the request of a BusinessMessageReject or OrderCancelReject, no ExecutionReport will answer it
+func (t *OrderTracker) FromReject(msg *quickfix.Message) {
+	msgType, _ := msg.MsgType()
+	var clOrdID string
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
+		clOrdID = getString(msg.Body, tag.BusinessRejectRefID)
+	case enum.MsgType_ORDER_CANCEL_REJECT:
+		clOrdID = getString(msg.Body, tag.ClOrdID)
+	default:
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sentAt, clOrdID)
+}
+
+// FromExecutionReport updates the order of an ExecutionReport, other messages are ignored.
+// acked is true for the 1st report of a request sent by the session, the order then has its latency timestamps.
+func (t *OrderTracker) FromExecutionReport(msg *quickfix.Message) (acked TrackedOrder, ok bool) {
+	msgType, _ := msg.MsgType()
+	if enum.MsgType(msgType) != enum.MsgType_EXECUTION_REPORT {
+		return
+	}
+	exec := DecodeExecution(msg, time.Now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(exec.ReceivedAt)
+
+	var order *TrackedOrder
+	if exec.OrderID != "" && exec.OrderID != "NONE" {
+		order = t.byOrderID[exec.OrderID]
+		if order == nil {
+			order = &TrackedOrder{OrderID: exec.OrderID}
+			t.byOrderID[exec.OrderID] = order
+		}
+		if exec.ClOrdID != "" {
+			order.ClOrdID = exec.ClOrdID
+			t.byClOrdID[exec.ClOrdID] = order
+		}
+		if exec.Symbol != "" {
+			order.Symbol = exec.Symbol
+		}
+		if exec.Side != "" {
+			order.Side = exec.Side
+		}
+		if exec.OrdType != "" {
+			order.OrdType = exec.OrdType
+		}
+		if !exec.OrderQty.IsZero() {
+			order.OrderQty = exec.OrderQty
+		}
+		if !exec.Price.IsZero() {
+			order.Price = exec.Price
+		}
+		order.CumQty = exec.CumQty
+		order.LeavesQty = exec.LeavesQty
+		order.AvgPx = exec.AvgPx
+		order.OrdStatus = exec.OrdStatus
+		order.UpdatedAt = exec.ReceivedAt
+
+		if sentAt, found := t.sentAt[exec.ClOrdID]; found {
+			delete(t.sentAt, exec.ClOrdID)
+			order.SentAt = sentAt
+			order.VenueTransactTime = exec.TransactTime
+			order.VenueSendingTime, _ = msg.Header.GetTime(tag.SendingTime)
+			acked, ok = *order, true
+		}
+	}
+
+	if reqID := getString(msg.Body, tag.OrdStatusReqID); reqID != "" {
+		if waiter, found := t.statusWaiters[reqID]; found {
+			delete(t.statusWaiters, reqID)
+			if order != nil {
+				waiter <- *order
+			} else {
+				waiter <- TrackedOrder{ClOrdID: exec.ClOrdID, OrdStatus: exec.OrdStatus}
+			}
+		}
+	}
+
+	// only status reports of our request count, a snapshot without orders has TotNumReports=0
+	if !t.isRecoveredLocked() && t.massStatusID != "" && exec.ExecType == enum.ExecType_ORDER_STATUS &&
+		getString(msg.Body, tag.MassStatusReqID) == t.massStatusID {
+		t.statusReports++
+		total := getInt(msg.Body, tag.TotNumReports)
+		last, _ := msg.Body.GetBool(tag.LastRptRequested)
+		if last || (total >= 0 && t.statusReports >= total) {
+			close(t.recovered)
+		}
+	}
+	return
+}
+
+// pruneLocked forgets orders closed for closedOrderTimeout, at most once per closedOrderTimeout
+func (t *OrderTracker) pruneLocked(now time.Time) {
+	if now.Sub(t.prunedAt) < closedOrderTimeout {
+		return
+	}
+	t.prunedAt = now
+	for orderID, order := range t.byOrderID {
+		if !order.IsWorking() && now.Sub(order.UpdatedAt) > closedOrderTimeout {
+			delete(t.byOrderID, orderID)
+		}
+	}
+	// ClOrdIDs of all requests of a forgotten order
+	for clOrdID, order := range t.byClOrdID {
+		if t.byOrderID[order.OrderID] != order {
+			delete(t.byClOrdID, clOrdID)
+		}
+	}
+}
+
+// StartRecovery marks the tracker as not recovered until the last status report of the mass status request
+func (t *OrderTracker) StartRecovery(massStatusReqID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.massStatusID = massStatusReqID
+	t.statusReports = 0
+	if t.isRecoveredLocked() {
+		t.recovered = make(chan struct{})
+	}
+}
+
+func (t *OrderTracker) isRecoveredLocked() bool {
+	select {
+	case <-t.recovered:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitRecovered waits for the recovery snapshot to be complete
+func (t *OrderTracker) WaitRecovered(timeout time.Duration) bool {
+	t.mu.RLock()
+	recovered := t.recovered
+	t.mu.RUnlock()
+
+	select {
+	case <-recovered:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (t *OrderTracker) String() string {
+	orders := t.Working()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-20s %-20s %-24s %-4s %-6s %14s %14s %14s %14s\n", "OrderID", "ClOrdID", "Symbol", "Side", "Status", "OrderQty", "Price", "CumQty", "LeavesQty")
+	for _, o := range orders {
+		fmt.Fprintf(&b, "%-20s %-20s %-24s %-4s %-6s %14s %14s %14s %14s\n",
+			o.OrderID, o.ClOrdID, o.Symbol, o.Side, o.OrdStatus, o.OrderQty, o.Price, o.CumQty, o.LeavesQty,
+		)
+	}
+	fmt.Fprintf(&b, "Working orders: %d\n", len(orders))
+	return b.String()
+}
+
+// EnableOrderRecovery makes the client request status of all orders after each Logon,
+// the tracker is not recovered from now on so that trading waits for the first snapshot
+func EnableOrderRecovery(app *TradeClient) {
+	app.RecoverOrders = true
+	DefaultOrderTracker.StartRecovery("")
+}
+
+// sendOrderMassStatus requests status of all orders of a session, the tracker is not recovered until the last report.
+// It is called by OnLogon and doesn't wait for the throttle.
+func sendOrderMassStatus(sessionID quickfix.SessionID) {
+	reqID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	request := ordermassstatusrequest.New(
+		field.NewMassStatusReqID(reqID),
+		field.NewMassStatusReqType(enum.MassStatusReqType_STATUS_FOR_ALL_ORDERS),
+	)
+
+	DefaultOrderTracker.StartRecovery(reqID)
+	if err := SendToTargetAsync(request, sessionID); err != nil {
+		fmt.Printf("Order recovery: %v\n", err)
+	}
+}
+
+// RequestOrderStatus asks for the status of a single order and waits for its ExecutionReport
+func RequestOrderStatus(app *TradeClient, targetCompID string, clOrdID string, orderID string, symbol string, side enum.Side) (TrackedOrder, error) {
+	reqID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	request := orderstatusrequest.New(
+		field.NewClOrdID(clOrdID),
+		field.NewSide(side),
+	)
+	request.SetOrdStatusReqID(reqID)
+	request.SetSymbol(symbol)
+	if orderID != "" {
+		request.SetOrderID(orderID)
+	}
+
+	msg := request.ToMessage()
+	msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(targetCompID))
+
+	waiter := make(chan TrackedOrder, 1)
+	DefaultOrderTracker.mu.Lock()
+	DefaultOrderTracker.statusWaiters[reqID] = waiter
+	DefaultOrderTracker.mu.Unlock()
+	defer func() {
+		DefaultOrderTracker.mu.Lock()
+		delete(DefaultOrderTracker.statusWaiters, reqID)
+		DefaultOrderTracker.mu.Unlock()
+	}()
+
+	err := Send(msg)
+	if err != nil {
+		return TrackedOrder{}, err
+	}
+
+	select {
+	case order := <-waiter:
+		return order, nil
+	case <-time.After(orderStatusTimeout):
+		return TrackedOrder{}, fmt.Errorf("OrderStatusRequest %s: no response in %v", clOrdID, orderStatusTimeout)
+	}
+}
+
+// WaitOrderRecovery blocks trading until the recovery snapshot requested after Logon is complete
+func WaitOrderRecovery() error {
+	if !DefaultOrderTracker.WaitRecovered(orderRecoveryTimeout) {
+		return fmt.Errorf("order recovery: snapshot not complete in %v", orderRecoveryTimeout)
+	}
+	fmt.Printf("Order recovery complete\n%s\n", DefaultOrderTracker)
+	return nil
+}
@@ -0,0 +1,140 @@
+package fix
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Power-Trade/fix-api-clients/spec"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+)
+
+var (
+	specDirCmd    = flag.String("sd", "spec", "Directory of the dictionary and FIX configs of spec, embedded copies are used for missing files")
+	dictionaryCmd = flag.String("dict", "", "Dictionary XML, overrides DataDictionary of FIX configs (default "+FIX_XML_PATH+")")
+	keysDirCmd    = flag.String("k", "keys", "Directory of <api key>.api and <api key>.pem files")
+
+	extractedSpecs   = make(map[string]string) // name -> extracted file
+	extractedSpecsMu sync.Mutex
+)
+
+// dictionaryName is the dictionary of `-dict`, FIX_XML_PATH by default
+func dictionaryName() string {
+	if *dictionaryCmd != "" {
+		return *dictionaryCmd
+	}
+	return FIX_XML_PATH
+}
+
+// isBuiltinSpecPath is true for a dictionary or FIX config of spec given by name or as spec/<name>,
+// only these fall back to `-sd` and the embedded copies
+func isBuiltinSpecPath(path string) bool {
+	name := filepath.Base(path)
+	if path != name && filepath.Clean(path) != filepath.Join("spec", name) {
+		return false
+	}
+	_, err := fs.Stat(spec.FS, name)
+	return err == nil
+}
+
+// ReadSpecFile reads a dictionary or FIX config: the path as given, else for files of spec the same name in `-sd`,
+// or the embedded copy
+func ReadSpecFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if !errors.Is(err, fs.ErrNotExist) || !isBuiltinSpecPath(path) {
+		return data, err
+	}
+
+	name := filepath.Base(path)
+	data, specErr := os.ReadFile(filepath.Join(*specDirCmd, name))
+	if !errors.Is(specErr, fs.ErrNotExist) {
+		return data, specErr
+	}
+
+	data, embedErr := spec.FS.ReadFile(name)
+	if embedErr != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SpecFilePath returns a file of a dictionary or FIX config (looked up as ReadSpecFile does) for libraries reading paths.
+// Embedded copies are extracted to a temporary directory.
+func SpecFilePath(path string) (string, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return path, nil
+	}
+	if !isBuiltinSpecPath(path) {
+		return "", err
+	}
+	name := filepath.Base(path)
+	if specPath := filepath.Join(*specDirCmd, name); fileExists(specPath) {
+		return specPath, nil
+	}
+
+	extractedSpecsMu.Lock()
+	defer extractedSpecsMu.Unlock()
+
+	if extracted, found := extractedSpecs[name]; found {
+		return extracted, nil
+	}
+	data, err := spec.FS.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("open '%s': %v", path, fs.ErrNotExist)
+	}
+
+	dir := filepath.Join(os.TempDir(), "fix-api-clients")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// other processes may read the file while it is written
+	tmp, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	extracted := filepath.Join(dir, name)
+	if err == nil {
+		err = os.Rename(tmp.Name(), extracted)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	extractedSpecs[name] = extracted
+	return extracted, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// resolveDataDictionary points DataDictionary of settings to a readable file, `-dict` takes precedence
+func resolveDataDictionary(settings *quickfix.Settings) error {
+	global := settings.GlobalSettings()
+	if *dictionaryCmd != "" {
+		global.Set(config.DataDictionary, *dictionaryCmd)
+	}
+	if !global.HasSetting(config.DataDictionary) {
+		return nil
+	}
+
+	path, _ := global.Setting(config.DataDictionary)
+	path, err := SpecFilePath(path)
+	if err != nil {
+		return err
+	}
+	global.Set(config.DataDictionary, path)
+	return nil
+}
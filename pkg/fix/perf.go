@@ -3,30 +3,40 @@ package fix
 import (
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/latency"
 	"github.com/quickfixgo/enum"
-	"github.com/quickfixgo/field"
-	"github.com/quickfixgo/fix44/newordersingle"
-	"github.com/quickfixgo/fix44/ordercancelrequest"
 	"github.com/quickfixgo/quickfix"
 	"github.com/quickfixgo/quickfix/config"
 	"github.com/quickfixgo/tag"
-	"github.com/shopspring/decimal"
 )
 
 var (
-	uoCntCmd = flag.Int64("puo", 10000, "Perf: max count of unresponded orders")
+	uoCntCmd        = flag.Int64("puo", 10000, "Perf: max count of unresponded orders")
+	perfDurationCmd = flag.Duration("pd", 0, "Perf: duration of the run (0 = until interrupted)")
 )
 
 type OrderState int
 
 type OrderInfo struct {
-	State  OrderState
-	SentAt time.Time
+	State        OrderState
+	SentAt       time.Time
+	CancelSentAt time.Time
+	SendingTime  time.Time // of the NewOrderSingle, nanoseconds
+	IsReplace    bool      // OrderCancelReplaceRequest, its ack replaces the original order
+	clOrdID      string    // key of the tracker
+	origClOrdID  string    // of a replace
+
+	// the worker of the order and its template, to cancel it again
+	worker *perfWorker
+	symbol int
+	side   enum.Side
 }
 
 const (
@@ -34,6 +44,7 @@ const (
 	OrderState_SENT      OrderState = 1
 	OrderState_CREATED   OrderState = 2
 	OrderState_CANCELLED OrderState = 3
+	OrderState_REPLACED  OrderState = 4
 )
 
 var (
@@ -41,98 +52,141 @@ var (
 	cntCreated   atomic.Int64
 	cntClosed    atomic.Int64
 	cntBsnReject atomic.Int64
-	delayCreated time.Duration
 
-	activeOrders   = make(map[string]*OrderInfo) // ClOrdId -> OrderState
-	activeOrdersMu sync.Mutex
+	cntRejectedOrders atomic.Int64 // orders rejected by BusinessMessageReject before an ack
+
+	ackLatency     = latency.New() // NewOrderSingle -> ExecutionReport
+	cancelLatency  = latency.New() // OrderCancelRequest -> ExecutionReport(Canceled)
+	replaceLatency = latency.New() // OrderCancelReplaceRequest -> ExecutionReport(Replaced)
+	ackBreakdown   = NewLatencyBreakdown()
+
+	perfRejects   = make(map[string]int64) // MsgType/reason -> count
+	perfRejectsMu sync.Mutex
 )
 
 type PerfTradeClient struct {
 	*TradeClient
 }
 
+// ToApp keeps SendingTime of orders for the latency breakdown
 func (e PerfTradeClient) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) (err error) {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_ORDER_SINGLE, enum.MsgType_NEW_ORDER_MULTILEG:
+		sendingTime, _ := msg.Header.GetTime(tag.SendingTime)
+		activeOrders.Update(getBytes(&msg.Body, tag.ClOrdID), func(orderInfo OrderInfo) (OrderInfo, bool) {
+			orderInfo.SendingTime = sendingTime
+			return orderInfo, true
+		})
+	}
 	return
 }
 
+func (e PerfTradeClient) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	DefaultClockOffset.FromAdmin(msg)
+	return e.TradeClient.FromAdmin(msg, sessionID)
+}
+
 func (e PerfTradeClient) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
 	now := time.Now()
 
-	typeField := field.MsgTypeField{}
-	err = msg.Header.Get(&typeField)
+	// fields are read as bytes of &msg.Body (not a copy boxed in an interface), the hot path doesn't allocate
+	msgType, err := msg.Header.GetBytes(tag.MsgType)
 	if err != nil {
 		return
 	}
 
-	switch typeField.Value() {
+	switch enum.MsgType(msgType) {
 	case enum.MsgType_EXECUTION_REPORT:
-		clOrdIDStr := ""
-		ordStatus := field.OrdStatusField{}
-		err = msg.Body.Get(&ordStatus)
+		if enum.ExecType(getBytes(&msg.Body, tag.ExecType)) == enum.ExecType_REJECTED {
+			countPerfReject(enum.MsgType_EXECUTION_REPORT, getString(msg.Body, tag.OrdRejReason))
+		}
+
+		ordStatus, err := msg.Body.GetBytes(tag.OrdStatus)
 		if err != nil {
-			return
+			return err
 		}
+		isCanceled := enum.OrdStatus(ordStatus) == enum.OrdStatus_CANCELED
 
+		var clOrdID []byte
 		isOrderActive := true
-		if ordStatus.Value() == enum.OrdStatus_CANCELED {
+		if isCanceled {
 			isOrderActive = false
 
-			clOrdID := field.OrigClOrdIDField{}
-			err = msg.Body.Get(&clOrdID)
-			if err != nil {
-				return
+			// an IOC order is canceled by the venue without OrigClOrdID
+			clOrdID = getBytes(&msg.Body, tag.OrigClOrdID)
+			if len(clOrdID) == 0 {
+				clOrdID = getBytes(&msg.Body, tag.ClOrdID)
 			}
-			clOrdIDStr = clOrdID.Value()
 		} else {
-			leavesQtyStr, _ := msg.Body.GetString(tag.LeavesQty)
-			leavesQty, _ := decimal.NewFromString(leavesQtyStr)
-			if leavesQty.IsZero() {
+			if isZeroDecimal(getBytes(&msg.Body, tag.LeavesQty)) {
 				isOrderActive = false
 			}
 
-			clOrdID := field.ClOrdIDField{}
-			err = msg.Body.Get(&clOrdID)
+			clOrdID, err = msg.Body.GetBytes(tag.ClOrdID)
 			if err != nil {
-				return
+				return err
 			}
-			clOrdIDStr = clOrdID.Value()
 		}
 
-		activeOrdersMu.Lock()
-		defer activeOrdersMu.Unlock()
-
-		orderInfo := activeOrders[clOrdIDStr]
-		if orderInfo == nil {
-			orderInfo = &OrderInfo{
-				State:  OrderState_UNKNOWN,
-				SentAt: time.Time{},
-			}
-		}
+		// closed orders are forgotten, unknown ones were lost or are not ours
+		replaced := false
+		activeOrders.Update(clOrdID, func(orderInfo OrderInfo) (OrderInfo, bool) {
+			switch {
+			case orderInfo.State == OrderState_SENT && orderInfo.IsReplace:
+				replaceLatency.Record(now.Sub(orderInfo.SentAt))
+				orderInfo.State = OrderState_CREATED
+				replaced = true
 
-		switch orderInfo.State {
-		case OrderState_SENT:
-			cntCreated.Add(1)
-			delay := now.Sub(orderInfo.SentAt)
-			// fmt.Printf("Order[%s]: %s -> %s\n", clOrdIDStr, orderInfo.SentAt.Format(time.RFC3339Nano), delay.String())
-			delayCreated += delay
-			if !isOrderActive {
-				cntClosed.Add(1)
-				orderInfo.State = OrderState_CANCELLED
-			} else {
+			case orderInfo.State == OrderState_SENT:
+				cntCreated.Add(1)
+				ackLatency.Record(now.Sub(orderInfo.SentAt))
+				recordAckBreakdown(msg, &orderInfo, now)
 				orderInfo.State = OrderState_CREATED
+				if !isOrderActive {
+					recordCancelLatency(&orderInfo, isCanceled, now)
+				}
+
+			case orderInfo.State == OrderState_CREATED:
+				if !isOrderActive {
+					recordCancelLatency(&orderInfo, isCanceled, now)
+				}
 			}
-
-		case OrderState_CREATED:
 			if !isOrderActive {
 				cntClosed.Add(1)
 				orderInfo.State = OrderState_CANCELLED
 			}
-		case OrderState_CANCELLED:
-		default:
+			return orderInfo, isOrderActive
+		})
+		if replaced {
+			activeOrders.Remove(getBytes(&msg.Body, tag.OrigClOrdID))
 		}
 
 	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
 		cntBsnReject.Add(1)
+		countPerfReject(enum.MsgType_BUSINESS_MESSAGE_REJECT, getString(msg.Body, tag.BusinessRejectReason))
+
+		// a rejected order is not acked, it is forgotten not to be counted as lost too
+		var replace OrderInfo
+		isReplace := false
+		activeOrders.Update(getBytes(&msg.Body, tag.BusinessRejectRefID), func(orderInfo OrderInfo) (OrderInfo, bool) {
+			switch {
+			case orderInfo.State == OrderState_SENT && orderInfo.IsReplace:
+				replace, isReplace = orderInfo, true
+				return orderInfo, false
+			case orderInfo.State == OrderState_SENT:
+				cntRejectedOrders.Add(1)
+				return orderInfo, false
+			}
+			return orderInfo, true
+		})
+		if isReplace {
+			onPerfReplaceRejected(replace, now)
+		}
+
+	case enum.MsgType_ORDER_CANCEL_REJECT:
+		countPerfReject(enum.MsgType_ORDER_CANCEL_REJECT, getString(msg.Body, tag.CxlRejReason))
+		onPerfCancelReject(getBytes(&msg.Body, tag.ClOrdID), getBytes(&msg.Body, tag.OrigClOrdID), now)
 
 	default:
 	}
@@ -140,119 +194,231 @@ func (e PerfTradeClient) FromApp(msg *quickfix.Message, sessionID quickfix.Sessi
 	return
 }
 
-func PrintStat() {
-	timeSt := time.Now().UTC()
-	for {
-		time.Sleep(1 * time.Second)
-
-		now := time.Now().UTC()
-		dur := now.Sub(timeSt)
+func countPerfReject(msgType enum.MsgType, reason string) {
+	perfRejectsMu.Lock()
+	perfRejects[string(msgType)+"/"+reason]++
+	perfRejectsMu.Unlock()
+}
 
-		ordPerSecond := int64(time.Duration(cntCreated.Load()+cntClosed.Load()) * time.Second / dur)
-		avgDelay := time.Duration(-1)
-		if cntCreated.Load() > 0 {
-			avgDelay = (delayCreated / time.Duration(cntCreated.Load()))
+// onPerfCancelReject forgets a rejected replace, or the order of a rejected cancel: it is closed already or won't be
+// canceled. Replaces are tracked by their ClOrdID, cancels are not.
+func onPerfCancelReject(clOrdID []byte, origClOrdID []byte, now time.Time) {
+	var replace OrderInfo
+	isReplace := false
+	activeOrders.Update(clOrdID, func(orderInfo OrderInfo) (OrderInfo, bool) {
+		if !orderInfo.IsReplace {
+			return orderInfo, true
 		}
-		fmt.Printf("Stats: %s %d %d %d %d  Perf=%v orders/sec Delay=%v\n",
-			dur.String(), cntSent.Load(), cntCreated.Load(), cntClosed.Load(), cntBsnReject.Load(),
-			ordPerSecond, avgDelay,
-		)
+		replace, isReplace = orderInfo, true
+		return orderInfo, false
+	})
+	if isReplace {
+		onPerfReplaceRejected(replace, now)
+	} else {
+		activeOrders.Remove(origClOrdID)
 	}
 }
 
-func RunOrderEntryPerf(cfgFileName string, apiKeyName string) error {
-	tapp, err := NewTradeClient(cfgFileName, apiKeyName)
-	if err != nil {
-		return err
+// onPerfReplaceRejected cancels the original order of a rejected replace if its cancel was sent to the replace,
+// else the worker cancels the original order itself, see perfWorker.send
+func onPerfReplaceRejected(replace OrderInfo, now time.Time) {
+	if replace.CancelSentAt.IsZero() {
+		return
 	}
+	activeOrders.Update([]byte(replace.origClOrdID), func(orderInfo OrderInfo) (OrderInfo, bool) {
+		orderInfo.CancelSentAt = now
+		orderInfo.worker.recancel(orderInfo)
+		return orderInfo, true
+	})
+}
 
-	app := PerfTradeClient{tapp}
-
-	err = StartConnection(app, app.Settings)
+func recordAckBreakdown(msg *quickfix.Message, orderInfo *OrderInfo, now time.Time) {
+	offset, _, ok := DefaultClockOffset.Offset()
+	if !ok || orderInfo.SendingTime.IsZero() {
+		return
+	}
+	transactTime, err := msg.Body.GetTime(tag.TransactTime)
 	if err != nil {
-		return err
+		return
 	}
-	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
-	sessionID := quickfix.SessionID{
-		BeginString:  string("FIX.4.4"),
-		TargetCompID: string(targetCompID),
-		SenderCompID: string(app.SenderCompID),
+	sendingTime, err := msg.Header.GetTime(tag.SendingTime)
+	if err != nil {
+		return
 	}
+	ackBreakdown.Record(orderInfo.SendingTime, transactTime, sendingTime, now, offset)
+}
 
-	app.WaitConnect()
-
-	go PrintStat()
-
-	for {
-		// time.Sleep(time.Second)
-
-		if cntSent.Load()-cntCreated.Load()-cntBsnReject.Load() >= *uoCntCmd {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-
-		clOrdIDStr := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+func recordCancelLatency(orderInfo *OrderInfo, isCanceled bool, now time.Time) {
+	if isCanceled && !orderInfo.CancelSentAt.IsZero() {
+		cancelLatency.Record(now.Sub(orderInfo.CancelSentAt))
+	}
+}
 
-		{
-			order := newordersingle.New(
-				field.NewClOrdID(clOrdIDStr), // ToDo: FIX server should allow a non-duplicate char[19], not only increasing int56
-				field.NewSide(enum.Side_BUY),
-				field.NewTransactTimeWithPrecision(time.Now(), quickfix.Nanos),
-				field.NewOrdType(enum.OrdType_LIMIT),
-			)
-			//order.Set(field.NewSendingTime(time.Now()))
-			order.Set(field.NewSymbol("BTC-USD"))
-			order.Set(field.NewOrderQty(decimal.NewFromFloat(0.01), 2))
+// perfStats prints counters and latency percentiles of the last interval and since the start
+type perfStats struct {
+	startedAt   time.Time
+	prevAck     *latency.Snapshot
+	prevCancel  *latency.Snapshot
+	prevReplace *latency.Snapshot
+	prevCounts  perfCounts
+	samples     []PerfSample
+}
 
-			order.Set(field.NewPrice(decimal.NewFromFloat(1), 0))
+func newPerfStats() *perfStats {
+	return &perfStats{
+		startedAt:   time.Now().UTC(),
+		prevAck:     ackLatency.Snapshot(),
+		prevCancel:  cancelLatency.Snapshot(),
+		prevReplace: replaceLatency.Snapshot(),
+		prevCounts:  loadPerfCounts(),
+	}
+}
 
-			order.Set(field.NewTimeInForce(enum.TimeInForce_GOOD_TILL_CANCEL))
-			//order.Set(field.NewExpireTimeWithPrecision(time.Now().AddDate(0, 0, 1), quickfix.Nanos))
+func (p *perfStats) Print() {
+	dur := time.Now().UTC().Sub(p.startedAt)
+	ack, cancel := ackLatency.Snapshot(), cancelLatency.Snapshot()
+
+	ordPerSecond := int64(time.Duration(cntCreated.Load()+cntClosed.Load()) * time.Second / dur)
+	fmt.Printf("Stats: %s %d %d %d %d  Perf=%v orders/sec  open=%d lost=%d/%d\n",
+		dur.String(), cntSent.Load(), cntCreated.Load(), cntClosed.Load(), cntBsnReject.Load(),
+		ordPerSecond, activeOrders.Len(), cntLostOrders.Load(), cntLostCancels.Load(),
+	)
+	ackInterval, cancelInterval := ack.Sub(p.prevAck), cancel.Sub(p.prevCancel)
+	fmt.Printf("  Ack:    %s | total %s\n", ackInterval, ack)
+	fmt.Printf("  Cancel: %s | total %s\n", cancelInterval, cancel)
+	replace := replaceLatency.Snapshot()
+	replaceInterval := replace.Sub(p.prevReplace)
+	if replace.Total > 0 {
+		fmt.Printf("  Replace: %s | total %s\n", replaceInterval, replace)
+	}
+	counts := loadPerfCounts()
+	p.samples = append(p.samples, newPerfSample(dur, counts, p.prevCounts, ackInterval, cancelInterval, replaceInterval))
+	p.prevAck, p.prevCancel, p.prevReplace, p.prevCounts = ack, cancel, replace, counts
+}
 
-			msg := order.ToMessage()
-			msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
-			msg.Header.Set(field.NewTargetCompID(targetCompID))
+// PrintSummary prints totals of the run
+func (p *perfStats) PrintSummary() {
+	dur := time.Now().UTC().Sub(p.startedAt)
+	ack, cancel := ackLatency.Snapshot(), cancelLatency.Snapshot()
+
+	fmt.Printf("\nPerf summary: %s\n", dur)
+	fmt.Printf("  Orders: sent=%d created=%d closed=%d rejected=%d\n", cntSent.Load(), cntCreated.Load(), cntClosed.Load(), cntBsnReject.Load())
+	fmt.Printf("  Lost (no ack within %v): orders=%d cancels=%d, open=%d\n", *perfLostTimeoutCmd, cntLostOrders.Load(), cntLostCancels.Load(), activeOrders.Len())
+	fmt.Printf("  Throughput: %.1f orders/sec created\n", float64(cntCreated.Load())/dur.Seconds())
+	fmt.Printf("  Ack:    %s mean=%v\n", ack, ack.Mean())
+	fmt.Printf("  Cancel: %s mean=%v\n", cancel, cancel.Mean())
+	if replace := replaceLatency.Snapshot(); replace.Total > 0 {
+		fmt.Printf("  Replace: %s mean=%v\n", replace, replace.Mean())
+	}
+	if offset, rtt, ok := DefaultClockOffset.Offset(); ok {
+		fmt.Printf("  Ack breakdown (venue clock offset %v, RTT %v):\n%s", offset, rtt, ackBreakdown)
+	}
+}
 
-			orderInfo := &OrderInfo{
-				State:  OrderState_SENT,
-				SentAt: time.Now(),
-			}
-			activeOrdersMu.Lock()
-			activeOrders[clOrdIDStr] = orderInfo
-			activeOrdersMu.Unlock()
+func PrintStat(stats *perfStats, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			activeOrders.Expire(now, *perfLostTimeoutCmd)
+			stats.Print()
+		case <-stop:
+			return
+		}
+	}
+}
 
-			//fmt.Printf("Sending[%s]: %s\n", clOrdID, msg.String())
+func RunOrderEntryPerf(cfgFileName string, apiKeyName string) error {
+	profile := DefaultPerfProfile(cfgFileName, apiKeyName)
+	if *perfProfileCmd != "" {
+		var err error
+		profile, err = LoadPerfProfile(*perfProfileCmd, cfgFileName, apiKeyName)
+		if err != nil {
+			return err
+		}
+	} else if err := profile.validate(); err != nil {
+		return err
+	}
 
-			err := quickfix.SendToTarget(msg, sessionID)
+	workers := make([]*perfWorker, 0, len(profile.Sessions))
+	sessions := make([]string, 0, len(profile.Sessions))
+	for i, session := range profile.Sessions {
+		tapp, err := NewTradeClient(session.Config, session.APIKey)
+		if err != nil {
+			return err
+		}
+		app := PerfTradeClient{tapp}
 
-			if err != nil {
-				return err
-			}
+		err = StartConnection(app, app.Settings)
+		if err != nil {
+			return err
 		}
+		targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
+		sessionID := quickfix.SessionID{
+			BeginString:  string("FIX.4.4"),
+			TargetCompID: string(targetCompID),
+			SenderCompID: string(app.SenderCompID),
+		}
+		app.WaitConnect()
 
-		{
-			cancelClOrdId := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+		host, _ := app.Settings.GlobalSettings().Setting(config.SocketConnectHost)
+		sessions = append(sessions, sessionID.String()+" -> "+host)
+		workers = append(workers, newPerfWorker(profile, sessionID, i))
+	}
 
-			order := ordercancelrequest.New(
-				field.NewOrigClOrdID(clOrdIDStr),
-				field.NewClOrdID(cancelClOrdId),
-				field.NewSide(enum.Side_BUY),
-				field.NewTransactTime(time.Now()),
-			)
+	fmt.Printf("Perf profile '%s': %d sessions, rate=%v orders/sec, mix=%v\n", profile.Name, len(workers), profile.Rate, profile.Mix)
+
+	stop := make(chan struct{})
+	stats := newPerfStats()
+
+	errs := make(chan error, len(workers))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		PrintStat(stats, stop)
+	}()
+	for _, worker := range workers {
+		if *clockProbeCmd > 0 {
+			wg.Add(1)
+			go func(sessionID quickfix.SessionID) {
+				defer wg.Done()
+				DefaultClockOffset.ProbeEvery(sessionID, *clockProbeCmd, stop)
+			}(worker.sessionID)
+		}
 
-			msg := order.ToMessage()
-			msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
-			msg.Header.Set(field.NewTargetCompID(targetCompID))
+		wg.Add(1)
+		go func(worker *perfWorker) {
+			defer wg.Done()
+			errs <- worker.Run(stop)
+		}(worker)
+	}
 
-			// fmt.Printf("Sending: %s\n", msg.String())
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
 
-			err := quickfix.SendToTarget(msg, sessionID)
+	var deadline <-chan time.Time
+	if profile.RunDuration() > 0 {
+		deadline = time.After(profile.RunDuration())
+	}
 
-			if err != nil {
-				return err
-			}
+	var err error
+	select {
+	case <-interrupted:
+	case <-deadline:
+	case err = <-errs:
+	}
+	close(stop)
+	wg.Wait()
+	stats.PrintSummary()
+
+	if *perfReportCmd != "" {
+		reportErr := WritePerfReport(*perfReportCmd, NewPerfReport(profile, stats, sessions))
+		if reportErr != nil && err == nil {
+			err = reportErr
 		}
-
-		cntSent.Add(1)
 	}
+	return err
 }
@@ -0,0 +1,119 @@
+package fix
+
+import (
+	"flag"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const perfOrderShards = 64 // power of 2
+
+var (
+	perfLostTimeoutCmd = flag.Duration("plt", 30*time.Second, "Perf: forget orders and cancels without an ack after this long and count them as lost")
+
+	cntLostOrders  atomic.Int64 // orders never acked
+	cntLostCancels atomic.Int64 // cancels and replaces never acked
+
+	activeOrders = newPerfOrders()
+)
+
+// perfOrders tracks orders in flight by ClOrdID. Orders are removed once closed, cancelled or replaced,
+// so its size is bounded by the unacked and resting orders rather than by the length of the run.
+type perfOrders struct {
+	shards [perfOrderShards]perfOrderShard
+}
+
+type perfOrderShard struct {
+	mu     sync.Mutex
+	orders map[string]OrderInfo
+	_      [48]byte // keeps shards on separate cache lines
+}
+
+func newPerfOrders() *perfOrders {
+	o := &perfOrders{}
+	for i := range o.shards {
+		o.shards[i].orders = make(map[string]OrderInfo)
+	}
+	return o
+}
+
+// shard hashes ClOrdID with FNV-1a
+func (o *perfOrders) shard(clOrdID []byte) *perfOrderShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(clOrdID); i++ {
+		h ^= uint32(clOrdID[i])
+		h *= 16777619
+	}
+	return &o.shards[h&(perfOrderShards-1)]
+}
+
+func (o *perfOrders) Add(clOrdID string, info OrderInfo) {
+	info.clOrdID = clOrdID
+	s := o.shard([]byte(clOrdID))
+	s.mu.Lock()
+	s.orders[clOrdID] = info
+	s.mu.Unlock()
+}
+
+// Update calls fn with the order under the lock of its shard and stores the order it returns, or removes it if fn
+// returns false. Returns false if the order is unknown. ClOrdID is bytes of a received message, lookups don't allocate.
+func (o *perfOrders) Update(clOrdID []byte, fn func(info OrderInfo) (OrderInfo, bool)) bool {
+	s := o.shard(clOrdID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, found := s.orders[string(clOrdID)]
+	if !found {
+		return false
+	}
+	clOrdIDStr := info.clOrdID
+	if info, keep := fn(info); keep {
+		s.orders[clOrdIDStr] = info
+	} else {
+		delete(s.orders, clOrdIDStr)
+	}
+	return true
+}
+
+func (o *perfOrders) Remove(clOrdID []byte) {
+	o.Update(clOrdID, func(info OrderInfo) (OrderInfo, bool) { return info, false })
+}
+
+func (o *perfOrders) Len() int {
+	n := 0
+	for i := range o.shards {
+		s := &o.shards[i]
+		s.mu.Lock()
+		n += len(s.orders)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Expire removes orders waiting for an ack since before now-timeout and counts them as lost
+func (o *perfOrders) Expire(now time.Time, timeout time.Duration) (lostOrders int64, lostCancels int64) {
+	deadline := now.Add(-timeout)
+	for i := range o.shards {
+		s := &o.shards[i]
+		s.mu.Lock()
+		for clOrdID, info := range s.orders {
+			switch {
+			case info.State == OrderState_SENT && info.SentAt.Before(deadline):
+				if info.IsReplace {
+					lostCancels++
+				} else {
+					lostOrders++
+				}
+				delete(s.orders, clOrdID)
+			case !info.CancelSentAt.IsZero() && info.CancelSentAt.Before(deadline):
+				lostCancels++
+				delete(s.orders, clOrdID)
+			}
+		}
+		s.mu.Unlock()
+	}
+	cntLostOrders.Add(lostOrders)
+	cntLostCancels.Add(lostCancels)
+	return
+}
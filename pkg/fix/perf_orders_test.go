@@ -0,0 +1,59 @@
+package fix
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// BenchmarkPerfOrders sends, acks and cancels b.N orders, 1 in 10 is never acked and expires as lost.
+// The heap after GC stays flat however many orders run, e.g. -benchtime 5000000x.
+func BenchmarkPerfOrders(b *testing.B) {
+	const unackedEvery = 10
+	orders := newPerfOrders()
+	timeout := 100 * time.Millisecond
+	start := time.Now()
+	clOrdID := make([]byte, 0, 20)
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// a fake clock, 1 order per microsecond
+		now := start.Add(time.Duration(i) * time.Microsecond)
+		clOrdID = strconv.AppendInt(clOrdID[:0], int64(i), 10)
+		orders.Add(string(clOrdID), OrderInfo{State: OrderState_SENT, SentAt: now})
+
+		if i%unackedEvery != 0 {
+			orders.Update(clOrdID, func(info OrderInfo) (OrderInfo, bool) {
+				info.State = OrderState_CREATED
+				info.CancelSentAt = now
+				return info, true
+			})
+			orders.Update(clOrdID, func(info OrderInfo) (OrderInfo, bool) {
+				return info, false
+			})
+		}
+		if i%10000 == 0 {
+			orders.Expire(now, timeout)
+		}
+	}
+	orders.Expire(start.Add(time.Duration(b.N)*time.Microsecond+2*timeout), timeout)
+	b.StopTimer()
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.HeapAlloc)/1024, "heap-KiB-before-gc")
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(before.HeapAlloc)/1024, "heap-KiB-start")
+	b.ReportMetric(float64(after.HeapAlloc)/1024, "heap-KiB-after-gc")
+
+	if n := orders.Len(); n != 0 {
+		b.Fatalf("%d orders left after expiry", n)
+	}
+}
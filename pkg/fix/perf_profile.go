@@ -0,0 +1,409 @@
+package fix
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/newordermultileg"
+	"github.com/quickfixgo/fix44/newordersingle"
+	"github.com/quickfixgo/fix44/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix44/ordercancelrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	PerfAction_NEW_CANCEL         = "new_cancel"         // limit GTC order, then its cancel
+	PerfAction_NEW_REPLACE_CANCEL = "new_replace_cancel" // limit GTC order, its replace, then cancel
+	PerfAction_IOC                = "ioc"                // IOC order closed by the venue
+	PerfAction_MULTILEG_CANCEL    = "multileg_cancel"    // NewOrderMultileg of a strategy, then its cancel
+)
+
+var (
+	perfProfileCmd = flag.String("pp", "", "Perf: workload profile JSON (default: closed loop of BTC-USD buy/cancel)")
+)
+
+// PerfProfile is a reproducible perf workload
+type PerfProfile struct {
+	Name     string         `json:"name"`
+	Rate     float64        `json:"rate"`     // orders/sec over all sessions, 0 = closed loop limited by max_unacked
+	Duration string         `json:"duration"` // e.g. "1m", empty = `-pd`
+	Unacked  int64          `json:"max_unacked"`
+	Seed     int64          `json:"seed"`
+	Sessions []PerfSession  `json:"sessions"` // empty = `-f` and `-a`
+	Symbols  []PerfSymbol   `json:"symbols"`
+	Sides    []string       `json:"sides"`    // buy/sell
+	Mix      map[string]int `json:"mix"`      // action -> weight
+	Strategy PerfStrategy   `json:"strategy"` // of multileg orders
+
+	duration time.Duration
+	sides    []enum.Side
+	actions  []string // one per weight unit
+}
+
+// PerfSession is a FIX config and an api key, each session needs a distinct api key
+type PerfSession struct {
+	Config string `json:"config"`
+	APIKey string `json:"api_key"`
+}
+
+type PerfSymbol struct {
+	Symbol       string          `json:"symbol"`
+	Price        decimal.Decimal `json:"price"`
+	Qty          decimal.Decimal `json:"qty"`
+	ReplacePrice decimal.Decimal `json:"replace_price"` // zero = price
+}
+
+// PerfStrategy is submitted as given, legs should be in the canonical form of the venue
+type PerfStrategy struct {
+	Legs  []PerfLeg       `json:"legs"`
+	Price decimal.Decimal `json:"price"`
+	Qty   decimal.Decimal `json:"qty"`
+}
+
+type PerfLeg struct {
+	Symbol string          `json:"symbol"`
+	Ratio  decimal.Decimal `json:"ratio"`
+}
+
+// DefaultPerfProfile is the closed loop of BTC-USD buy orders and their cancels
+func DefaultPerfProfile(cfgFileName string, apiKeyName string) *PerfProfile {
+	return &PerfProfile{
+		Name:     "default",
+		Unacked:  *uoCntCmd,
+		Sessions: []PerfSession{{Config: cfgFileName, APIKey: apiKeyName}},
+		Symbols:  []PerfSymbol{{Symbol: "BTC-USD", Price: decimal.NewFromInt(1), Qty: decimal.NewFromFloat(0.01)}},
+		Sides:    []string{"buy"},
+		Mix:      map[string]int{PerfAction_NEW_CANCEL: 1},
+	}
+}
+
+// LoadPerfProfile reads a profile, fields it leaves out are taken from DefaultPerfProfile and printed
+func LoadPerfProfile(path string, cfgFileName string, apiKeyName string) (*PerfProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// defaults are filled in after parsing, json would merge the mix into the default one
+	profile := &PerfProfile{}
+	err = json.Unmarshal(data, profile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	defaults := DefaultPerfProfile(cfgFileName, apiKeyName)
+	if profile.Name == "" {
+		profile.Name = strings.TrimSuffix(path, ".json")
+	}
+	if profile.Unacked == 0 {
+		profile.Unacked = defaults.Unacked
+		fmt.Printf("Perf profile %s: max_unacked=%d from -puo\n", profile.Name, profile.Unacked)
+	}
+	if len(profile.Sessions) == 0 {
+		profile.Sessions = defaults.Sessions
+		fmt.Printf("Perf profile %s: sessions of -f and -a\n", profile.Name)
+	}
+	if len(profile.Symbols) == 0 {
+		profile.Symbols = defaults.Symbols
+		fmt.Printf("Perf profile %s: default symbols %s\n", profile.Name, defaults.Symbols[0].Symbol)
+	}
+	if len(profile.Sides) == 0 {
+		profile.Sides = defaults.Sides
+		fmt.Printf("Perf profile %s: default sides %v\n", profile.Name, profile.Sides)
+	}
+	if len(profile.Mix) == 0 {
+		profile.Mix = defaults.Mix
+		fmt.Printf("Perf profile %s: default mix %v\n", profile.Name, profile.Mix)
+	}
+	return profile, profile.validate()
+}
+
+func (p *PerfProfile) validate() error {
+	if p.Duration != "" {
+		var err error
+		p.duration, err = time.ParseDuration(p.Duration)
+		if err != nil {
+			return fmt.Errorf("duration: %v", err)
+		}
+	}
+	if len(p.Sessions) == 0 || len(p.Symbols) == 0 || len(p.Sides) == 0 {
+		return fmt.Errorf("profile '%s': sessions, symbols and sides are required", p.Name)
+	}
+	apiKeys := make(map[string]bool)
+	for _, session := range p.Sessions {
+		if apiKeys[session.APIKey] {
+			return fmt.Errorf("profile '%s': api key '%s' is used by several sessions", p.Name, session.APIKey)
+		}
+		apiKeys[session.APIKey] = true
+	}
+
+	p.sides = p.sides[:0]
+	for _, side := range p.Sides {
+		switch strings.ToLower(side) {
+		case "buy":
+			p.sides = append(p.sides, enum.Side_BUY)
+		case "sell":
+			p.sides = append(p.sides, enum.Side_SELL)
+		default:
+			return fmt.Errorf("profile '%s': unknown side '%s'", p.Name, side)
+		}
+	}
+
+	p.actions = p.actions[:0]
+	for action, weight := range p.Mix {
+		switch action {
+		case PerfAction_NEW_CANCEL, PerfAction_NEW_REPLACE_CANCEL, PerfAction_IOC:
+		case PerfAction_MULTILEG_CANCEL:
+			if len(p.Strategy.Legs) < 2 {
+				return fmt.Errorf("profile '%s': %s needs a strategy of 2 legs or more", p.Name, action)
+			}
+		default:
+			return fmt.Errorf("profile '%s': unknown action '%s'", p.Name, action)
+		}
+		for i := 0; i < weight; i++ {
+			p.actions = append(p.actions, action)
+		}
+	}
+	if len(p.actions) == 0 {
+		return fmt.Errorf("profile '%s': empty mix", p.Name)
+	}
+	// map iteration is random, the same seed must give the same sequence
+	sort.Strings(p.actions)
+	return nil
+}
+
+// RunDuration is the duration of the profile, else of `-pd`
+func (p *PerfProfile) RunDuration() time.Duration {
+	if p.duration > 0 {
+		return p.duration
+	}
+	return *perfDurationCmd
+}
+
+// perfWorker sends the workload of one session
+type perfWorker struct {
+	profile   *PerfProfile
+	sessionID quickfix.SessionID
+	rate      float64 // orders/sec of this session
+	rnd       *rand.Rand
+	templates map[perfTemplateKey]*perfTemplate
+	recancels chan perfRecancel // orders to cancel again, their cancel targeted a rejected replace
+}
+
+type perfRecancel struct {
+	clOrdID string
+	symbol  int
+	side    enum.Side
+}
+
+func newPerfWorker(profile *PerfProfile, sessionID quickfix.SessionID, index int) *perfWorker {
+	w := &perfWorker{
+		profile:   profile,
+		sessionID: sessionID,
+		rate:      profile.Rate / float64(len(profile.Sessions)),
+		rnd:       rand.New(rand.NewSource(profile.Seed + int64(index))),
+		recancels: make(chan perfRecancel, 1024),
+	}
+	w.templates = w.newPerfTemplates()
+	return w
+}
+
+// Run sends orders until stop is closed. In open loop orders are scheduled at the rate and latencies are measured
+// from the scheduled time, so that a stalled sender shows up as latency (no coordinated omission).
+func (w *perfWorker) Run(stop <-chan struct{}) error {
+	startedAt := time.Now()
+	for i := int64(0); ; i++ {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		if err := w.sendRecancels(); err != nil {
+			return err
+		}
+		if cntSent.Load()-cntCreated.Load()-cntRejectedOrders.Load()-cntLostOrders.Load() >= w.profile.Unacked {
+			time.Sleep(100 * time.Millisecond)
+			i--
+			continue
+		}
+
+		scheduledAt := time.Now()
+		if w.rate > 0 {
+			scheduledAt = startedAt.Add(time.Duration(float64(i) * float64(time.Second) / w.rate))
+			if wait := time.Until(scheduledAt); wait > 0 {
+				select {
+				case <-stop:
+					return nil
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		err := w.send(scheduledAt)
+		if err != nil {
+			return err
+		}
+		cntSent.Add(1)
+	}
+}
+
+func (w *perfWorker) send(scheduledAt time.Time) error {
+	symbol := w.rnd.Intn(len(w.profile.Symbols))
+	side := w.profile.sides[w.rnd.Intn(len(w.profile.sides))]
+	action := w.profile.actions[w.rnd.Intn(len(w.profile.actions))]
+
+	key := perfTemplateKey{enum.MsgType_ORDER_SINGLE, enum.TimeInForce_GOOD_TILL_CANCEL, symbol, side}
+	switch action {
+	case PerfAction_MULTILEG_CANCEL:
+		key = perfTemplateKey{enum.MsgType_NEW_ORDER_MULTILEG, "", -1, side}
+		symbol = -1
+	case PerfAction_IOC:
+		key.timeInForce = enum.TimeInForce_IMMEDIATE_OR_CANCEL
+	}
+	clOrdID, err := w.sendTracked(w.templates[key], "", scheduledAt, false, symbol, side)
+	if err != nil || action == PerfAction_IOC {
+		return err
+	}
+
+	origClOrdID := ""
+	if action == PerfAction_NEW_REPLACE_CANCEL {
+		replace := w.templates[perfTemplateKey{enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST, "", symbol, side}]
+		origClOrdID = clOrdID
+		clOrdID, err = w.sendTracked(replace, origClOrdID, time.Now(), true, symbol, side)
+		if err != nil {
+			return err
+		}
+	}
+
+	cancelSentAt := time.Now()
+	setCancelSentAt := func(orderInfo OrderInfo) (OrderInfo, bool) {
+		orderInfo.CancelSentAt = cancelSentAt
+		return orderInfo, true
+	}
+	if !activeOrders.Update([]byte(clOrdID), setCancelSentAt) && origClOrdID != "" {
+		// the replace is rejected already, the original order rests
+		clOrdID = origClOrdID
+		activeOrders.Update([]byte(clOrdID), setCancelSentAt)
+	}
+	cancel := w.templates[perfTemplateKey{enum.MsgType_ORDER_CANCEL_REQUEST, "", symbol, side}]
+	return SendToTarget(cancel.patch(pt.DefaultTokenGenerator.Next(), clOrdID, cancelSentAt), w.sessionID)
+}
+
+// sendTracked sends an order or replace of origClOrdID with a new ClOrdID, it is tracked until closed
+func (w *perfWorker) sendTracked(template *perfTemplate, origClOrdID string, sentAt time.Time, isReplace bool, symbol int, side enum.Side) (string, error) {
+	id := pt.DefaultTokenGenerator.Next()
+	clOrdID := strconv.FormatUint(id, 10)
+	activeOrders.Add(clOrdID, OrderInfo{
+		State:       OrderState_SENT,
+		SentAt:      sentAt,
+		IsReplace:   isReplace,
+		origClOrdID: origClOrdID,
+		worker:      w,
+		symbol:      symbol,
+		side:        side,
+	})
+
+	return clOrdID, SendToTarget(template.patch(id, origClOrdID, time.Now()), w.sessionID)
+}
+
+// recancel queues a cancel of an order, called by the session goroutine so it doesn't wait for the throttle. If the
+// queue is full the order expires as a lost cancel.
+func (w *perfWorker) recancel(orderInfo OrderInfo) {
+	select {
+	case w.recancels <- perfRecancel{orderInfo.clOrdID, orderInfo.symbol, orderInfo.side}:
+	default:
+	}
+}
+
+// sendRecancels sends the queued cancels
+func (w *perfWorker) sendRecancels() error {
+	for {
+		select {
+		case r := <-w.recancels:
+			cancel := w.templates[perfTemplateKey{enum.MsgType_ORDER_CANCEL_REQUEST, "", r.symbol, r.side}]
+			if err := SendToTarget(cancel.patch(pt.DefaultTokenGenerator.Next(), r.clOrdID, time.Now()), w.sessionID); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (w *perfWorker) singleOrder(clOrdID string, symbol PerfSymbol, side enum.Side, timeInForce enum.TimeInForce) quickfix.Messagable {
+	order := newordersingle.New(
+		field.NewClOrdID(clOrdID), // ToDo: FIX server should allow a non-duplicate char[19], not only increasing int56
+		field.NewSide(side),
+		field.NewTransactTimeWithPrecision(time.Now(), quickfix.Nanos),
+		field.NewOrdType(enum.OrdType_LIMIT),
+	)
+	order.Set(field.NewSymbol(symbol.Symbol))
+	order.Set(field.NewOrderQty(symbol.Qty, scaleOf(symbol.Qty)))
+	order.Set(field.NewPrice(symbol.Price, scaleOf(symbol.Price)))
+	order.Set(field.NewTimeInForce(timeInForce))
+	return order
+}
+
+func (w *perfWorker) multilegOrder(clOrdID string, side enum.Side) quickfix.Messagable {
+	strategy := w.profile.Strategy
+	order := newordermultileg.New(
+		field.NewClOrdID(clOrdID),
+		field.NewSide(side),
+		field.NewTransactTimeWithPrecision(time.Now(), quickfix.Nanos),
+		field.NewOrdType(enum.OrdType_LIMIT),
+	)
+	legs := newordermultileg.NewNoLegsRepeatingGroup()
+	for _, leg := range strategy.Legs {
+		l := legs.Add()
+		l.Set(field.NewLegSymbol(leg.Symbol))
+		l.Set(field.NewLegRatioQty(leg.Ratio, 0))
+	}
+	order.SetGroup(legs)
+	order.Set(field.NewOrderQty(strategy.Qty, scaleOf(strategy.Qty)))
+	order.Set(field.NewPrice(strategy.Price, scaleOf(strategy.Price)))
+	order.Set(field.NewTimeInForce(enum.TimeInForce_GOOD_TILL_CANCEL))
+	return order
+}
+
+func (w *perfWorker) replace(origClOrdID string, clOrdID string, symbol PerfSymbol, side enum.Side) quickfix.Messagable {
+	price := symbol.ReplacePrice
+	if price.IsZero() {
+		price = symbol.Price
+	}
+	order := ordercancelreplacerequest.New(
+		field.NewOrigClOrdID(origClOrdID),
+		field.NewClOrdID(clOrdID),
+		field.NewSide(side),
+		field.NewTransactTimeWithPrecision(time.Now(), quickfix.Nanos),
+		field.NewOrdType(enum.OrdType_LIMIT),
+	)
+	order.Set(field.NewSymbol(symbol.Symbol))
+	order.Set(field.NewOrderQty(symbol.Qty, scaleOf(symbol.Qty)))
+	order.Set(field.NewPrice(price, scaleOf(price)))
+	return order
+}
+
+func (w *perfWorker) cancel(origClOrdID string, symbol PerfSymbol, side enum.Side) quickfix.Messagable {
+	order := ordercancelrequest.New(
+		field.NewOrigClOrdID(origClOrdID),
+		field.NewClOrdID("0"),
+		field.NewSide(side),
+		field.NewTransactTimeWithPrecision(time.Now(), quickfix.Nanos),
+	)
+	if symbol.Symbol != "" {
+		order.Set(field.NewSymbol(symbol.Symbol))
+	}
+	return order
+}
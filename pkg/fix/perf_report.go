@@ -0,0 +1,347 @@
+package fix
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/latency"
+)
+
+var (
+	perfReportCmd = flag.String("pr", "", "Perf: write a report to this file, .json (full) or .csv (time series, the full report goes next to it as .json)")
+
+	perfCompareLatencyCmd    = flag.Float64("pcl", 10, "Perf compare: latency regression threshold in %")
+	perfCompareThroughputCmd = flag.Float64("pct", 10, "Perf compare: throughput regression threshold in %")
+	perfCompareRejectsCmd    = flag.Float64("pcr", 0.1, "Perf compare: reject rate regression threshold in percentage points")
+)
+
+// PerfReport is the machine-readable result of a perf run
+type PerfReport struct {
+	Profile     *PerfProfile             `json:"profile"`
+	Environment PerfEnvironment          `json:"environment"`
+	StartedAt   time.Time                `json:"started_at"`
+	EndedAt     time.Time                `json:"ended_at"`
+	Sent        int64                    `json:"sent"`
+	Created     int64                    `json:"created"`
+	Closed      int64                    `json:"closed"`
+	Rejected    int64                    `json:"business_rejects"`
+	LostOrders  int64                    `json:"lost_orders"`    // never acked
+	LostCancels int64                    `json:"lost_cancels"`   // cancels and replaces never acked
+	Throughput  float64                  `json:"orders_per_sec"` // created
+	Latency     map[string]LatencyReport `json:"latency"`        // ack/cancel/replace
+	Breakdown   map[string]LatencyReport `json:"breakdown"`      // ack: outbound/venue/inbound
+	ClockOffset int64                    `json:"clock_offset_ns"`
+	ClockRTT    int64                    `json:"clock_rtt_ns"`
+	Rejects     map[string]int64         `json:"rejects"` // MsgType/reason -> count
+	Samples     []PerfSample             `json:"samples"` // per second, counters are not cumulative
+}
+
+type PerfEnvironment struct {
+	GoVersion string   `json:"go_version"`
+	OS        string   `json:"os"`
+	Arch      string   `json:"arch"`
+	CPUs      int      `json:"cpus"`
+	Hostname  string   `json:"hostname"`
+	Sessions  []string `json:"sessions"` // session -> host
+}
+
+// LatencyReport summarizes a latency histogram, values are in nanoseconds
+type LatencyReport struct {
+	Count   int64            `json:"count"`
+	Mean    int64            `json:"mean_ns"`
+	P50     int64            `json:"p50_ns"`
+	P90     int64            `json:"p90_ns"`
+	P99     int64            `json:"p99_ns"`
+	P999    int64            `json:"p99_9_ns"`
+	Max     int64            `json:"max_ns"`
+	Buckets []latency.Bucket `json:"buckets,omitempty"`
+}
+
+func NewLatencyReport(s *latency.Snapshot, withBuckets bool) LatencyReport {
+	report := LatencyReport{
+		Count: s.Total,
+		Mean:  int64(s.Mean()),
+		P50:   int64(s.Percentile(50)),
+		P90:   int64(s.Percentile(90)),
+		P99:   int64(s.Percentile(99)),
+		P999:  int64(s.Percentile(99.9)),
+		Max:   s.Max,
+	}
+	if withBuckets {
+		report.Buckets = s.Buckets()
+	}
+	return report
+}
+
+// PerfSample is a second of a run: counters and latencies of the interval since the previous sample
+type PerfSample struct {
+	Elapsed  float64       `json:"elapsed_sec"`
+	Sent     int64         `json:"sent"`
+	Created  int64         `json:"created"`
+	Closed   int64         `json:"closed"`
+	Rejected int64         `json:"business_rejects"`
+	Lost     int64         `json:"lost_orders"`
+	Ack      LatencyReport `json:"ack"`
+	Cancel   LatencyReport `json:"cancel"`
+	Replace  LatencyReport `json:"replace"`
+}
+
+// perfCounts are counters of the run so far
+type perfCounts struct {
+	sent, created, closed, rejected, lost int64
+}
+
+func loadPerfCounts() perfCounts {
+	return perfCounts{cntSent.Load(), cntCreated.Load(), cntClosed.Load(), cntBsnReject.Load(), cntLostOrders.Load()}
+}
+
+func newPerfSample(elapsed time.Duration, counts perfCounts, prev perfCounts, ack *latency.Snapshot, cancel *latency.Snapshot, replace *latency.Snapshot) PerfSample {
+	return PerfSample{
+		Elapsed:  elapsed.Seconds(),
+		Sent:     counts.sent - prev.sent,
+		Created:  counts.created - prev.created,
+		Closed:   counts.closed - prev.closed,
+		Rejected: counts.rejected - prev.rejected,
+		Lost:     counts.lost - prev.lost,
+		Ack:      NewLatencyReport(ack, false),
+		Cancel:   NewLatencyReport(cancel, false),
+		Replace:  NewLatencyReport(replace, false),
+	}
+}
+
+// NewPerfReport collects results of the run so far
+func NewPerfReport(profile *PerfProfile, stats *perfStats, sessions []string) *PerfReport {
+	hostname, _ := os.Hostname()
+	report := &PerfReport{
+		Profile: profile,
+		Environment: PerfEnvironment{
+			GoVersion: runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			CPUs:      runtime.NumCPU(),
+			Hostname:  hostname,
+			Sessions:  sessions,
+		},
+		StartedAt:   stats.startedAt,
+		EndedAt:     time.Now().UTC(),
+		Sent:        cntSent.Load(),
+		Created:     cntCreated.Load(),
+		Closed:      cntClosed.Load(),
+		Rejected:    cntBsnReject.Load(),
+		LostOrders:  cntLostOrders.Load(),
+		LostCancels: cntLostCancels.Load(),
+		Latency: map[string]LatencyReport{
+			"ack":     NewLatencyReport(ackLatency.Snapshot(), true),
+			"cancel":  NewLatencyReport(cancelLatency.Snapshot(), true),
+			"replace": NewLatencyReport(replaceLatency.Snapshot(), true),
+		},
+		Breakdown: map[string]LatencyReport{
+			"outbound": NewLatencyReport(ackBreakdown.Outbound.Snapshot(), true),
+			"venue":    NewLatencyReport(ackBreakdown.Venue.Snapshot(), true),
+			"inbound":  NewLatencyReport(ackBreakdown.Inbound.Snapshot(), true),
+		},
+		Rejects: make(map[string]int64),
+		Samples: stats.samples,
+	}
+	if offset, rtt, ok := DefaultClockOffset.Offset(); ok {
+		report.ClockOffset, report.ClockRTT = int64(offset), int64(rtt)
+	}
+	if dur := report.EndedAt.Sub(report.StartedAt).Seconds(); dur > 0 {
+		report.Throughput = float64(report.Created) / dur
+	}
+
+	perfRejectsMu.Lock()
+	for reason, count := range perfRejects {
+		report.Rejects[reason] = count
+	}
+	perfRejectsMu.Unlock()
+	return report
+}
+
+// RejectRate is the share of sent orders which were rejected, in %
+func (r *PerfReport) RejectRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	var rejects int64
+	for _, count := range r.Rejects {
+		rejects += count
+	}
+	return float64(rejects) * 100 / float64(r.Sent)
+}
+
+// WritePerfReport writes a report as JSON. If the path ends with .csv it writes the samples as CSV
+// and the full report next to it with the .json extension, so config, environment, histograms and rejects are kept.
+func WritePerfReport(path string, report *PerfReport) error {
+	ext := filepath.Ext(path)
+	if !strings.EqualFold(ext, ".csv") {
+		return writePerfReportFile(path, func(file *os.File) error { return writePerfReportJSON(file, report) })
+	}
+	if err := writePerfReportFile(path, func(file *os.File) error { return writePerfSamplesCSV(file, report.Samples) }); err != nil {
+		return err
+	}
+	return writePerfReportFile(strings.TrimSuffix(path, ext)+".json", func(file *os.File) error { return writePerfReportJSON(file, report) })
+}
+
+func writePerfReportFile(path string, write func(file *os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = write(file); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writePerfReportJSON(file *os.File, report *PerfReport) error {
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writePerfSamplesCSV(file *os.File, samples []PerfSample) error {
+	w := csv.NewWriter(file)
+	header := []string{"elapsed_sec", "sent", "created", "closed", "business_rejects", "lost_orders"}
+	for _, kind := range []string{"ack", "cancel", "replace"} {
+		header = append(header, kind+"_count", kind+"_p50_ns", kind+"_p90_ns", kind+"_p99_ns", kind+"_p99_9_ns", kind+"_max_ns")
+	}
+	w.Write(header)
+	for _, s := range samples {
+		row := []string{fmt.Sprintf("%.3f", s.Elapsed), fmt.Sprint(s.Sent), fmt.Sprint(s.Created), fmt.Sprint(s.Closed), fmt.Sprint(s.Rejected), fmt.Sprint(s.Lost)}
+		for _, l := range []LatencyReport{s.Ack, s.Cancel, s.Replace} {
+			row = append(row, fmt.Sprint(l.Count), fmt.Sprint(l.P50), fmt.Sprint(l.P90), fmt.Sprint(l.P99), fmt.Sprint(l.P999), fmt.Sprint(l.Max))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func ReadPerfReport(path string) (*PerfReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &PerfReport{}
+	if err = json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return report, nil
+}
+
+// PerfComparison is a metric of two reports, Change is in Unit: % or pp (percentage points, for rates)
+type PerfComparison struct {
+	Metric     string
+	Base       float64
+	New        float64
+	Change     float64
+	Unit       string
+	Regression bool
+}
+
+// ComparePerfReports compares throughput, latency percentiles and reject rate.
+// Latency regressions are increases beyond latencyPct %, throughput decreases beyond throughputPct %,
+// reject rate increases beyond rejectsPts percentage points.
+func ComparePerfReports(base *PerfReport, current *PerfReport, latencyPct float64, throughputPct float64, rejectsPts float64) []PerfComparison {
+	res := make([]PerfComparison, 0)
+
+	change := func(b float64, n float64) float64 {
+		if b == 0 {
+			return 0
+		}
+		return (n - b) * 100 / b
+	}
+
+	c := change(base.Throughput, current.Throughput)
+	res = append(res, PerfComparison{"orders_per_sec", base.Throughput, current.Throughput, c, "%", c < -throughputPct})
+
+	latencies := func(r *PerfReport) map[string]LatencyReport {
+		all := make(map[string]LatencyReport)
+		for kind, l := range r.Latency {
+			all[kind] = l
+		}
+		for kind, l := range r.Breakdown {
+			all["ack_"+kind] = l
+		}
+		return all
+	}
+	baseLatency, currentLatency := latencies(base), latencies(current)
+
+	kinds := make([]string, 0, len(baseLatency))
+	for kind := range baseLatency {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		b, n := baseLatency[kind], currentLatency[kind]
+		if b.Count == 0 || n.Count == 0 {
+			continue
+		}
+		for _, p := range []struct {
+			name string
+			b, n int64
+		}{
+			{"p50", b.P50, n.P50}, {"p90", b.P90, n.P90}, {"p99", b.P99, n.P99}, {"p99.9", b.P999, n.P999}, {"max", b.Max, n.Max},
+		} {
+			c := change(float64(p.b), float64(p.n))
+			res = append(res, PerfComparison{kind + "_" + p.name + "_ms", float64(p.b) / 1e6, float64(p.n) / 1e6, c, "%", c > latencyPct})
+		}
+	}
+
+	b, n := base.RejectRate(), current.RejectRate()
+	res = append(res, PerfComparison{"reject_rate_pct", b, n, n - b, "pp", n-b > rejectsPts})
+	return res
+}
+
+func FormatPerfComparison(comparisons []PerfComparison) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-24s %14s %14s %10s\n", "Metric", "Base", "New", "Change")
+	for _, c := range comparisons {
+		status := ""
+		if c.Regression {
+			status = "  REGRESSION"
+		}
+		fmt.Fprintf(&b, "%-24s %14.3f %14.3f %+8.1f%-2s%s\n", c.Metric, c.Base, c.New, c.Change, c.Unit, status)
+	}
+	return b.String()
+}
+
+// RunPerfCompare diffs two perf reports and fails on regressions beyond `-pcl`, `-pct` and `-pcr`
+func RunPerfCompare(files []string) error {
+	if len(files) != 2 {
+		return fmt.Errorf("perf_compare: expected 2 reports, got %d", len(files))
+	}
+	base, err := ReadPerfReport(files[0])
+	if err != nil {
+		return err
+	}
+	current, err := ReadPerfReport(files[1])
+	if err != nil {
+		return err
+	}
+
+	comparisons := ComparePerfReports(base, current, *perfCompareLatencyCmd, *perfCompareThroughputCmd, *perfCompareRejectsCmd)
+	fmt.Print(FormatPerfComparison(comparisons))
+
+	regressions := 0
+	for _, c := range comparisons {
+		if c.Regression {
+			regressions++
+		}
+	}
+	if regressions > 0 {
+		return fmt.Errorf("perf_compare: %d regressions", regressions)
+	}
+	return nil
+}
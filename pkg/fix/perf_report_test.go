@@ -0,0 +1,80 @@
+package fix
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/latency"
+)
+
+func TestComparePerfReportsThresholds(t *testing.T) {
+	report := func(throughput float64, p99 int64, rejects int64) *PerfReport {
+		return &PerfReport{
+			Sent:       10000,
+			Throughput: throughput,
+			Latency: map[string]LatencyReport{
+				"ack": {Count: 1000, P50: 1e6, P90: 1e6, P99: p99, P999: 1e6, Max: 1e6},
+			},
+			Rejects: map[string]int64{"j/Other": rejects},
+		}
+	}
+	base := report(100, 1e6, 100) // reject rate 1%
+
+	tests := []struct {
+		name        string
+		current     *PerfReport
+		regressions []string
+	}{
+		{"unchanged", report(100, 1e6, 100), nil},
+		{"throughput drop within threshold", report(90, 1e6, 100), nil},
+		{"throughput drop beyond threshold", report(89, 1e6, 100), []string{"orders_per_sec"}},
+		{"throughput increase", report(200, 1e6, 100), nil},
+		{"latency increase within threshold", report(100, 1.1e6, 100), nil},
+		{"latency increase beyond threshold", report(100, 1.2e6, 100), []string{"ack_p99_ms"}},
+		{"latency decrease", report(100, 0.5e6, 100), nil},
+		{"reject rate increase within threshold", report(100, 1e6, 105), nil},
+		{"reject rate increase beyond threshold", report(100, 1e6, 120), []string{"reject_rate_pct"}},
+		{"all regressed", report(50, 2e6, 200), []string{"orders_per_sec", "ack_p99_ms", "reject_rate_pct"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var regressions []string
+			for _, c := range ComparePerfReports(base, test.current, 10, 10, 0.1) {
+				if c.Regression {
+					regressions = append(regressions, c.Metric)
+				}
+			}
+			if len(regressions) != len(test.regressions) {
+				t.Fatalf("regressions %v, want %v", regressions, test.regressions)
+			}
+			for i := range regressions {
+				if regressions[i] != test.regressions[i] {
+					t.Errorf("regressions %v, want %v", regressions, test.regressions)
+				}
+			}
+		})
+	}
+}
+
+func TestComparePerfReportsUnits(t *testing.T) {
+	base := &PerfReport{Sent: 100, Throughput: 100, Rejects: map[string]int64{"j/Other": 1}}
+	current := &PerfReport{Sent: 100, Throughput: 100, Rejects: map[string]int64{"j/Other": 2}}
+	for _, c := range ComparePerfReports(base, current, 10, 10, 0.1) {
+		want := "%"
+		if c.Metric == "reject_rate_pct" {
+			want = "pp"
+		}
+		if c.Unit != want {
+			t.Errorf("%s: unit %q, want %q", c.Metric, c.Unit, want)
+		}
+	}
+}
+
+func TestNewPerfSampleCountsInterval(t *testing.T) {
+	prev := perfCounts{sent: 100, created: 90, closed: 80, rejected: 5, lost: 1}
+	counts := perfCounts{sent: 150, created: 130, closed: 125, rejected: 5, lost: 2}
+	s := newPerfSample(2*time.Second, counts, prev, latency.New().Snapshot(), latency.New().Snapshot(), latency.New().Snapshot())
+	if s.Elapsed != 2 || s.Sent != 50 || s.Created != 40 || s.Closed != 45 || s.Rejected != 0 || s.Lost != 1 {
+		t.Errorf("sample %+v, want counts of the interval", s)
+	}
+}
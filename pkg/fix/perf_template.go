@@ -0,0 +1,76 @@
+package fix
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+const transactTimeLayout = "20060102-15:04:05.000000000"
+
+// perfTemplateKey identifies a prebuilt message, symbol is -1 for the strategy of the profile
+type perfTemplateKey struct {
+	msgType     enum.MsgType
+	timeInForce enum.TimeInForce
+	symbol      int
+	side        enum.Side
+}
+
+// perfTemplate is a message of the perf hot path built once, ClOrdID, OrigClOrdID and TransactTime are patched
+// before each send. quickfix serializes a message before SendToTarget returns, so a template is reused by its worker
+// for the next send, and its field buffers too.
+type perfTemplate struct {
+	msg          *quickfix.Message
+	clOrdID      []byte
+	origClOrdID  []byte
+	transactTime []byte
+}
+
+func newPerfTemplate(m quickfix.Messagable) *perfTemplate {
+	return &perfTemplate{
+		msg:          m.ToMessage(),
+		clOrdID:      make([]byte, 0, 20),
+		origClOrdID:  make([]byte, 0, 20),
+		transactTime: make([]byte, 0, len(transactTimeLayout)),
+	}
+}
+
+// patch sets the varying fields, origClOrdID is set if not empty
+func (t *perfTemplate) patch(clOrdID uint64, origClOrdID string, transactTime time.Time) *quickfix.Message {
+	t.clOrdID = strconv.AppendUint(t.clOrdID[:0], clOrdID, 10)
+	t.msg.Body.SetBytes(tag.ClOrdID, t.clOrdID)
+	if origClOrdID != "" {
+		t.origClOrdID = append(t.origClOrdID[:0], origClOrdID...)
+		t.msg.Body.SetBytes(tag.OrigClOrdID, t.origClOrdID)
+	}
+	t.transactTime = transactTime.UTC().AppendFormat(t.transactTime[:0], transactTimeLayout)
+	t.msg.Body.SetBytes(tag.TransactTime, t.transactTime)
+	return t.msg
+}
+
+// newPerfTemplates builds the messages of every symbol and side of a profile
+func (w *perfWorker) newPerfTemplates() map[perfTemplateKey]*perfTemplate {
+	templates := make(map[perfTemplateKey]*perfTemplate)
+	for i, symbol := range w.profile.Symbols {
+		for _, side := range w.profile.sides {
+			templates[perfTemplateKey{enum.MsgType_ORDER_SINGLE, enum.TimeInForce_GOOD_TILL_CANCEL, i, side}] =
+				newPerfTemplate(w.singleOrder("0", symbol, side, enum.TimeInForce_GOOD_TILL_CANCEL))
+			templates[perfTemplateKey{enum.MsgType_ORDER_SINGLE, enum.TimeInForce_IMMEDIATE_OR_CANCEL, i, side}] =
+				newPerfTemplate(w.singleOrder("0", symbol, side, enum.TimeInForce_IMMEDIATE_OR_CANCEL))
+			templates[perfTemplateKey{enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST, "", i, side}] =
+				newPerfTemplate(w.replace("0", "0", symbol, side))
+			templates[perfTemplateKey{enum.MsgType_ORDER_CANCEL_REQUEST, "", i, side}] =
+				newPerfTemplate(w.cancel("0", symbol, side))
+		}
+	}
+	if len(w.profile.Strategy.Legs) > 0 {
+		for _, side := range w.profile.sides {
+			templates[perfTemplateKey{enum.MsgType_NEW_ORDER_MULTILEG, "", -1, side}] = newPerfTemplate(w.multilegOrder("0", side))
+			templates[perfTemplateKey{enum.MsgType_ORDER_CANCEL_REQUEST, "", -1, side}] = newPerfTemplate(w.cancel("0", PerfSymbol{}, side))
+		}
+	}
+	return templates
+}
@@ -0,0 +1,88 @@
+package fix
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+func newBenchPerfWorker(b *testing.B) *perfWorker {
+	profile := DefaultPerfProfile("", "bench")
+	if err := profile.validate(); err != nil {
+		b.Fatal(err)
+	}
+	return newPerfWorker(profile, quickfix.SessionID{BeginString: quickfix.BeginStringFIX44, SenderCompID: "bench", TargetCompID: "PT"}, 0)
+}
+
+// BenchmarkPerfOrderNew builds a NewOrderSingle from scratch for each order, as before templates
+func BenchmarkPerfOrderNew(b *testing.B) {
+	w := newBenchPerfWorker(b)
+	symbol := w.profile.Symbols[0]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg := w.singleOrder("1234567890", symbol, enum.Side_BUY, enum.TimeInForce_GOOD_TILL_CANCEL).ToMessage()
+		msg.Header.Set(field.NewSenderCompID("bench"))
+		msg.Header.Set(field.NewTargetCompID("PT"))
+		_ = msg.Bytes()
+	}
+}
+
+// BenchmarkPerfOrderTemplate patches the prebuilt NewOrderSingle, Bytes is the build of quickfix before sending
+func BenchmarkPerfOrderTemplate(b *testing.B) {
+	w := newBenchPerfWorker(b)
+	template := w.templates[perfTemplateKey{enum.MsgType_ORDER_SINGLE, enum.TimeInForce_GOOD_TILL_CANCEL, 0, enum.Side_BUY}]
+	template.msg.Header.Set(field.NewSenderCompID("bench"))
+	template.msg.Header.Set(field.NewTargetCompID("PT"))
+	now := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = template.patch(uint64(1234567890+i), "", now).Bytes()
+	}
+}
+
+// BenchmarkPerfFromApp handles a partial fill of a tracked order
+func BenchmarkPerfFromApp(b *testing.B) {
+	report := quickfix.NewMessage()
+	report.Header.Set(field.NewBeginString(quickfix.BeginStringFIX44))
+	report.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
+	report.Header.Set(field.NewSendingTime(time.Now()))
+	report.Body.Set(field.NewOrderID("9001"))
+	report.Body.Set(field.NewClOrdID("1234567890"))
+	report.Body.Set(field.NewExecID("1"))
+	report.Body.Set(field.NewExecType(enum.ExecType_TRADE))
+	report.Body.Set(field.NewOrdStatus(enum.OrdStatus_PARTIALLY_FILLED))
+	report.Body.Set(field.NewSymbol("BTC-USD"))
+	report.Body.Set(field.NewSide(enum.Side_BUY))
+	report.Body.Set(field.NewLeavesQty(decimal.RequireFromString("0.005"), 3))
+	report.Body.Set(field.NewCumQty(decimal.RequireFromString("0.005"), 3))
+	report.Body.Set(field.NewAvgPx(decimal.NewFromInt(1), 0))
+	report.Body.Set(field.NewTransactTime(time.Now()))
+
+	msg := quickfix.NewMessage()
+	if err := quickfix.ParseMessage(msg, bytes.NewBufferString(report.String())); err != nil {
+		b.Fatal(err)
+	}
+	if clOrdID, _ := msg.Body.GetString(tag.ClOrdID); clOrdID != "1234567890" {
+		b.Fatalf("ClOrdID %q", clOrdID)
+	}
+	activeOrders.Add("1234567890", OrderInfo{State: OrderState_CREATED, SentAt: time.Now()})
+	defer activeOrders.Remove([]byte("1234567890"))
+
+	app := PerfTradeClient{}
+	sessionID := quickfix.SessionID{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := app.FromApp(msg, sessionID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package positions keeps net positions and PnL built from drop-copy fills
+package positions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type Method int
+
+const (
+	Method_FIFO    Method = 0
+	Method_AVERAGE Method = 1
+)
+
+func ParseMethod(name string) (Method, error) {
+	switch strings.ToLower(name) {
+	case "fifo", "":
+		return Method_FIFO, nil
+	case "avg", "average":
+		return Method_AVERAGE, nil
+	default:
+		return Method_FIFO, fmt.Errorf("unknown PnL method '%s': fifo/avg", name)
+	}
+}
+
+func (m Method) String() string {
+	if m == Method_AVERAGE {
+		return "AVERAGE"
+	}
+	return "FIFO"
+}
+
+// Fill is a trade of a single instrument, a multileg fill is applied leg by leg
+type Fill struct {
+	ExecID string // unique per fill, used to skip duplicates
+	Symbol string
+	Buy    bool
+	Qty    decimal.Decimal
+	Px     decimal.Decimal
+	Fee    decimal.Decimal
+	Time   time.Time
+}
+
+// PriceFeed supplies marks for unrealized PnL
+type PriceFeed interface {
+	Mark(symbol string) (decimal.Decimal, bool)
+}
+
+// Marks is a PriceFeed updated by a market-data client or any other source
+type Marks struct {
+	mu     sync.RWMutex
+	prices map[string]decimal.Decimal
+}
+
+func NewMarks() *Marks {
+	return &Marks{prices: make(map[string]decimal.Decimal)}
+}
+
+func (m *Marks) Set(symbol string, px decimal.Decimal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prices[symbol] = px
+}
+
+func (m *Marks) Mark(symbol string) (decimal.Decimal, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	px, found := m.prices[symbol]
+	return px, found
+}
+
+// lot is an open part of a position, Qty is signed
+type lot struct {
+	qty decimal.Decimal
+	px  decimal.Decimal
+}
+
+type position struct {
+	symbol   string
+	qty      decimal.Decimal // signed net position
+	avgPx    decimal.Decimal
+	realized decimal.Decimal
+	fees     decimal.Decimal
+	lots     []lot // FIFO only
+	updated  time.Time
+}
+
+// Engine applies fills to per-symbol positions
+type Engine struct {
+	mu        sync.Mutex
+	method    Method
+	feed      PriceFeed
+	positions map[string]*position
+	seen      map[string]struct{}
+}
+
+func NewEngine(method Method, feed PriceFeed) *Engine {
+	return &Engine{
+		method:    method,
+		feed:      feed,
+		positions: make(map[string]*position),
+		seen:      make(map[string]struct{}),
+	}
+}
+
+// Apply returns false if the fill was already applied
+func (e *Engine) Apply(fill Fill) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if fill.ExecID != "" {
+		if _, found := e.seen[fill.ExecID]; found {
+			return false
+		}
+		e.seen[fill.ExecID] = struct{}{}
+	}
+
+	p := e.positions[fill.Symbol]
+	if p == nil {
+		p = &position{symbol: fill.Symbol}
+		e.positions[fill.Symbol] = p
+	}
+
+	qty := fill.Qty
+	if !fill.Buy {
+		qty = qty.Neg()
+	}
+	p.fees = p.fees.Add(fill.Fee)
+	p.updated = fill.Time
+
+	switch e.method {
+	case Method_AVERAGE:
+		p.applyAverage(qty, fill.Px)
+	default:
+		p.applyFIFO(qty, fill.Px)
+	}
+	return true
+}
+
+func (p *position) applyAverage(qty decimal.Decimal, px decimal.Decimal) {
+	if p.qty.IsZero() || p.qty.Sign() == qty.Sign() {
+		total := p.qty.Add(qty)
+		p.avgPx = p.qty.Mul(p.avgPx).Add(qty.Mul(px)).Div(total)
+		p.qty = total
+		return
+	}
+
+	closed := decimal.Min(p.qty.Abs(), qty.Abs())
+	p.realized = p.realized.Add(px.Sub(p.avgPx).Mul(closed).Mul(decimal.NewFromInt(int64(p.qty.Sign()))))
+	p.qty = p.qty.Add(qty)
+
+	switch {
+	case p.qty.IsZero():
+		p.avgPx = decimal.Zero
+	case p.qty.Sign() == qty.Sign():
+		// position flipped, the rest is opened at the fill price
+		p.avgPx = px
+	}
+}
+
+func (p *position) applyFIFO(qty decimal.Decimal, px decimal.Decimal) {
+	for !qty.IsZero() && len(p.lots) > 0 && p.lots[0].qty.Sign() != qty.Sign() {
+		first := &p.lots[0]
+		closed := decimal.Min(first.qty.Abs(), qty.Abs())
+		sign := decimal.NewFromInt(int64(first.qty.Sign()))
+
+		p.realized = p.realized.Add(px.Sub(first.px).Mul(closed).Mul(sign))
+		first.qty = first.qty.Sub(closed.Mul(sign))
+		qty = qty.Add(closed.Mul(sign))
+
+		if first.qty.IsZero() {
+			p.lots = p.lots[1:]
+		}
+	}
+	if !qty.IsZero() {
+		p.lots = append(p.lots, lot{qty: qty, px: px})
+	}
+
+	p.qty = decimal.Zero
+	notional := decimal.Zero
+	for _, l := range p.lots {
+		p.qty = p.qty.Add(l.qty)
+		notional = notional.Add(l.qty.Mul(l.px))
+	}
+	p.avgPx = decimal.Zero
+	if !p.qty.IsZero() {
+		p.avgPx = notional.Div(p.qty)
+	}
+}
+
+type PositionSnapshot struct {
+	Symbol        string
+	Qty           decimal.Decimal
+	AvgPx         decimal.Decimal
+	RealizedPnL   decimal.Decimal
+	Fees          decimal.Decimal
+	Mark          decimal.Decimal
+	HasMark       bool
+	UnrealizedPnL decimal.Decimal
+	Updated       time.Time
+}
+
+// HasUnrealized is false for an open position without a mark, its UnrealizedPnL is unknown rather than 0
+func (p PositionSnapshot) HasUnrealized() bool {
+	return p.HasMark || p.Qty.IsZero()
+}
+
+type Snapshot struct {
+	Time          time.Time
+	Method        Method
+	Positions     []PositionSnapshot
+	RealizedPnL   decimal.Decimal
+	UnrealizedPnL decimal.Decimal // of marked positions only
+	Unmarked      int             // open positions without a mark
+	Fees          decimal.Decimal
+}
+
+// Snapshot returns positions sorted by symbol, open positions without a mark are counted in Unmarked
+func (e *Engine) Snapshot() Snapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	snapshot := Snapshot{
+		Time:      time.Now().UTC(),
+		Method:    e.method,
+		Positions: make([]PositionSnapshot, 0, len(e.positions)),
+	}
+	for _, p := range e.positions {
+		ps := PositionSnapshot{
+			Symbol:      p.symbol,
+			Qty:         p.qty,
+			AvgPx:       p.avgPx,
+			RealizedPnL: p.realized,
+			Fees:        p.fees,
+			Updated:     p.updated,
+		}
+		if e.feed != nil {
+			ps.Mark, ps.HasMark = e.feed.Mark(p.symbol)
+		}
+		if ps.HasMark {
+			ps.UnrealizedPnL = ps.Mark.Sub(p.avgPx).Mul(p.qty)
+		} else if !ps.HasUnrealized() {
+			snapshot.Unmarked++
+		}
+
+		snapshot.Positions = append(snapshot.Positions, ps)
+		snapshot.RealizedPnL = snapshot.RealizedPnL.Add(ps.RealizedPnL)
+		snapshot.UnrealizedPnL = snapshot.UnrealizedPnL.Add(ps.UnrealizedPnL)
+		snapshot.Fees = snapshot.Fees.Add(ps.Fees)
+	}
+
+	sort.Slice(snapshot.Positions, func(i, j int) bool {
+		return snapshot.Positions[i].Symbol < snapshot.Positions[j].Symbol
+	})
+	return snapshot
+}
+
+// Get returns the snapshot of one symbol
+func (e *Engine) Get(symbol string) (PositionSnapshot, bool) {
+	for _, ps := range e.Snapshot().Positions {
+		if ps.Symbol == symbol {
+			return ps, true
+		}
+	}
+	return PositionSnapshot{}, false
+}
+
+func (s Snapshot) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Positions[%s] %s\n", s.Method, s.Time.Format(time.RFC3339))
+	fmt.Fprintf(&b, "%-24s %14s %14s %14s %14s %14s %12s\n", "Symbol", "Qty", "AvgPx", "Mark", "Realized", "Unrealized", "Fees")
+	for _, p := range s.Positions {
+		mark, unrealized := "-", "-"
+		if p.HasMark {
+			mark = p.Mark.String()
+		}
+		if p.HasUnrealized() {
+			unrealized = p.UnrealizedPnL.Round(8).String()
+		}
+		fmt.Fprintf(&b, "%-24s %14s %14s %14s %14s %14s %12s\n",
+			p.Symbol, p.Qty, p.AvgPx.Round(8), mark, p.RealizedPnL.Round(8), unrealized, p.Fees,
+		)
+	}
+	fmt.Fprintf(&b, "%-24s %14s %14s %14s %14s %14s %12s\n",
+		"TOTAL", "", "", "", s.RealizedPnL.Round(8), s.UnrealizedPnL.Round(8), s.Fees,
+	)
+	if s.Unmarked > 0 {
+		fmt.Fprintf(&b, "Unrealized PnL excludes %d open positions without a mark\n", s.Unmarked)
+	}
+	return b.String()
+}
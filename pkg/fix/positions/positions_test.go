@@ -0,0 +1,87 @@
+package positions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestEnginePnL(t *testing.T) {
+	d := decimal.RequireFromString
+	buy := func(execID string, qty string, px string) Fill {
+		return Fill{ExecID: execID, Symbol: "BTC-USD", Buy: true, Qty: d(qty), Px: d(px), Time: time.Unix(0, 0)}
+	}
+	sell := func(execID string, qty string, px string) Fill {
+		fill := buy(execID, qty, px)
+		fill.Buy = false
+		return fill
+	}
+
+	tests := []struct {
+		name       string
+		method     Method
+		fills      []Fill
+		mark       string // empty for no mark
+		qty        string
+		avgPx      string
+		realized   string
+		unrealized string
+	}{
+		{"fifo closes the first lot", Method_FIFO, []Fill{buy("1", "1", "100"), buy("2", "1", "200"), sell("3", "1", "300")}, "250", "1", "200", "200", "50"},
+		{"avg closes at the average", Method_AVERAGE, []Fill{buy("1", "1", "100"), buy("2", "1", "200"), sell("3", "1", "300")}, "250", "1", "150", "150", "100"},
+		{"fifo round trip", Method_FIFO, []Fill{buy("1", "2", "100"), sell("2", "2", "110")}, "", "0", "0", "20", "0"},
+		{"avg round trip", Method_AVERAGE, []Fill{buy("1", "2", "100"), sell("2", "2", "110")}, "", "0", "0", "20", "0"},
+		{"fifo short covered", Method_FIFO, []Fill{sell("1", "1", "100"), sell("2", "1", "120"), buy("3", "1", "90")}, "100", "-1", "120", "10", "20"},
+		{"avg short covered", Method_AVERAGE, []Fill{sell("1", "1", "100"), sell("2", "1", "120"), buy("3", "1", "90")}, "100", "-1", "110", "20", "10"},
+		{"fifo flips to short", Method_FIFO, []Fill{buy("1", "1", "100"), sell("2", "3", "110")}, "", "-2", "110", "10", "0"},
+		{"avg flips to short", Method_AVERAGE, []Fill{buy("1", "1", "100"), sell("2", "3", "110")}, "", "-2", "110", "10", "0"},
+		{"fifo skips duplicated fills", Method_FIFO, []Fill{buy("1", "1", "100"), buy("1", "1", "100"), sell("2", "1", "150")}, "", "0", "0", "50", "0"},
+		{"avg skips duplicated fills", Method_AVERAGE, []Fill{buy("1", "1", "100"), buy("1", "1", "100"), sell("2", "1", "150")}, "", "0", "0", "50", "0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			marks := NewMarks()
+			if test.mark != "" {
+				marks.Set("BTC-USD", d(test.mark))
+			}
+			engine := NewEngine(test.method, marks)
+			for _, fill := range test.fills {
+				engine.Apply(fill)
+			}
+
+			p, found := engine.Get("BTC-USD")
+			if !found {
+				t.Fatal("no position")
+			}
+			if !p.Qty.Equal(d(test.qty)) || !p.AvgPx.Equal(d(test.avgPx)) {
+				t.Errorf("position %s @ %s, want %s @ %s", p.Qty, p.AvgPx, test.qty, test.avgPx)
+			}
+			if !p.RealizedPnL.Equal(d(test.realized)) {
+				t.Errorf("realized %s, want %s", p.RealizedPnL, test.realized)
+			}
+			if !p.UnrealizedPnL.Equal(d(test.unrealized)) {
+				t.Errorf("unrealized %s, want %s", p.UnrealizedPnL, test.unrealized)
+			}
+			if p.HasUnrealized() != (test.mark != "" || p.Qty.IsZero()) {
+				t.Errorf("HasUnrealized %v with mark %q", p.HasUnrealized(), test.mark)
+			}
+		})
+	}
+}
+
+func TestSnapshotUnmarked(t *testing.T) {
+	marks := NewMarks()
+	marks.Set("BTC-USD", decimal.NewFromInt(110))
+	engine := NewEngine(Method_FIFO, marks)
+	engine.Apply(Fill{ExecID: "1", Symbol: "BTC-USD", Buy: true, Qty: decimal.NewFromInt(1), Px: decimal.NewFromInt(100)})
+	engine.Apply(Fill{ExecID: "2", Symbol: "ETH-USD", Buy: true, Qty: decimal.NewFromInt(1), Px: decimal.NewFromInt(10)})
+
+	snapshot := engine.Snapshot()
+	if snapshot.Unmarked != 1 {
+		t.Errorf("Unmarked %d, want 1", snapshot.Unmarked)
+	}
+	if !snapshot.UnrealizedPnL.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("unrealized %s, want 10", snapshot.UnrealizedPnL)
+	}
+}
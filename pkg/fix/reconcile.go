@@ -0,0 +1,324 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+)
+
+const (
+	reconcileTombstones = 100000 // keys of closed entries remembered to ignore late duplicates
+)
+
+var (
+	reconcileIntervalCmd = flag.Duration("ri", 10*time.Second, "Reconcile: report interval")
+	reconcileLateCmd     = flag.Duration("rl", time.Second, "Reconcile: drop copy later than this is reported as late")
+	reconcileMissingCmd  = flag.Duration("rm", 10*time.Second, "Reconcile: no counterpart after this is reported as missing")
+)
+
+// ExecutionClient passes decoded ExecutionReports of a session to a callback
+type ExecutionClient struct {
+	*TradeClient
+	onExecution func(exec *journal.Execution)
+}
+
+func (e *ExecutionClient) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	reject = e.TradeClient.FromApp(msg, sessionID)
+
+	msgType, _ := msg.MsgType()
+	if enum.MsgType(msgType) == enum.MsgType_EXECUTION_REPORT {
+		e.onExecution(DecodeExecution(msg, time.Now()))
+	}
+	return
+}
+
+type reconcileEntry struct {
+	oe       *journal.Execution
+	dc       *journal.Execution
+	reported bool
+}
+
+// tombstones is a bounded set of keys, the oldest are forgotten first
+type tombstones struct {
+	keys []string
+	next int
+	set  map[string]struct{}
+}
+
+func newTombstones(size int) *tombstones {
+	return &tombstones{keys: make([]string, 0, size), set: make(map[string]struct{}, size)}
+}
+
+func (t *tombstones) add(key string) {
+	if len(t.keys) < cap(t.keys) {
+		t.keys = append(t.keys, key)
+	} else {
+		delete(t.set, t.keys[t.next])
+		t.keys[t.next] = key
+		t.next = (t.next + 1) % len(t.keys)
+	}
+	t.set[key] = struct{}{}
+}
+
+func (t *tombstones) has(key string) bool {
+	_, found := t.set[key]
+	return found
+}
+
+type ReconcileStats struct {
+	Matched      int64
+	Late         int64
+	Inconsistent int64
+	MissingDC    int64 // seen on order entry only
+	MissingOE    int64 // seen on drop copy only, for an order placed by order entry
+	Unknown      int64 // seen on drop copy only, for an order no known session placed
+}
+
+// Reconciler matches ExecutionReports of order-entry against drop-copy by OrderID/ExecID
+type Reconciler struct {
+	mu         sync.Mutex
+	lateAfter  time.Duration
+	missAfter  time.Duration
+	entries    map[string]*reconcileEntry // OrderID/ExecID -> reports
+	closed     *tombstones                // keys of entries removed by Check
+	oeOrderIDs map[string]time.Time       // OrderID -> last order entry report
+	stats      ReconcileStats
+	issues     []string // since the last report
+}
+
+func NewReconciler(lateAfter time.Duration, missAfter time.Duration) *Reconciler {
+	return &Reconciler{
+		lateAfter:  lateAfter,
+		missAfter:  missAfter,
+		entries:    make(map[string]*reconcileEntry),
+		closed:     newTombstones(reconcileTombstones),
+		oeOrderIDs: make(map[string]time.Time),
+	}
+}
+
+// entry returns nil for a report of an entry already closed, i.e. a late duplicate
+func (r *Reconciler) entry(exec *journal.Execution) *reconcileEntry {
+	key := exec.OrderID + "/" + exec.ExecID
+	entry := r.entries[key]
+	if entry == nil {
+		if r.closed.has(key) {
+			return nil
+		}
+		entry = &reconcileEntry{}
+		r.entries[key] = entry
+	}
+	return entry
+}
+
+func (r *Reconciler) OnOrderEntry(exec *journal.Execution) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.oeOrderIDs[exec.OrderID] = exec.ReceivedAt
+	entry := r.entry(exec)
+	if entry == nil || entry.oe != nil {
+		return // resent
+	}
+	entry.oe = exec
+	if entry.dc != nil {
+		r.match(entry)
+	}
+}
+
+func (r *Reconciler) OnDropCopy(exec *journal.Execution) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := r.entry(exec)
+	if entry == nil || entry.dc != nil {
+		return // resent
+	}
+	entry.dc = exec
+	if entry.oe != nil {
+		r.match(entry)
+	}
+}
+
+func (r *Reconciler) match(entry *reconcileEntry) {
+	oe, dc := entry.oe, entry.dc
+	entry.reported = true
+	r.stats.Matched++
+
+	if delay := dc.ReceivedAt.Sub(oe.ReceivedAt); delay > r.lateAfter {
+		r.stats.Late++
+		r.issue("LATE", dc, fmt.Sprintf("drop copy %v after order entry", delay.Round(time.Millisecond)))
+	}
+
+	mismatches := make([]string, 0)
+	compare := func(name string, a string, b string) {
+		if a != b {
+			mismatches = append(mismatches, fmt.Sprintf("%s %s != %s", name, a, b))
+		}
+	}
+	compare("ExecType", string(oe.ExecType), string(dc.ExecType))
+	compare("OrdStatus", string(oe.OrdStatus), string(dc.OrdStatus))
+	compare("Symbol", oe.Symbol, dc.Symbol)
+	compare("Side", string(oe.Side), string(dc.Side))
+	compare("LastQty", oe.LastQty.String(), dc.LastQty.String())
+	compare("LastPx", oe.LastPx.String(), dc.LastPx.String())
+	compare("CumQty", oe.CumQty.String(), dc.CumQty.String())
+	compare("LeavesQty", oe.LeavesQty.String(), dc.LeavesQty.String())
+	if !oe.Price.IsZero() && !dc.Price.IsZero() {
+		compare("Price", oe.Price.String(), dc.Price.String())
+	}
+	if !oe.OrderQty.IsZero() && !dc.OrderQty.IsZero() {
+		compare("OrderQty", oe.OrderQty.String(), dc.OrderQty.String())
+	}
+
+	if len(mismatches) > 0 {
+		r.stats.Inconsistent++
+		r.issue("INCONSISTENT", dc, strings.Join(mismatches, ", "))
+	}
+}
+
+func (r *Reconciler) issue(kind string, exec *journal.Execution, details string) {
+	r.issues = append(r.issues, fmt.Sprintf("%-12s OrderID=%s ExecID=%s ClOrdID=%s %s %s",
+		kind, exec.OrderID, exec.ExecID, exec.ClOrdID, exec.Symbol, details,
+	))
+}
+
+// Check reports reports without a counterpart for longer than the missing timeout
+func (r *Reconciler) Check(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, entry := range r.entries {
+		if entry.reported {
+			// no more reports expected long after
+			first := entry.oe
+			if first == nil {
+				first = entry.dc
+			}
+			if now.Sub(first.ReceivedAt) > 10*r.missAfter {
+				delete(r.entries, key)
+				r.closed.add(key)
+			}
+			continue
+		}
+
+		switch {
+		case entry.dc == nil && now.Sub(entry.oe.ReceivedAt) > r.missAfter:
+			entry.reported = true
+			r.stats.MissingDC++
+			r.issue("MISSING_DC", entry.oe, "no drop copy")
+		case entry.oe == nil && now.Sub(entry.dc.ReceivedAt) > r.missAfter:
+			entry.reported = true
+			if _, found := r.oeOrderIDs[entry.dc.OrderID]; found {
+				r.stats.MissingOE++
+				r.issue("MISSING_OE", entry.dc, "no order entry report")
+			} else {
+				r.stats.Unknown++
+				r.issue("UNKNOWN", entry.dc, "order placed by no known session")
+			}
+		}
+	}
+
+	// orders of order entry are forgotten as their entries, drop copies of them are late duplicates by then
+	for orderID, lastSeen := range r.oeOrderIDs {
+		if now.Sub(lastSeen) > 10*r.missAfter {
+			delete(r.oeOrderIDs, orderID)
+		}
+	}
+}
+
+// Report returns stats and issues found since the previous report
+func (r *Reconciler) Report(now time.Time) string {
+	r.Check(now)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Reconcile %s: matched=%d late=%d inconsistent=%d missing_dc=%d missing_oe=%d unknown=%d pending=%d\n",
+		now.UTC().Format(time.RFC3339), r.stats.Matched, r.stats.Late, r.stats.Inconsistent,
+		r.stats.MissingDC, r.stats.MissingOE, r.stats.Unknown, r.pending(),
+	)
+	sort.Strings(r.issues)
+	for _, issue := range r.issues {
+		fmt.Fprintf(&b, "\t%s\n", issue)
+	}
+	r.issues = r.issues[:0]
+	return b.String()
+}
+
+func (r *Reconciler) Stats() ReconcileStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+func (r *Reconciler) pending() int {
+	pending := 0
+	for _, entry := range r.entries {
+		if !entry.reported {
+			pending++
+		}
+	}
+	return pending
+}
+
+// RunReconcile runs order-entry actions and compares their ExecutionReports with drop copy
+func RunReconcile(cfgFilenameOE string, cfgFilenameDC string, apiKeyName string) error {
+	reconciler := NewReconciler(*reconcileLateCmd, *reconcileMissingCmd)
+
+	tappDC, err := NewTradeClient(cfgFilenameDC, apiKeyName)
+	if err != nil {
+		return err
+	}
+	appDC := &ExecutionClient{TradeClient: tappDC, onExecution: reconciler.OnDropCopy}
+	err = StartConnection(appDC, appDC.Settings)
+	if err != nil {
+		return err
+	}
+
+	tappOE, err := NewTradeClient(cfgFilenameOE, apiKeyName)
+	if err != nil {
+		return err
+	}
+	appOE := &ExecutionClient{TradeClient: tappOE, onExecution: reconciler.OnOrderEntry}
+	if *recoverOrdersCmd {
+		EnableOrderRecovery(appOE.TradeClient)
+	}
+	err = StartConnection(appOE, appOE.Settings)
+	if err != nil {
+		return err
+	}
+	targetCompID, _ := appOE.Settings.GlobalSettings().Setting(config.TargetCompID)
+
+	go func() {
+		for {
+			time.Sleep(*reconcileIntervalCmd)
+			fmt.Printf("\n%s\n", reconciler.Report(time.Now()))
+		}
+	}()
+
+	// as in RunOrderEntry
+	if usesAction("addOrderMultiLeg") || *buyingPowerCmd {
+		err = LoadInstruments(appOE.TradeClient, targetCompID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if appOE.RecoverOrders {
+		err = WaitOrderRecovery()
+		if err != nil {
+			return err
+		}
+	}
+
+	return runOrderEntryActions(appOE.TradeClient, targetCompID)
+}
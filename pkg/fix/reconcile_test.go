@@ -0,0 +1,129 @@
+package fix
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+)
+
+// reconcileEvent is a report received by a session at an offset of the test start
+type reconcileEvent struct {
+	dc      bool
+	orderID string
+	execID  string
+	at      time.Duration
+	cumQty  string
+}
+
+func TestReconciler(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	oe := func(orderID, execID string, at time.Duration) reconcileEvent {
+		return reconcileEvent{false, orderID, execID, at, "1"}
+	}
+	dc := func(orderID, execID string, at time.Duration) reconcileEvent {
+		return reconcileEvent{true, orderID, execID, at, "1"}
+	}
+
+	tests := []struct {
+		name    string
+		events  []reconcileEvent
+		check   time.Duration
+		stats   ReconcileStats
+		pending int
+		issues  []string
+	}{
+		{"match", []reconcileEvent{oe("1", "a", 0), dc("1", "a", 100*time.Millisecond)}, time.Minute,
+			ReconcileStats{Matched: 1}, 0, nil},
+		{"drop copy first", []reconcileEvent{dc("1", "a", 0), oe("1", "a", 100*time.Millisecond)}, time.Minute,
+			ReconcileStats{Matched: 1}, 0, nil},
+		{"late", []reconcileEvent{oe("1", "a", 0), dc("1", "a", 2*time.Second)}, time.Minute,
+			ReconcileStats{Matched: 1, Late: 1}, 0, []string{"LATE"}},
+		{"inconsistent", []reconcileEvent{oe("1", "a", 0), {true, "1", "a", 0, "2"}}, time.Minute,
+			ReconcileStats{Matched: 1, Inconsistent: 1}, 0, []string{"INCONSISTENT", "CumQty 1 != 2"}},
+		{"resent", []reconcileEvent{oe("1", "a", 0), oe("1", "a", 0), dc("1", "a", 0), dc("1", "a", 0)}, time.Minute,
+			ReconcileStats{Matched: 1}, 0, nil},
+		{"pending", []reconcileEvent{oe("1", "a", 0)}, 5 * time.Second,
+			ReconcileStats{}, 1, nil},
+		{"missing drop copy", []reconcileEvent{oe("1", "a", 0)}, 11 * time.Second,
+			ReconcileStats{MissingDC: 1}, 0, []string{"MISSING_DC"}},
+		{"missing order entry", []reconcileEvent{oe("1", "a", 0), dc("1", "a", 0), dc("1", "b", time.Second)}, 12 * time.Second,
+			ReconcileStats{Matched: 1, MissingOE: 1}, 0, []string{"MISSING_OE", "ExecID=b"}},
+		{"unknown", []reconcileEvent{oe("1", "a", 0), dc("1", "a", 0), dc("2", "b", time.Second)}, 12 * time.Second,
+			ReconcileStats{Matched: 1, Unknown: 1}, 0, []string{"UNKNOWN", "OrderID=2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewReconciler(time.Second, 10*time.Second)
+			for _, event := range test.events {
+				exec := &journal.Execution{
+					OrderID: event.orderID, ExecID: event.execID, ExecType: enum.ExecType_TRADE, Symbol: "BTC-USD",
+					CumQty: decimal.RequireFromString(event.cumQty), ReceivedAt: start.Add(event.at),
+				}
+				if event.dc {
+					r.OnDropCopy(exec)
+				} else {
+					r.OnOrderEntry(exec)
+				}
+			}
+
+			report := r.Report(start.Add(test.check))
+			if stats := r.Stats(); stats != test.stats {
+				t.Errorf("stats %+v, want %+v", stats, test.stats)
+			}
+			if pending := r.pending(); pending != test.pending {
+				t.Errorf("%d pending, want %d", pending, test.pending)
+			}
+			lines := strings.Split(strings.TrimSpace(report), "\n")
+			if issues := len(lines) - 1; (issues > 0) != (len(test.issues) > 0) {
+				t.Errorf("%d issues in report:\n%s", issues, report)
+			}
+			for _, issue := range test.issues {
+				if !strings.Contains(report, issue) {
+					t.Errorf("no %q in report:\n%s", issue, report)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcilerTombstones(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	exec := func(at time.Duration) *journal.Execution {
+		return &journal.Execution{OrderID: "1", ExecID: "a", ExecType: enum.ExecType_NEW, ReceivedAt: start.Add(at)}
+	}
+	r := NewReconciler(time.Second, 10*time.Second)
+	r.OnOrderEntry(exec(0))
+	r.OnDropCopy(exec(0))
+
+	// the matched entry is closed long after, a drop copy resent then is not an unknown order
+	r.Check(start.Add(2 * time.Minute))
+	if len(r.entries) != 0 || !r.closed.has("1/a") {
+		t.Fatalf("entries %v not closed", r.entries)
+	}
+	r.OnDropCopy(exec(3 * time.Minute))
+	r.Check(start.Add(4 * time.Minute))
+	if stats := r.Stats(); stats != (ReconcileStats{Matched: 1}) {
+		t.Errorf("stats %+v after a late duplicate", stats)
+	}
+	if len(r.entries) != 0 {
+		t.Errorf("late duplicate kept: %v", r.entries)
+	}
+}
+
+func TestTombstonesBounded(t *testing.T) {
+	closed := newTombstones(2)
+	for _, key := range []string{"a", "b", "c"} {
+		closed.add(key)
+	}
+	if closed.has("a") || !closed.has("b") || !closed.has("c") || len(closed.set) != 2 {
+		t.Errorf("tombstones %v, want the 2 latest", closed.keys)
+	}
+	closed.add("d")
+	if closed.has("b") || !closed.has("d") {
+		t.Errorf("tombstones %v, want c and d", closed.keys)
+	}
+}
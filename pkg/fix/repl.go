@@ -0,0 +1,497 @@
+package fix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/newordersingle"
+	"github.com/quickfixgo/fix44/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix44/securitydefinitionrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+	"golang.org/x/term"
+)
+
+const replPrompt = "fix> "
+
+var (
+	replCommands = []string{"buy", "sell", "cancel", "amend", "orders", "fills", "secdef", "help", "quit"}
+
+	replOrdStatuses = map[string]string{
+		"new":              "0",
+		"partially_filled": "1",
+		"filled":           "2",
+		"canceled":         "4",
+		"pending_cancel":   "6",
+		"rejected":         "8",
+		"pending_new":      "A",
+		"expired":          "C",
+		"pending_replace":  "E",
+	}
+)
+
+const replHelp = `Commands:
+  buy|sell <symbol> <qty> [@ <price>] [gtc|ioc|fok|day|gtd]   market order without a price, gtc by default
+  cancel <id>                                                 by ClOrdID or OrderID
+  amend <id> <qty> [@ <price>]                                OrderCancelReplaceRequest
+  orders                                                      working orders
+  fills                                                       fills of this session
+  secdef <symbol>                                             SecurityDefinition
+  help, quit
+Tab completes commands and symbols, Up/Down browse the history.
+`
+
+// replFill is a fill of an ExecutionReport
+type replFill struct {
+	At      time.Time
+	ClOrdID string
+	Symbol  string
+	Side    enum.Side
+	Qty     decimal.Decimal
+	Px      decimal.Decimal
+}
+
+// repl is an interactive order entry shell, responses are printed as they arrive
+type repl struct {
+	app          *TradeClient
+	targetCompID string
+	out          io.Writer
+
+	mu    sync.Mutex
+	fills []replFill
+}
+
+// replApp prints ExecutionReports and rejects to the shell
+type replApp struct {
+	*TradeClient
+	repl *repl
+}
+
+func (a replApp) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	reject := a.TradeClient.FromApp(msg, sessionID)
+	a.repl.onMessage(msg)
+	return reject
+}
+
+func (a replApp) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	if msgType, _ := msg.MsgType(); enum.MsgType(msgType) == enum.MsgType_REJECT {
+		a.repl.printf("<< Reject RefSeqNum=%s: %s\n", getString(msg.Body, tag.RefSeqNum), getString(msg.Body, tag.Text))
+	}
+	return a.TradeClient.FromAdmin(msg, sessionID)
+}
+
+func (r *repl) printf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, format, args...)
+}
+
+func (r *repl) onMessage(msg *quickfix.Message) {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_EXECUTION_REPORT:
+		execType := enum.ExecType(getString(msg.Body, tag.ExecType))
+		line := fmt.Sprintf("<< %s %s %s %s ClOrdID=%s OrderID=%s OrdStatus=%s Cum=%s Leaves=%s",
+			codeName(scenarioExecTypes, string(execType)), getString(msg.Body, tag.Symbol), codeName(scenarioSides, getString(msg.Body, tag.Side)),
+			getString(msg.Body, tag.OrderQty), getString(msg.Body, tag.ClOrdID), getString(msg.Body, tag.OrderID),
+			codeName(replOrdStatuses, getString(msg.Body, tag.OrdStatus)),
+			getString(msg.Body, tag.CumQty), getString(msg.Body, tag.LeavesQty))
+		if execType == enum.ExecType_TRADE {
+			fill := replFill{
+				At:      time.Now(),
+				ClOrdID: getString(msg.Body, tag.ClOrdID),
+				Symbol:  getString(msg.Body, tag.Symbol),
+				Side:    enum.Side(getString(msg.Body, tag.Side)),
+				Qty:     getDecimal(msg.Body, tag.LastQty),
+				Px:      getDecimal(msg.Body, tag.LastPx),
+			}
+			r.mu.Lock()
+			r.fills = append(r.fills, fill)
+			r.mu.Unlock()
+			line += fmt.Sprintf(" Last=%s@%s", fill.Qty, fill.Px)
+		}
+		if text := getString(msg.Body, tag.Text); text != "" {
+			line += " Text=" + text
+		}
+		r.printf("%s\n", line)
+
+	case enum.MsgType_ORDER_CANCEL_REJECT:
+		r.printf("<< CancelReject ClOrdID=%s OrigClOrdID=%s reason=%s %s\n", getString(msg.Body, tag.ClOrdID),
+			getString(msg.Body, tag.OrigClOrdID), getString(msg.Body, tag.CxlRejReason), getString(msg.Body, tag.Text))
+
+	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
+		r.printf("<< BusinessReject RefID=%s reason=%s %s\n", getString(msg.Body, tag.BusinessRejectRefID),
+			getString(msg.Body, tag.BusinessRejectReason), getString(msg.Body, tag.Text))
+
+	case enum.MsgType_SECURITY_DEFINITION:
+		if instrument, found := DefaultInstruments.Get(getString(msg.Body, tag.Symbol)); found {
+			r.printf("<< %s\n", formatInstrument(instrument))
+		}
+	}
+}
+
+func formatInstrument(i *Instrument) string {
+	res := fmt.Sprintf("%s %s currency=%s", i.Symbol, i.SecurityType, i.Currency)
+	if i.Underlying != "" {
+		res += " underlying=" + i.Underlying
+	}
+	if i.IsOption() {
+		res += fmt.Sprintf(" %s strike=%s", i.PutOrCall, i.StrikePrice)
+	}
+	if i.MaturityDate != "" {
+		res += " maturity=" + i.MaturityDate
+	}
+	return res + fmt.Sprintf(" multiplier=%s lot=%s min=%s", i.ContractMultiplier, i.RoundLot, i.MinTradeVol)
+}
+
+// complete completes the command, else the symbol being typed at the end of the line
+func (r *repl) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || pos != len(line) {
+		return "", 0, false
+	}
+	words := strings.Split(line, " ")
+	prefix := words[len(words)-1]
+
+	candidates := DefaultInstruments.Symbols()
+	if len(words) == 1 {
+		candidates = replCommands
+	}
+	matches := make([]string, 0)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToUpper(candidate), strings.ToUpper(prefix)) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	if len(matches) > 1 {
+		r.printf("%s\n", strings.Join(matches, " "))
+	}
+
+	// the longest common prefix of the matches
+	common := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(matches) == 1 {
+		common += " "
+	}
+	if len(common) < len(prefix) {
+		return "", 0, false
+	}
+	newLine := strings.Join(words[:len(words)-1], " ")
+	if len(words) > 1 {
+		newLine += " "
+	}
+	newLine += common
+	return newLine, len(newLine), true
+}
+
+func (r *repl) send(msg *quickfix.Message) error {
+	msg.Header.Set(field.NewSenderCompID(r.app.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(r.targetCompID))
+	return Send(msg)
+}
+
+// parseQtyPrice parses `<qty> [@ <price>]`, also `<qty>@<price>`, and returns the remaining words
+func parseQtyPrice(words []string) (qty decimal.Decimal, price decimal.Decimal, rest []string, err error) {
+	words = strings.Fields(strings.ReplaceAll(strings.Join(words, " "), "@", " @ "))
+	if len(words) == 0 {
+		return qty, price, nil, fmt.Errorf("qty is required")
+	}
+	if qty, err = decimal.NewFromString(words[0]); err != nil {
+		return qty, price, nil, fmt.Errorf("qty '%s': %v", words[0], err)
+	}
+	rest = words[1:]
+	if len(rest) >= 2 && rest[0] == "@" {
+		if price, err = decimal.NewFromString(rest[1]); err != nil {
+			return qty, price, nil, fmt.Errorf("price '%s': %v", rest[1], err)
+		}
+		rest = rest[2:]
+	}
+	return qty, price, rest, nil
+}
+
+func (r *repl) order(side enum.Side, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: buy|sell <symbol> <qty> [@ <price>] [gtc|ioc|fok|day|gtd]")
+	}
+	symbol := args[0]
+	qty, price, rest, err := parseQtyPrice(args[1:])
+	if err != nil {
+		return err
+	}
+	tif := enum.TimeInForce_GOOD_TILL_CANCEL
+	if len(rest) > 0 {
+		code, found := scenarioTIFs[strings.ToLower(rest[0])]
+		if !found {
+			return fmt.Errorf("time in force '%s': expected gtc, ioc, fok, day or gtd", rest[0])
+		}
+		tif = enum.TimeInForce(code)
+	}
+
+	ordType := enum.OrdType_LIMIT
+	if price.IsZero() {
+		ordType = enum.OrdType_MARKET
+	}
+	clOrdID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	order := newordersingle.New(
+		field.NewClOrdID(clOrdID),
+		field.NewSide(side),
+		field.NewTransactTime(time.Now()),
+		field.NewOrdType(ordType),
+	)
+	order.Set(field.NewSymbol(symbol))
+	order.Set(field.NewOrderQty(qty, scaleOf(qty)))
+	if !price.IsZero() {
+		order.Set(field.NewPrice(price, scaleOf(price)))
+	}
+	order.Set(field.NewTimeInForce(tif))
+	if tif == enum.TimeInForce_GOOD_TILL_DATE {
+		order.Set(field.NewExpireTime(time.Now().AddDate(0, 0, 1)))
+	}
+
+	r.printf(">> %s %s %s %s ClOrdID=%s\n", codeName(scenarioSides, string(side)), symbol, qty, price, clOrdID)
+	return r.send(order.ToMessage())
+}
+
+func (r *repl) trackedOrder(id string) (TrackedOrder, error) {
+	order, found := DefaultOrderTracker.Get(id)
+	if !found {
+		return order, fmt.Errorf("unknown order '%s', see `orders`", id)
+	}
+	return order, nil
+}
+
+func (r *repl) cancel(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cancel <id>")
+	}
+	order, err := r.trackedOrder(args[0])
+	if err != nil {
+		return err
+	}
+	cancel := NewOrderCancel(order)
+	r.printf(">> cancel %s ClOrdID=%s\n", order.ClOrdID, getString(cancel.Body, tag.ClOrdID))
+	return r.send(cancel)
+}
+
+func (r *repl) amend(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: amend <id> <qty> [@ <price>]")
+	}
+	order, err := r.trackedOrder(args[0])
+	if err != nil {
+		return err
+	}
+	qty, price, _, err := parseQtyPrice(args[1:])
+	if err != nil {
+		return err
+	}
+	if price.IsZero() {
+		price = order.Price
+	}
+
+	clOrdID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	replace := ordercancelreplacerequest.New(
+		field.NewOrigClOrdID(order.ClOrdID),
+		field.NewClOrdID(clOrdID),
+		field.NewSide(order.Side),
+		field.NewTransactTime(time.Now()),
+		field.NewOrdType(enum.OrdType_LIMIT),
+	)
+	replace.Set(field.NewSymbol(order.Symbol))
+	replace.Set(field.NewOrderQty(qty, scaleOf(qty)))
+	replace.Set(field.NewPrice(price, scaleOf(price)))
+	if order.OrderID != "" {
+		replace.Set(field.NewOrderID(order.OrderID))
+	}
+
+	r.printf(">> amend %s %s @ %s ClOrdID=%s\n", order.ClOrdID, qty, price, clOrdID)
+	return r.send(replace.ToMessage())
+}
+
+func (r *repl) orders() {
+	orders := DefaultOrderTracker.Working()
+	if len(orders) == 0 {
+		r.printf("No working orders\n")
+		return
+	}
+	for _, o := range orders {
+		r.printf("%-20s %-20s %-24s %-4s %s @ %s cum=%s leaves=%s %s\n", o.ClOrdID, o.OrderID, o.Symbol,
+			codeName(scenarioSides, string(o.Side)), o.OrderQty, o.Price, o.CumQty, o.LeavesQty, codeName(replOrdStatuses, string(o.OrdStatus)))
+	}
+}
+
+func (r *repl) printFills() {
+	r.mu.Lock()
+	fills := append([]replFill{}, r.fills...)
+	r.mu.Unlock()
+	if len(fills) == 0 {
+		r.printf("No fills\n")
+		return
+	}
+	for _, f := range fills {
+		r.printf("%s %-20s %-24s %-4s %s @ %s\n", f.At.Format("15:04:05.000"), f.ClOrdID, f.Symbol, codeName(scenarioSides, string(f.Side)), f.Qty, f.Px)
+	}
+}
+
+func (r *repl) secdef(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: secdef <symbol>")
+	}
+	if instrument, found := DefaultInstruments.Get(args[0]); found {
+		r.printf("%s\n", formatInstrument(instrument))
+	}
+	request := securitydefinitionrequest.New(
+		field.NewSecurityReqID(fmt.Sprint(pt.DefaultTokenGenerator.Next())),
+		field.NewSecurityRequestType(enum.SecurityRequestType_REQUEST_SECURITY_IDENTITY_AND_SPECIFICATIONS),
+	)
+	request.SetSymbol(args[0])
+	return r.send(request.ToMessage())
+}
+
+// execute runs a command line, returns false to quit
+func (r *repl) execute(line string) bool {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return true
+	}
+
+	var err error
+	switch cmd, args := strings.ToLower(words[0]), words[1:]; cmd {
+	case "buy":
+		err = r.order(enum.Side_BUY, args)
+	case "sell":
+		err = r.order(enum.Side_SELL, args)
+	case "cancel":
+		err = r.cancel(args)
+	case "amend":
+		err = r.amend(args)
+	case "orders":
+		r.orders()
+	case "fills":
+		r.printFills()
+	case "secdef":
+		err = r.secdef(args)
+	case "help", "?":
+		r.printf("%s", replHelp)
+	case "quit", "exit":
+		return false
+	default:
+		err = fmt.Errorf("unknown command '%s', see `help`", cmd)
+	}
+	if err != nil {
+		r.printf("Error: %v\n", err)
+	}
+	return true
+}
+
+// redirectStdout prints the output of the client (logs, callbacks) through w, so that it doesn't break the prompt.
+// It is the only swap of os.Stdout: events of `-e -` were given the stdout of the process by NewTradeClient.
+func redirectStdout(w io.Writer) (restore func(), err error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(w, reader)
+		close(done)
+	}()
+	return func() {
+		os.Stdout = stdout
+		writer.Close()
+		<-done
+	}, nil
+}
+
+// RunOrderEntryManual is an interactive order entry shell, see replHelp.
+// Without a terminal on stdin commands are read line by line, e.g. from a file.
+func RunOrderEntryManual(cfgFileName string, apiKeyName string) error {
+	tapp, err := NewTradeClient(cfgFileName, apiKeyName)
+	if err != nil {
+		return err
+	}
+	targetCompID, _ := tapp.Settings.GlobalSettings().Setting(config.TargetCompID)
+	r := &repl{app: tapp, targetCompID: targetCompID, out: os.Stdout}
+
+	connect := func() error {
+		if err := StartConnection(replApp{tapp, r}, tapp.Settings); err != nil {
+			return err
+		}
+		if err := LoadInstruments(tapp, targetCompID); err != nil {
+			return err
+		}
+		r.printf("%d symbols, type `help` for commands\n", DefaultInstruments.Len())
+		return nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		if err = connect(); err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() && r.execute(scanner.Text()) {
+		}
+		return scanner.Err()
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, replPrompt)
+	terminal.AutoCompleteCallback = r.complete
+	r.out = terminal
+	restore, err := redirectStdout(terminal)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	if err = connect(); err != nil {
+		return err
+	}
+	for {
+		line, err := terminal.ReadLine()
+		if err == io.EOF { // Ctrl-D or Ctrl-C
+			return nil
+		}
+		if err != nil && err != term.ErrPasteIndicator {
+			return err
+		}
+		if !r.execute(line) {
+			return nil
+		}
+	}
+}
+
+// codeName is the name of a FIX code in names, else the code
+func codeName(names map[string]string, code string) string {
+	for name, c := range names {
+		if c == code {
+			return name
+		}
+	}
+	return code
+}
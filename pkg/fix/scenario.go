@@ -0,0 +1,198 @@
+package fix
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ScenarioAction_NEW_ORDER   = "new_order"   // NewOrderSingle
+	ScenarioAction_MULTILEG    = "multileg"    // NewOrderMultileg of legs
+	ScenarioAction_CANCEL      = "cancel"      // OrderCancelRequest of ref
+	ScenarioAction_REPLACE     = "replace"     // OrderCancelReplaceRequest of ref
+	ScenarioAction_STATUS      = "status"      // OrderStatusRequest of ref
+	ScenarioAction_MASS_CANCEL = "mass_cancel" // OrderMassCancelRequest of a symbol, else of all orders
+	ScenarioAction_SLEEP       = "sleep"       // waits for duration
+	ScenarioAction_LOOP        = "loop"        // runs steps count times
+
+	scenarioExpectTimeout = 5 * time.Second
+)
+
+var (
+	scenarioCmd = flag.String("sc", "", "Scenario: YAML or JSON file of order-flow steps")
+
+	scenarioSides = map[string]string{"buy": "1", "sell": "2"}
+	scenarioTIFs  = map[string]string{"day": "0", "gtc": "1", "ioc": "3", "fok": "4", "gtd": "6"}
+	scenarioTypes = map[string]string{"market": "1", "limit": "2"}
+
+	scenarioMsgTypes = map[string]string{
+		"reject":             "3",
+		"execution_report":   "8",
+		"cancel_reject":      "9",
+		"business_reject":    "j",
+		"mass_cancel_report": "r",
+	}
+	scenarioExecTypes = map[string]string{
+		"new":             "0",
+		"canceled":        "4",
+		"replaced":        "5",
+		"pending_cancel":  "6",
+		"rejected":        "8",
+		"pending_new":     "A",
+		"expired":         "C",
+		"pending_replace": "E",
+		"trade":           "F",
+		"order_status":    "I",
+	}
+)
+
+// Scenario is a script of order-flow steps, e.g. for QA of the venue
+type Scenario struct {
+	Name  string         `json:"name" yaml:"name"`
+	Steps []ScenarioStep `json:"steps" yaml:"steps"`
+}
+
+// ScenarioStep sends a message and waits for its expected responses. The ClOrdID of a step with an id is referred
+// to by later steps as ref, or as ${id} in field values.
+type ScenarioStep struct {
+	ID       string            `json:"id" yaml:"id"`
+	Action   string            `json:"action" yaml:"action"`
+	Ref      string            `json:"ref" yaml:"ref"` // id of the order to cancel, replace or query
+	Symbol   string            `json:"symbol" yaml:"symbol"`
+	Side     string            `json:"side" yaml:"side"` // buy/sell or a FIX code
+	Qty      decimal.Decimal   `json:"qty" yaml:"qty"`
+	Price    decimal.Decimal   `json:"price" yaml:"price"`
+	OrdType  string            `json:"ord_type" yaml:"ord_type"` // limit/market or a FIX code, default: limit with price
+	TIF      string            `json:"tif" yaml:"tif"`           // day/gtc/ioc/fok/gtd or a FIX code, default: gtc
+	ExecInst string            `json:"exec_inst" yaml:"exec_inst"`
+	Legs     []ScenarioLeg     `json:"legs" yaml:"legs"`
+	Fields   map[string]string `json:"fields" yaml:"fields"` // more fields by tag or name
+	Expect   []ScenarioExpect  `json:"expect" yaml:"expect"`
+
+	Duration string         `json:"duration" yaml:"duration"` // of sleep
+	Count    int            `json:"count" yaml:"count"`       // of loop
+	Steps    []ScenarioStep `json:"steps" yaml:"steps"`       // of loop
+
+	duration time.Duration
+}
+
+type ScenarioLeg struct {
+	Symbol string          `json:"symbol" yaml:"symbol"`
+	Ratio  decimal.Decimal `json:"ratio" yaml:"ratio"`
+}
+
+// ScenarioExpect waits for a response to the step, by ClOrdID, OrigClOrdID or BusinessRejectRefID, of a MsgType and
+// ExecType, then asserts its fields. Earlier responses of other types are skipped.
+type ScenarioExpect struct {
+	MsgType  string            `json:"msg_type" yaml:"msg_type"`   // a name or FIX code, default: execution_report
+	ExecType string            `json:"exec_type" yaml:"exec_type"` // a name or FIX code, default: any
+	Fields   map[string]string `json:"fields" yaml:"fields"`       // by tag or name, decimals are compared as numbers
+	Timeout  string            `json:"timeout" yaml:"timeout"`     // default 5s
+
+	timeout time.Duration
+}
+
+// scenarioCode maps a name to its FIX code, other values are codes
+func scenarioCode(names map[string]string, value string) string {
+	if code, found := names[strings.ToLower(value)]; found {
+		return code
+	}
+	return value
+}
+
+// LoadScenario reads a scenario, .json files as JSON and others as YAML
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario := &Scenario{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, scenario)
+	} else {
+		err = yaml.Unmarshal(data, scenario)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if scenario.Name == "" {
+		scenario.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err = validateScenarioSteps(scenario.Steps, make(map[string]*ScenarioStep), "steps"); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return scenario, nil
+}
+
+// validateScenarioSteps checks steps in order, ids are the steps declared so far
+func validateScenarioSteps(steps []ScenarioStep, ids map[string]*ScenarioStep, path string) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("%s: no steps", path)
+	}
+	for i := range steps {
+		step := &steps[i]
+		where := fmt.Sprintf("%s[%d] %s", path, i, step.Action)
+		if step.ID != "" && ids[step.ID] != nil {
+			return fmt.Errorf("%s: id '%s' is already declared", where, step.ID)
+		}
+
+		switch step.Action {
+		case ScenarioAction_NEW_ORDER:
+			if step.Symbol == "" || step.Side == "" || step.Qty.IsZero() {
+				return fmt.Errorf("%s: symbol, side and qty are required", where)
+			}
+		case ScenarioAction_MULTILEG:
+			if len(step.Legs) < 2 || step.Side == "" || step.Qty.IsZero() {
+				return fmt.Errorf("%s: 2+ legs, side and qty are required", where)
+			}
+			for j, leg := range step.Legs {
+				if leg.Symbol == "" || leg.Ratio.IsZero() {
+					return fmt.Errorf("%s: legs[%d]: symbol and a non-zero ratio are required", where, j)
+				}
+			}
+		case ScenarioAction_CANCEL, ScenarioAction_REPLACE, ScenarioAction_STATUS:
+			if ids[step.Ref] == nil {
+				return fmt.Errorf("%s: ref '%s' is not an id of an earlier step", where, step.Ref)
+			}
+		case ScenarioAction_MASS_CANCEL:
+		case ScenarioAction_SLEEP:
+			var err error
+			if step.duration, err = time.ParseDuration(step.Duration); err != nil {
+				return fmt.Errorf("%s: duration: %v", where, err)
+			}
+		case ScenarioAction_LOOP:
+			if step.Count <= 0 {
+				return fmt.Errorf("%s: count should be positive", where)
+			}
+			if err := validateScenarioSteps(step.Steps, ids, where+".steps"); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unknown action", where)
+		}
+
+		for j := range step.Expect {
+			expect := &step.Expect[j]
+			expect.timeout = scenarioExpectTimeout
+			if expect.Timeout != "" {
+				var err error
+				if expect.timeout, err = time.ParseDuration(expect.Timeout); err != nil {
+					return fmt.Errorf("%s: expect[%d]: timeout: %v", where, j, err)
+				}
+			}
+		}
+		if step.ID != "" {
+			ids[step.ID] = step
+		}
+	}
+	return nil
+}
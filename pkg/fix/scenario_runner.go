@@ -0,0 +1,447 @@
+package fix
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/newordersingle"
+	"github.com/quickfixgo/fix44/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix44/ordercancelrequest"
+	"github.com/quickfixgo/fix44/ordermasscancelrequest"
+	"github.com/quickfixgo/fix44/orderstatusrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+var scenarioRefRe = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// scenarioRunner sends steps and matches responses received since each step was sent
+type scenarioRunner struct {
+	app          *TradeClient
+	targetCompID string
+	clOrdIDs     map[string]string        // step id -> ClOrdID
+	orders       map[string]*ScenarioStep // step id -> the step of the order, for cancels of it
+
+	mu       sync.Mutex
+	received []*quickfix.Message
+	consumed []bool
+	notify   chan struct{} // closed on receipt
+}
+
+func newScenarioRunner(app *TradeClient, targetCompID string) *scenarioRunner {
+	return &scenarioRunner{
+		app:          app,
+		targetCompID: targetCompID,
+		clOrdIDs:     make(map[string]string),
+		orders:       make(map[string]*ScenarioStep),
+		notify:       make(chan struct{}),
+	}
+}
+
+func (r *scenarioRunner) receive(msg *quickfix.Message) {
+	received := quickfix.NewMessage()
+	msg.CopyInto(received)
+
+	r.mu.Lock()
+	r.received = append(r.received, received)
+	r.consumed = append(r.consumed, false)
+	close(r.notify)
+	r.notify = make(chan struct{})
+	r.mu.Unlock()
+}
+
+// mark is the index of the next received message
+func (r *scenarioRunner) mark() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.received)
+}
+
+// scenarioApp feeds the runner with rejects and application messages
+type scenarioApp struct {
+	*TradeClient
+	runner *scenarioRunner
+}
+
+func (a scenarioApp) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	if msgType, _ := msg.MsgType(); enum.MsgType(msgType) == enum.MsgType_REJECT {
+		a.runner.receive(msg)
+	}
+	return a.TradeClient.FromAdmin(msg, sessionID)
+}
+
+func (a scenarioApp) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.runner.receive(msg)
+	return a.TradeClient.FromApp(msg, sessionID)
+}
+
+// substitute replaces ${id} by the ClOrdID of the step
+func (r *scenarioRunner) substitute(value string) (string, error) {
+	var err error
+	res := scenarioRefRe.ReplaceAllStringFunc(value, func(ref string) string {
+		id := scenarioRefRe.FindStringSubmatch(ref)[1]
+		clOrdID, found := r.clOrdIDs[id]
+		if !found {
+			err = fmt.Errorf("unknown ref '%s'", id)
+		}
+		return clOrdID
+	})
+	return res, err
+}
+
+// scenarioTag resolves a tag number or a field name of the dictionary
+func scenarioTag(name string) (quickfix.Tag, error) {
+	if t, err := strconv.Atoi(name); err == nil {
+		return quickfix.Tag(t), nil
+	}
+	dict, err := AppDictionary()
+	if err != nil {
+		return 0, err
+	}
+	fieldType, found := dict.FieldTypeByName[name]
+	if !found {
+		return 0, fmt.Errorf("unknown field '%s'", name)
+	}
+	return quickfix.Tag(fieldType.Tag()), nil
+}
+
+func (r *scenarioRunner) Run(scenario *Scenario) error {
+	fmt.Printf("Scenario '%s'\n", scenario.Name)
+	err := r.runSteps(scenario.Steps)
+	if err != nil {
+		return fmt.Errorf("scenario '%s': %v", scenario.Name, err)
+	}
+	fmt.Printf("Scenario '%s': passed\n", scenario.Name)
+	return nil
+}
+
+func (r *scenarioRunner) runSteps(steps []ScenarioStep) error {
+	for i := range steps {
+		step := &steps[i]
+		switch step.Action {
+		case ScenarioAction_SLEEP:
+			time.Sleep(step.duration)
+
+		case ScenarioAction_LOOP:
+			for n := 0; n < step.Count; n++ {
+				fmt.Printf("  loop %d/%d\n", n+1, step.Count)
+				if err := r.runSteps(step.Steps); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := r.runStep(step); err != nil {
+				return fmt.Errorf("%s %s: %v", step.Action, step.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *scenarioRunner) runStep(step *ScenarioStep) error {
+	clOrdID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	msg, err := r.build(step, clOrdID)
+	if err != nil {
+		return err
+	}
+	for name, value := range step.Fields {
+		t, err := scenarioTag(name)
+		if err != nil {
+			return err
+		}
+		if value, err = r.substitute(value); err != nil {
+			return err
+		}
+		msg.Body.SetString(t, value)
+	}
+	if step.ID != "" {
+		r.clOrdIDs[step.ID] = clOrdID
+		if step.Action == ScenarioAction_NEW_ORDER || step.Action == ScenarioAction_MULTILEG || step.Action == ScenarioAction_REPLACE {
+			r.orders[step.ID] = step
+		}
+	}
+
+	msg.Header.Set(field.NewSenderCompID(r.app.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(r.targetCompID))
+	fmt.Printf("  %s %s ClOrdID=%s\n", step.Action, step.ID, clOrdID)
+
+	from := r.mark()
+	if err = Send(msg); err != nil {
+		return err
+	}
+
+	for i := range step.Expect {
+		if err = r.expect(from, clOrdID, &step.Expect[i]); err != nil {
+			return fmt.Errorf("expect[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// orderOf is the step of the order referred by ref, a replace inherits symbol, side, qty and price from its order
+func (r *scenarioRunner) orderOf(step *ScenarioStep) (symbol string, side string, qty decimal.Decimal, price decimal.Decimal) {
+	for order := r.orders[step.Ref]; order != nil; order = r.orders[order.Ref] {
+		symbol = firstNonEmpty(symbol, order.Symbol)
+		side = firstNonEmpty(side, order.Side)
+		if qty.IsZero() {
+			qty = order.Qty
+		}
+		if price.IsZero() {
+			price = order.Price
+		}
+		if order.Action != ScenarioAction_REPLACE {
+			break
+		}
+	}
+	return
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (r *scenarioRunner) build(step *ScenarioStep, clOrdID string) (*quickfix.Message, error) {
+	side := enum.Side(scenarioCode(scenarioSides, step.Side))
+	refClOrdID := r.clOrdIDs[step.Ref]
+	refSymbol, refSide, refQty, refPrice := r.orderOf(step)
+	if side == "" {
+		side = enum.Side(scenarioCode(scenarioSides, refSide))
+	}
+
+	switch step.Action {
+	case ScenarioAction_NEW_ORDER:
+		ordType := enum.OrdType(scenarioCode(scenarioTypes, firstNonEmpty(step.OrdType, "limit")))
+		if step.OrdType == "" && step.Price.IsZero() {
+			ordType = enum.OrdType_MARKET
+		}
+		order := newordersingle.New(
+			field.NewClOrdID(clOrdID),
+			field.NewSide(side),
+			field.NewTransactTime(time.Now()),
+			field.NewOrdType(ordType),
+		)
+		order.Set(field.NewSymbol(step.Symbol))
+		order.Set(field.NewOrderQty(step.Qty, scaleOf(step.Qty)))
+		if !step.Price.IsZero() {
+			order.Set(field.NewPrice(step.Price, scaleOf(step.Price)))
+		}
+		r.setTimeInForce(&order.Body.FieldMap, step)
+		if step.ExecInst != "" {
+			order.Set(field.NewExecInst(enum.ExecInst(step.ExecInst)))
+		}
+		return order.ToMessage(), nil
+
+	case ScenarioAction_MULTILEG:
+		legs := make([]StrategyLeg, 0, len(step.Legs))
+		for _, leg := range step.Legs {
+			legs = append(legs, StrategyLeg{Symbol: leg.Symbol, Ratio: leg.Ratio})
+		}
+		builder := NewStrategyBuilder(DefaultInstruments)
+		strategy, err := builder.Custom(legs...)
+		if err != nil {
+			return nil, err
+		}
+		// reports of the canonical form are mapped back by DefaultStrategyOrders
+		strategyOrder := builder.NewOrder(clOrdID, strategy, side, step.Qty, step.Price)
+		DefaultStrategyOrders.Add(strategyOrder)
+		order := strategyOrder.NewOrderMultileg()
+		r.setTimeInForce(&order.Body.FieldMap, step)
+		return order.ToMessage(), nil
+
+	case ScenarioAction_CANCEL:
+		cancel := ordercancelrequest.New(
+			field.NewOrigClOrdID(refClOrdID),
+			field.NewClOrdID(clOrdID),
+			field.NewSide(side),
+			field.NewTransactTime(time.Now()),
+		)
+		if symbol := firstNonEmpty(step.Symbol, refSymbol); symbol != "" {
+			cancel.Set(field.NewSymbol(symbol))
+		}
+		return cancel.ToMessage(), nil
+
+	case ScenarioAction_REPLACE:
+		qty, price := step.Qty, step.Price
+		if qty.IsZero() {
+			qty = refQty
+		}
+		if price.IsZero() {
+			price = refPrice
+		}
+		replace := ordercancelreplacerequest.New(
+			field.NewOrigClOrdID(refClOrdID),
+			field.NewClOrdID(clOrdID),
+			field.NewSide(side),
+			field.NewTransactTime(time.Now()),
+			field.NewOrdType(enum.OrdType(scenarioCode(scenarioTypes, firstNonEmpty(step.OrdType, "limit")))),
+		)
+		if symbol := firstNonEmpty(step.Symbol, refSymbol); symbol != "" {
+			replace.Set(field.NewSymbol(symbol))
+		}
+		replace.Set(field.NewOrderQty(qty, scaleOf(qty)))
+		replace.Set(field.NewPrice(price, scaleOf(price)))
+		return replace.ToMessage(), nil
+
+	case ScenarioAction_STATUS:
+		status := orderstatusrequest.New(field.NewClOrdID(refClOrdID), field.NewSide(side))
+		status.SetOrdStatusReqID(clOrdID)
+		if symbol := firstNonEmpty(step.Symbol, refSymbol); symbol != "" {
+			status.SetSymbol(symbol)
+		}
+		return status.ToMessage(), nil
+
+	case ScenarioAction_MASS_CANCEL:
+		requestType := enum.MassCancelRequestType_CANCEL_ALL_ORDERS
+		if step.Symbol != "" {
+			requestType = enum.MassCancelRequestType_CANCEL_ORDERS_FOR_A_SECURITY
+		}
+		massCancel := ordermasscancelrequest.New(
+			field.NewClOrdID(clOrdID),
+			field.NewMassCancelRequestType(requestType),
+			field.NewTransactTime(time.Now()),
+		)
+		if step.Symbol != "" {
+			massCancel.SetSymbol(step.Symbol)
+		}
+		return massCancel.ToMessage(), nil
+	}
+	return nil, fmt.Errorf("unknown action")
+}
+
+// setTimeInForce sets TIF, GTD orders expire in a day
+func (r *scenarioRunner) setTimeInForce(body *quickfix.FieldMap, step *ScenarioStep) {
+	tif := enum.TimeInForce(scenarioCode(scenarioTIFs, firstNonEmpty(step.TIF, "gtc")))
+	body.Set(field.NewTimeInForce(tif))
+	if tif == enum.TimeInForce_GOOD_TILL_DATE {
+		body.Set(field.NewExpireTime(time.Now().AddDate(0, 0, 1)))
+	}
+}
+
+// isResponseTo is true for messages referring to the ClOrdID and for messages without any reference
+func isResponseTo(msg *quickfix.Message, clOrdID string) bool {
+	hasRef := false
+	for _, t := range []quickfix.Tag{tag.ClOrdID, tag.OrigClOrdID, tag.BusinessRejectRefID} {
+		if value := getString(&msg.Body, t); value != "" {
+			hasRef = true
+			if value == clOrdID {
+				return true
+			}
+		}
+	}
+	return !hasRef
+}
+
+// expect waits for a response received since from and asserts its fields
+func (r *scenarioRunner) expect(from int, clOrdID string, expect *ScenarioExpect) error {
+	msgType := scenarioCode(scenarioMsgTypes, firstNonEmpty(expect.MsgType, "execution_report"))
+	execType := scenarioCode(scenarioExecTypes, expect.ExecType)
+	description := "MsgType " + msgType
+	if execType != "" {
+		description += " ExecType " + execType
+	}
+
+	deadline := time.After(expect.timeout)
+	for {
+		r.mu.Lock()
+		var response *quickfix.Message
+		for i := from; i < len(r.received) && response == nil; i++ {
+			msg := r.received[i]
+			if r.consumed[i] || !msg.IsMsgTypeOf(msgType) || !isResponseTo(msg, clOrdID) {
+				continue
+			}
+			if execType != "" && getString(&msg.Body, tag.ExecType) != execType {
+				continue
+			}
+			r.consumed[i] = true
+			response = msg
+		}
+		notify := r.notify
+		r.mu.Unlock()
+
+		if response != nil {
+			if err := r.assertFields(response, expect.Fields); err != nil {
+				return fmt.Errorf("%s: %v", description, err)
+			}
+			fmt.Printf("    got %s\n", description)
+			return nil
+		}
+
+		select {
+		case <-notify:
+		case <-deadline:
+			return fmt.Errorf("no %s in %v", description, expect.timeout)
+		}
+	}
+}
+
+func (r *scenarioRunner) assertFields(msg *quickfix.Message, fields map[string]string) error {
+	for name, expected := range fields {
+		t, err := scenarioTag(name)
+		if err != nil {
+			return err
+		}
+		if expected, err = r.substitute(expected); err != nil {
+			return err
+		}
+
+		actual, err := msg.Body.GetString(t)
+		if err != nil {
+			actual, err = msg.Header.GetString(t)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: missing, expected '%s'", name, expected)
+		}
+		if actual == expected {
+			continue
+		}
+		a, errA := decimal.NewFromString(actual)
+		e, errE := decimal.NewFromString(expected)
+		if errA != nil || errE != nil || !a.Equal(e) {
+			return fmt.Errorf("%s: '%s', expected '%s'", name, actual, expected)
+		}
+	}
+	return nil
+}
+
+// RunScenario runs the scenario of `-sc` on an order entry session
+func RunScenario(cfgFileName string, apiKeyName string) error {
+	if *scenarioCmd == "" {
+		return fmt.Errorf("scenario: -sc is required")
+	}
+	scenario, err := LoadScenario(*scenarioCmd)
+	if err != nil {
+		return err
+	}
+
+	tapp, err := NewTradeClient(cfgFileName, apiKeyName)
+	if err != nil {
+		return err
+	}
+	targetCompID, _ := tapp.Settings.GlobalSettings().Setting(config.TargetCompID)
+	runner := newScenarioRunner(tapp, targetCompID)
+
+	err = StartConnection(scenarioApp{tapp, runner}, tapp.Settings)
+	if err != nil {
+		return err
+	}
+	err = LoadInstruments(tapp, targetCompID)
+	if err != nil {
+		return err
+	}
+
+	return runner.Run(scenario)
+}
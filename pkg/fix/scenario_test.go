@@ -0,0 +1,152 @@
+package fix
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+func TestLoadScenarioBundled(t *testing.T) {
+	scenario, err := LoadScenario("../../spec/scenarios/order-entry.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.Name != "order-entry" || len(scenario.Steps) != 6 {
+		t.Fatalf("%s with %d steps", scenario.Name, len(scenario.Steps))
+	}
+	loop := scenario.Steps[3]
+	if loop.Action != ScenarioAction_LOOP || loop.Count != 3 || loop.Steps[3].duration != 500*time.Millisecond {
+		t.Errorf("loop %+v", loop)
+	}
+	if timeout := scenario.Steps[1].Expect[1].timeout; timeout != 2*time.Second {
+		t.Errorf("expect timeout %v, want 2s", timeout)
+	}
+	if timeout := scenario.Steps[0].Expect[0].timeout; timeout != scenarioExpectTimeout {
+		t.Errorf("default expect timeout %v", timeout)
+	}
+}
+
+func TestLoadScenarioErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+		err   string
+	}{
+		{"no steps", "steps: []", "steps: no steps"},
+		{"unknown action", "steps:\n  - action: fly", "unknown action"},
+		{"bad ref", "steps:\n  - action: cancel\n    ref: missing", "ref 'missing' is not an id of an earlier step"},
+		{"ref of a later step", "steps:\n  - action: cancel\n    ref: o\n  - id: o\n    action: new_order\n    symbol: BTC-USD\n    side: buy\n    qty: 1", "ref 'o'"},
+		{"zero loop count", "steps:\n  - action: loop\n    count: 0\n    steps:\n      - action: sleep\n        duration: 1s", "count should be positive"},
+		{"bad duration", "steps:\n  - action: sleep\n    duration: soon", "duration:"},
+		{"bad duration in a loop", "steps:\n  - action: loop\n    count: 1\n    steps:\n      - action: sleep\n        duration: 1", "steps[0] loop.steps[0] sleep: duration:"},
+		{"bad expect timeout", "steps:\n  - action: mass_cancel\n    expect:\n      - msg_type: mass_cancel_report\n        timeout: x", "expect[0]: timeout:"},
+		{"missing qty", "steps:\n  - action: new_order\n    symbol: BTC-USD\n    side: buy", "symbol, side and qty are required"},
+		{"duplicate id", "steps:\n  - id: o\n    action: sleep\n    duration: 1s\n  - id: o\n    action: sleep\n    duration: 1s", "id 'o' is already declared"},
+		{"zero leg ratio", "steps:\n  - action: multileg\n    side: buy\n    qty: 1\n    legs:\n      - symbol: BTC-USD\n        ratio: 1\n      - symbol: ETH-USD\n        ratio: 0", "legs[1]: symbol and a non-zero ratio are required"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.yaml")
+			if err := os.WriteFile(path, []byte(test.steps), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadScenario(path)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestScenarioSubstitute(t *testing.T) {
+	r := newScenarioRunner(nil, "PT")
+	r.clOrdIDs["gtd"] = "1001"
+	r.clOrdIDs["order"] = "1002"
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"plain", "plain", false},
+		{"${gtd}", "1001", false},
+		{"${gtd}/${order}", "1001/1002", false},
+		{"${missing}", "", true},
+	}
+	for _, test := range tests {
+		got, err := r.substitute(test.value)
+		if (err != nil) != test.err || (!test.err && got != test.want) {
+			t.Errorf("substitute(%q) = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestScenarioAssertFields(t *testing.T) {
+	r := newScenarioRunner(nil, "PT")
+	r.clOrdIDs["gtd"] = "1001"
+
+	msg := quickfix.NewMessage()
+	msg.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
+	msg.Header.Set(field.NewTargetCompID("CLIENT"))
+	msg.Body.Set(field.NewOrigClOrdID("1001"))
+	msg.Body.Set(field.NewOrdStatus(enum.OrdStatus_NEW))
+	msg.Body.Set(field.NewPrice(decimal.RequireFromString("0.310"), 3))
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		err    bool
+	}{
+		{"equal strings", map[string]string{"OrdStatus": "0"}, false},
+		{"by tag", map[string]string{"39": "0"}, false},
+		{"decimal with trailing zero", map[string]string{"Price": "0.31"}, false},
+		{"different decimal", map[string]string{"Price": "0.32"}, true},
+		{"different string", map[string]string{"OrdStatus": "4"}, true},
+		{"header field", map[string]string{"TargetCompID": "CLIENT"}, false},
+		{"ref", map[string]string{"OrigClOrdID": "${gtd}"}, false},
+		{"unknown ref", map[string]string{"OrigClOrdID": "${missing}"}, true},
+		{"missing field", map[string]string{"ClOrdID": "1001"}, true},
+		{"unknown field name", map[string]string{"NoSuchField": "1"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := r.assertFields(msg, test.fields); (err != nil) != test.err {
+				t.Errorf("assertFields %v: %v", test.fields, err)
+			}
+		})
+	}
+}
+
+func TestScenarioIsResponseTo(t *testing.T) {
+	msg := func(fields ...quickfix.FieldWriter) *quickfix.Message {
+		m := quickfix.NewMessage()
+		for _, f := range fields {
+			m.Body.Set(f)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name string
+		msg  *quickfix.Message
+		want bool
+	}{
+		{"ClOrdID", msg(field.NewClOrdID("1001")), true},
+		{"OrigClOrdID", msg(field.NewClOrdID("1002"), field.NewOrigClOrdID("1001")), true},
+		{"BusinessRejectRefID", msg(field.NewBusinessRejectRefID("1001")), true},
+		{"other order", msg(field.NewClOrdID("1002")), false},
+		{"no ref", msg(field.NewText("session reject")), true},
+	}
+	for _, test := range tests {
+		if got := isResponseTo(test.msg, "1001"); got != test.want {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -13,10 +13,14 @@ import (
 	"github.com/quickfixgo/quickfix/config"
 )
 
+const (
+	instrumentsTimeout = 10 * time.Second
+)
+
 var (
-	possibleActionsSL = []FIXExampleAction{
-		securityListRequest,
-		securityDefinitionRequest,
+	possibleActionsSL = []FIXNamedAction{
+		{"securityListRequest", securityListRequest},
+		{"securityDefinitionRequest", securityDefinitionRequest},
 	}
 )
 
@@ -44,6 +48,23 @@ func securityDefinitionRequest() *quickfix.Message {
 	return order.ToMessage()
 }
 
+// LoadInstruments requests all securities and waits for DefaultInstruments to be filled
+func LoadInstruments(app *TradeClient, targetCompID string) error {
+	msg := securityListRequest()
+	msg.Header.Set(field.NewSenderCompID(app.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(targetCompID))
+
+	err := Send(msg)
+	if err != nil {
+		return err
+	}
+
+	if !DefaultInstruments.WaitLoaded(instrumentsTimeout) {
+		fmt.Printf("SecurityList: no response in %v, %d instruments known\n", instrumentsTimeout, DefaultInstruments.Len())
+	}
+	return nil
+}
+
 func RunSecurityList(cfgFileName string, apiKeyName string) error {
 	app, err := NewTradeClient(cfgFileName, apiKeyName)
 	if err != nil {
@@ -56,7 +77,10 @@ func RunSecurityList(cfgFileName string, apiKeyName string) error {
 	}
 	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
 
-	actions := getActions(possibleActionsSL)
+	actions, err := getActions(possibleActionsSL)
+	if err != nil {
+		return err
+	}
 	for _, action := range actions {
 		time.Sleep(time.Second)
 
@@ -66,7 +90,7 @@ func RunSecurityList(cfgFileName string, apiKeyName string) error {
 
 		fmt.Printf("Sending: %s\n", msg.String())
 
-		err := quickfix.Send(msg)
+		err := Send(msg)
 
 		if err != nil {
 			return err
@@ -0,0 +1,537 @@
+package fix
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/newordermultileg"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+type StrategyType int
+
+const (
+	StrategyType_CUSTOM    StrategyType = 0
+	StrategyType_SPREAD    StrategyType = 1
+	StrategyType_STRADDLE  StrategyType = 2
+	StrategyType_STRANGLE  StrategyType = 3
+	StrategyType_BUTTERFLY StrategyType = 4
+	StrategyType_CALENDAR  StrategyType = 5
+)
+
+var (
+	DefaultStrategyOrders = NewStrategyOrders()
+)
+
+func (t StrategyType) String() string {
+	switch t {
+	case StrategyType_SPREAD:
+		return "SPREAD"
+	case StrategyType_STRADDLE:
+		return "STRADDLE"
+	case StrategyType_STRANGLE:
+		return "STRANGLE"
+	case StrategyType_BUTTERFLY:
+		return "BUTTERFLY"
+	case StrategyType_CALENDAR:
+		return "CALENDAR"
+	default:
+		return "CUSTOM"
+	}
+}
+
+// StrategyLeg has a Ratio signed from the buyer's point of view: > 0 the leg is bought, < 0 it is sold
+type StrategyLeg struct {
+	Symbol string
+	Ratio  decimal.Decimal
+}
+
+type Strategy struct {
+	Type StrategyType
+	Legs []StrategyLeg
+}
+
+func (s *Strategy) String() string {
+	legs := make([]string, 0, len(s.Legs))
+	for _, leg := range s.Legs {
+		legs = append(legs, fmt.Sprintf("%s x %s", leg.Ratio, leg.Symbol))
+	}
+	return fmt.Sprintf("%s[%s]", s.Type, strings.Join(legs, ", "))
+}
+
+// StrategyBuilder validates strategy legs against the instrument cache
+type StrategyBuilder struct {
+	instruments *Instruments
+}
+
+func NewStrategyBuilder(instruments *Instruments) *StrategyBuilder {
+	return &StrategyBuilder{instruments: instruments}
+}
+
+func (b *StrategyBuilder) instrument(symbol string) (*Instrument, error) {
+	instrument, found := b.instruments.Get(symbol)
+	if !found {
+		return nil, fmt.Errorf("unknown instrument '%s'", symbol)
+	}
+	return instrument, nil
+}
+
+func (b *StrategyBuilder) options(symbols ...string) ([]*Instrument, error) {
+	options := make([]*Instrument, 0, len(symbols))
+	for _, symbol := range symbols {
+		instrument, err := b.instrument(symbol)
+		if err != nil {
+			return nil, err
+		}
+		if !instrument.IsOption() {
+			return nil, fmt.Errorf("'%s' is not an option", symbol)
+		}
+		if len(options) > 0 {
+			if instrument.Underlying != options[0].Underlying {
+				return nil, fmt.Errorf("'%s' and '%s' have different underlyings", options[0].Symbol, symbol)
+			}
+			if instrument.MaturityDate != options[0].MaturityDate {
+				return nil, fmt.Errorf("'%s' and '%s' have different expiries", options[0].Symbol, symbol)
+			}
+		}
+		options = append(options, instrument)
+	}
+	return options, nil
+}
+
+// Custom builds a strategy of arbitrary legs
+func (b *StrategyBuilder) Custom(legs ...StrategyLeg) (*Strategy, error) {
+	return b.build(StrategyType_CUSTOM, legs)
+}
+
+// Spread buys one instrument and sells another
+func (b *StrategyBuilder) Spread(long string, short string) (*Strategy, error) {
+	return b.build(StrategyType_SPREAD, []StrategyLeg{
+		{Symbol: long, Ratio: decimal.NewFromInt(1)},
+		{Symbol: short, Ratio: decimal.NewFromInt(-1)},
+	})
+}
+
+// Calendar sells the near expiry and buys the far one of the same underlying (and strike / type for options)
+func (b *StrategyBuilder) Calendar(near string, far string) (*Strategy, error) {
+	nearInstr, err := b.instrument(near)
+	if err != nil {
+		return nil, err
+	}
+	farInstr, err := b.instrument(far)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case nearInstr.Underlying != farInstr.Underlying:
+		return nil, fmt.Errorf("calendar: '%s' and '%s' have different underlyings", near, far)
+	case nearInstr.SecurityType != farInstr.SecurityType:
+		return nil, fmt.Errorf("calendar: '%s' and '%s' have different security types", near, far)
+	case nearInstr.PutOrCall != farInstr.PutOrCall || !nearInstr.StrikePrice.Equal(farInstr.StrikePrice):
+		return nil, fmt.Errorf("calendar: '%s' and '%s' have different strikes", near, far)
+	case nearInstr.MaturityDate >= farInstr.MaturityDate:
+		return nil, fmt.Errorf("calendar: '%s' doesn't expire before '%s'", near, far)
+	}
+
+	return b.build(StrategyType_CALENDAR, []StrategyLeg{
+		{Symbol: near, Ratio: decimal.NewFromInt(-1)},
+		{Symbol: far, Ratio: decimal.NewFromInt(1)},
+	})
+}
+
+// Straddle buys a call and a put of the same strike and expiry
+func (b *StrategyBuilder) Straddle(call string, put string) (*Strategy, error) {
+	options, err := b.options(call, put)
+	if err != nil {
+		return nil, fmt.Errorf("straddle: %v", err)
+	}
+	if !options[0].IsCall() || !options[1].IsPut() {
+		return nil, fmt.Errorf("straddle: needs a call and a put")
+	}
+	if !options[0].StrikePrice.Equal(options[1].StrikePrice) {
+		return nil, fmt.Errorf("straddle: '%s' and '%s' have different strikes", call, put)
+	}
+
+	return b.build(StrategyType_STRADDLE, []StrategyLeg{
+		{Symbol: call, Ratio: decimal.NewFromInt(1)},
+		{Symbol: put, Ratio: decimal.NewFromInt(1)},
+	})
+}
+
+// Strangle buys an OTM put and an OTM call of the same expiry
+func (b *StrategyBuilder) Strangle(put string, call string) (*Strategy, error) {
+	options, err := b.options(put, call)
+	if err != nil {
+		return nil, fmt.Errorf("strangle: %v", err)
+	}
+	if !options[0].IsPut() || !options[1].IsCall() {
+		return nil, fmt.Errorf("strangle: needs a put and a call")
+	}
+	if !options[0].StrikePrice.LessThan(options[1].StrikePrice) {
+		return nil, fmt.Errorf("strangle: put strike should be below call strike")
+	}
+
+	return b.build(StrategyType_STRANGLE, []StrategyLeg{
+		{Symbol: put, Ratio: decimal.NewFromInt(1)},
+		{Symbol: call, Ratio: decimal.NewFromInt(1)},
+	})
+}
+
+// Butterfly buys the wings and sells twice the body, strikes must be equidistant
+func (b *StrategyBuilder) Butterfly(low string, mid string, high string) (*Strategy, error) {
+	options, err := b.options(low, mid, high)
+	if err != nil {
+		return nil, fmt.Errorf("butterfly: %v", err)
+	}
+	if options[0].PutOrCall != options[1].PutOrCall || options[1].PutOrCall != options[2].PutOrCall {
+		return nil, fmt.Errorf("butterfly: legs should be all calls or all puts")
+	}
+	lowWing := options[1].StrikePrice.Sub(options[0].StrikePrice)
+	highWing := options[2].StrikePrice.Sub(options[1].StrikePrice)
+	if !lowWing.IsPositive() || !lowWing.Equal(highWing) {
+		return nil, fmt.Errorf("butterfly: strikes should be increasing and equidistant")
+	}
+
+	return b.build(StrategyType_BUTTERFLY, []StrategyLeg{
+		{Symbol: low, Ratio: decimal.NewFromInt(1)},
+		{Symbol: mid, Ratio: decimal.NewFromInt(-2)},
+		{Symbol: high, Ratio: decimal.NewFromInt(1)},
+	})
+}
+
+func (b *StrategyBuilder) build(strategyType StrategyType, legs []StrategyLeg) (*Strategy, error) {
+	if len(legs) < 2 {
+		return nil, fmt.Errorf("%s: at least 2 legs expected", strategyType)
+	}
+
+	seen := make(map[string]bool)
+	for _, leg := range legs {
+		if _, err := b.instrument(leg.Symbol); err != nil {
+			return nil, fmt.Errorf("%s: %v", strategyType, err)
+		}
+		if leg.Ratio.IsZero() {
+			return nil, fmt.Errorf("%s: zero ratio of '%s'", strategyType, leg.Symbol)
+		}
+		if seen[leg.Symbol] {
+			return nil, fmt.Errorf("%s: duplicated leg '%s'", strategyType, leg.Symbol)
+		}
+		seen[leg.Symbol] = true
+	}
+
+	return &Strategy{Type: strategyType, Legs: legs}, nil
+}
+
+// CanonicalStrategy is a strategy order the way the venue reports it back: legs sorted by expiry, strike,
+// put before call and symbol, ratios divided by their GCD and the 1st leg's ratio positive (side is reverted otherwise)
+type CanonicalStrategy struct {
+	Legs  []StrategyLeg
+	Side  enum.Side
+	Qty   decimal.Decimal
+	Price decimal.Decimal
+
+	legIndex []int // canonical leg -> submitted leg
+	reverted bool
+	scale    decimal.Decimal // canonical ratio = submitted ratio / scale
+}
+
+// Canonicalize sorts, reduces and signs legs the way the venue does
+func (b *StrategyBuilder) Canonicalize(s *Strategy, side enum.Side, qty decimal.Decimal, price decimal.Decimal) CanonicalStrategy {
+	legIndex := make([]int, len(s.Legs))
+	for i := range legIndex {
+		legIndex[i] = i
+	}
+
+	instruments := make([]*Instrument, len(s.Legs))
+	for i, leg := range s.Legs {
+		instrument, found := b.instruments.Get(leg.Symbol)
+		if !found {
+			instrument = &Instrument{Symbol: leg.Symbol}
+		}
+		instruments[i] = instrument
+	}
+
+	sort.SliceStable(legIndex, func(i, j int) bool {
+		a, b := instruments[legIndex[i]], instruments[legIndex[j]]
+		if a.MaturityDate != b.MaturityDate {
+			return a.MaturityDate < b.MaturityDate
+		}
+		if !a.StrikePrice.Equal(b.StrikePrice) {
+			return a.StrikePrice.LessThan(b.StrikePrice)
+		}
+		if a.PutOrCall != b.PutOrCall {
+			return a.PutOrCall < b.PutOrCall
+		}
+		return a.Symbol < b.Symbol
+	})
+
+	scale := ratioGCD(s.Legs)
+	reverted := s.Legs[legIndex[0]].Ratio.IsNegative()
+
+	canonical := CanonicalStrategy{
+		Legs:     make([]StrategyLeg, len(s.Legs)),
+		Side:     side,
+		Qty:      qty.Mul(scale),
+		Price:    price.Div(scale),
+		legIndex: legIndex,
+		reverted: reverted,
+		scale:    scale,
+	}
+	for i, idx := range legIndex {
+		ratio := s.Legs[idx].Ratio.Div(scale)
+		if reverted {
+			ratio = ratio.Neg()
+		}
+		canonical.Legs[i] = StrategyLeg{Symbol: s.Legs[idx].Symbol, Ratio: ratio}
+	}
+	if reverted {
+		canonical.Side = oppositeSide(side)
+		canonical.Price = canonical.Price.Neg()
+	}
+	return canonical
+}
+
+// ratioGCD is the greatest common divisor of integer ratios, 1 if any ratio is fractional
+func ratioGCD(legs []StrategyLeg) decimal.Decimal {
+	gcd := int64(0)
+	for _, leg := range legs {
+		if !leg.Ratio.IsInteger() {
+			return decimal.NewFromInt(1)
+		}
+		a, b := gcd, leg.Ratio.Abs().IntPart()
+		for b != 0 {
+			a, b = b, a%b
+		}
+		gcd = a
+	}
+	if gcd == 0 {
+		return decimal.NewFromInt(1)
+	}
+	return decimal.NewFromInt(gcd)
+}
+
+func oppositeSide(side enum.Side) enum.Side {
+	if side == enum.Side_BUY {
+		return enum.Side_SELL
+	}
+	return enum.Side_BUY
+}
+
+// StrategyOrder is a strategy submitted as NewOrderMultileg in its canonical form
+type StrategyOrder struct {
+	ClOrdID   string
+	Strategy  *Strategy
+	Side      enum.Side
+	Qty       decimal.Decimal
+	Price     decimal.Decimal
+	Canonical CanonicalStrategy
+
+	LegCumQty []decimal.Decimal // per submitted leg
+
+	execIDs map[string]bool // reports seen, resent ones are ignored
+}
+
+func (b *StrategyBuilder) NewOrder(clOrdID string, s *Strategy, side enum.Side, qty decimal.Decimal, price decimal.Decimal) *StrategyOrder {
+	return &StrategyOrder{
+		ClOrdID:   clOrdID,
+		Strategy:  s,
+		Side:      side,
+		Qty:       qty,
+		Price:     price,
+		Canonical: b.Canonicalize(s, side, qty, price),
+		LegCumQty: make([]decimal.Decimal, len(s.Legs)),
+		execIDs:   make(map[string]bool),
+	}
+}
+
+// NewOrderMultileg builds the message of the canonical strategy, TimeInForce etc. are up to the caller
+func (o *StrategyOrder) NewOrderMultileg() newordermultileg.NewOrderMultileg {
+	order := newordermultileg.New(
+		field.NewClOrdID(o.ClOrdID),
+		field.NewSide(o.Canonical.Side),
+		field.NewTransactTime(time.Now()),
+		field.NewOrdType(enum.OrdType_LIMIT),
+	)
+
+	legs := newordermultileg.NewNoLegsRepeatingGroup()
+	for _, leg := range o.Canonical.Legs {
+		l := legs.Add()
+		l.Set(field.NewLegSymbol(leg.Symbol))
+		l.Set(field.NewLegRatioQty(leg.Ratio, 0))
+	}
+	order.SetGroup(legs)
+
+	order.Set(field.NewOrderQty(o.Canonical.Qty, 4))
+	order.Set(field.NewPrice(o.Canonical.Price, 4))
+	return order
+}
+
+// toSubmittedQty converts a canonical quantity back to units of the submitted strategy
+func (o *StrategyOrder) toSubmittedQty(qty decimal.Decimal) decimal.Decimal {
+	return qty.Div(o.Canonical.scale)
+}
+
+// toSubmittedPx converts a canonical price back to the submitted strategy
+func (o *StrategyOrder) toSubmittedPx(px decimal.Decimal) decimal.Decimal {
+	px = px.Mul(o.Canonical.scale)
+	if o.Canonical.reverted {
+		px = px.Neg()
+	}
+	return px
+}
+
+func (o *StrategyOrder) legBySymbol(symbol string) int {
+	for i, leg := range o.Strategy.Legs {
+		if leg.Symbol == symbol {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *StrategyOrder) legSide(leg int) enum.Side {
+	if o.Strategy.Legs[leg].Ratio.IsPositive() == (o.Side == enum.Side_BUY) {
+		return enum.Side_BUY
+	}
+	return enum.Side_SELL
+}
+
+type StrategyLegFill struct {
+	Leg    int // index in Strategy.Legs
+	Symbol string
+	Side   enum.Side
+	Qty    decimal.Decimal
+	Price  decimal.Decimal
+}
+
+// StrategyExecution is an ExecutionReport expressed in terms of the submitted strategy
+type StrategyExecution struct {
+	Order     *StrategyOrder
+	ExecID    string
+	ExecType  enum.ExecType
+	OrdStatus enum.OrdStatus
+	LastQty   decimal.Decimal
+	LastPx    decimal.Decimal
+	CumQty    decimal.Decimal
+	LeavesQty decimal.Decimal
+	LegFills  []StrategyLegFill
+}
+
+func (e *StrategyExecution) String() string {
+	res := fmt.Sprintf("Strategy[%s] %s %s %s ExecType=%s OrdStatus=%s Last=%s@%s Cum=%s Leaves=%s",
+		e.Order.ClOrdID, e.Order.Strategy, e.Order.Side, e.Order.Qty,
+		e.ExecType, e.OrdStatus, e.LastQty, e.LastPx, e.CumQty, e.LeavesQty,
+	)
+	for _, fill := range e.LegFills {
+		res += fmt.Sprintf("\n\tLeg#%d %s %s %s@%s", fill.Leg, fill.Symbol, fill.Side, fill.Qty, fill.Price)
+	}
+	return res
+}
+
+// StrategyOrders maps ExecutionReports back onto submitted strategies
+type StrategyOrders struct {
+	mu     sync.Mutex
+	orders map[string]*StrategyOrder // ClOrdID -> order
+}
+
+func NewStrategyOrders() *StrategyOrders {
+	return &StrategyOrders{
+		orders: make(map[string]*StrategyOrder),
+	}
+}
+
+func (o *StrategyOrders) Add(order *StrategyOrder) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.orders[order.ClOrdID] = order
+}
+
+func (o *StrategyOrders) Get(clOrdID string) *StrategyOrder {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.orders[clOrdID]
+}
+
+// FromExecutionReport returns false if the report doesn't belong to a known strategy order or repeats an ExecID (resend, PossDup)
+func (o *StrategyOrders) FromExecutionReport(msg *quickfix.Message) (*StrategyExecution, bool) {
+	msgType, _ := msg.MsgType()
+	if enum.MsgType(msgType) != enum.MsgType_EXECUTION_REPORT {
+		return nil, false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	order := o.orders[getString(msg.Body, tag.ClOrdID)]
+	if order == nil {
+		order = o.orders[getString(msg.Body, tag.OrigClOrdID)]
+	}
+	if order == nil {
+		return nil, false
+	}
+	if execID := getString(msg.Body, tag.ExecID); execID != "" {
+		if order.execIDs[execID] {
+			return nil, false
+		}
+		order.execIDs[execID] = true
+	}
+
+	canonicalLastQty := getDecimal(msg.Body, tag.LastQty)
+	exec := &StrategyExecution{
+		Order:     order,
+		ExecID:    getString(msg.Body, tag.ExecID),
+		ExecType:  enum.ExecType(getString(msg.Body, tag.ExecType)),
+		OrdStatus: enum.OrdStatus(getString(msg.Body, tag.OrdStatus)),
+		LastQty:   order.toSubmittedQty(canonicalLastQty),
+		LastPx:    order.toSubmittedPx(getDecimal(msg.Body, tag.LastPx)),
+		CumQty:    order.toSubmittedQty(getDecimal(msg.Body, tag.CumQty)),
+		LeavesQty: order.toSubmittedQty(getDecimal(msg.Body, tag.LeavesQty)),
+	}
+
+	// A fill of an individual leg is reported with the leg's Symbol
+	if enum.MultiLegReportingType(getString(msg.Body, tag.MultiLegReportingType)) == enum.MultiLegReportingType_INDIVIDUAL_LEG_OF_A_MULTI_LEG_SECURITY {
+		exec.LastQty = decimal.Zero
+		exec.LastPx = decimal.Zero
+		if leg := order.legBySymbol(getString(msg.Body, tag.Symbol)); leg >= 0 && canonicalLastQty.IsPositive() {
+			exec.LegFills = append(exec.LegFills, order.legFill(leg, canonicalLastQty, getDecimal(msg.Body, tag.LastPx)))
+		}
+		return exec, true
+	}
+
+	if !canonicalLastQty.IsPositive() {
+		return exec, true
+	}
+
+	legs, err := GetMessageGroup(msg, tag.NoLegs)
+	if err != nil {
+		return exec, true
+	}
+	for i := 0; i < legs.Len(); i++ {
+		g := legs.Get(i)
+		leg := order.legBySymbol(getString(g, tag.LegSymbol))
+		if leg < 0 {
+			continue
+		}
+		ratio := order.Strategy.Legs[leg].Ratio.Div(order.Canonical.scale).Abs()
+		exec.LegFills = append(exec.LegFills, order.legFill(leg, canonicalLastQty.Mul(ratio), getDecimal(g, tag.LegLastPx)))
+	}
+	return exec, true
+}
+
+func (o *StrategyOrder) legFill(leg int, qty decimal.Decimal, px decimal.Decimal) StrategyLegFill {
+	o.LegCumQty[leg] = o.LegCumQty[leg].Add(qty)
+	return StrategyLegFill{
+		Leg:    leg,
+		Symbol: o.Strategy.Legs[leg].Symbol,
+		Side:   o.legSide(leg),
+		Qty:    qty,
+		Price:  px,
+	}
+}
@@ -0,0 +1,164 @@
+package fix
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+func newTestStrategyBuilder() *StrategyBuilder {
+	instruments := NewInstruments()
+	for _, instrument := range []*Instrument{
+		{Symbol: "BTC-20240329-50000C", Underlying: "BTC", PutOrCall: enum.PutOrCall_CALL, StrikePrice: decimal.NewFromInt(50000), MaturityDate: "20240329"},
+		{Symbol: "BTC-20240329-60000C", Underlying: "BTC", PutOrCall: enum.PutOrCall_CALL, StrikePrice: decimal.NewFromInt(60000), MaturityDate: "20240329"},
+		{Symbol: "BTC-20240628-50000C", Underlying: "BTC", PutOrCall: enum.PutOrCall_CALL, StrikePrice: decimal.NewFromInt(50000), MaturityDate: "20240628"},
+	} {
+		instruments.Add(instrument)
+	}
+	return NewStrategyBuilder(instruments)
+}
+
+func TestCanonicalize(t *testing.T) {
+	d := decimal.RequireFromString
+	leg := func(symbol string, ratio int64) StrategyLeg {
+		return StrategyLeg{Symbol: symbol, Ratio: decimal.NewFromInt(ratio)}
+	}
+
+	tests := []struct {
+		name  string
+		legs  []StrategyLeg
+		side  enum.Side
+		want  []StrategyLeg
+		wSide enum.Side
+		wQty  string
+		wPx   string
+	}{
+		{
+			"ratios reduced by their GCD",
+			[]StrategyLeg{leg("BTC-20240329-50000C", 2), leg("BTC-20240329-60000C", -4)},
+			enum.Side_BUY,
+			[]StrategyLeg{leg("BTC-20240329-50000C", 1), leg("BTC-20240329-60000C", -2)},
+			enum.Side_BUY, "20", "50",
+		},
+		{
+			"negative first leg reverses side and price",
+			[]StrategyLeg{leg("BTC-20240329-50000C", -1), leg("BTC-20240329-60000C", 1)},
+			enum.Side_BUY,
+			[]StrategyLeg{leg("BTC-20240329-50000C", 1), leg("BTC-20240329-60000C", -1)},
+			enum.Side_SELL, "10", "-100",
+		},
+		{
+			"legs sorted by expiry",
+			[]StrategyLeg{leg("BTC-20240628-50000C", 1), leg("BTC-20240329-50000C", -1)},
+			enum.Side_SELL,
+			[]StrategyLeg{leg("BTC-20240329-50000C", 1), leg("BTC-20240628-50000C", -1)},
+			enum.Side_BUY, "10", "-100",
+		},
+		{
+			"legs sorted by strike",
+			[]StrategyLeg{leg("BTC-20240329-60000C", 1), leg("BTC-20240329-50000C", 1)},
+			enum.Side_BUY,
+			[]StrategyLeg{leg("BTC-20240329-50000C", 1), leg("BTC-20240329-60000C", 1)},
+			enum.Side_BUY, "10", "100",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestStrategyBuilder().Canonicalize(&Strategy{Legs: test.legs}, test.side, d("10"), d("100"))
+			if len(c.Legs) != len(test.want) {
+				t.Fatalf("legs %v, want %v", c.Legs, test.want)
+			}
+			for i := range c.Legs {
+				if c.Legs[i].Symbol != test.want[i].Symbol || !c.Legs[i].Ratio.Equal(test.want[i].Ratio) {
+					t.Errorf("legs %v, want %v", c.Legs, test.want)
+					break
+				}
+			}
+			if c.Side != test.wSide || !c.Qty.Equal(d(test.wQty)) || !c.Price.Equal(d(test.wPx)) {
+				t.Errorf("%s %s @ %s, want %s %s @ %s", c.Side, c.Qty, c.Price, test.wSide, test.wQty, test.wPx)
+			}
+		})
+	}
+}
+
+// newTestStrategyReport is a fill of the canonical strategy with a leg group in the venue's order
+func newTestStrategyReport(t *testing.T, clOrdID string, execID string, lastQty string, lastPx string, legPx map[string]string, legs ...string) *quickfix.Message {
+	d := decimal.RequireFromString
+	report := quickfix.NewMessage()
+	report.Header.Set(field.NewBeginString(quickfix.BeginStringFIX44))
+	report.Header.Set(field.NewMsgType(enum.MsgType_EXECUTION_REPORT))
+	report.Body.Set(field.NewClOrdID(clOrdID))
+	report.Body.Set(field.NewExecID(execID))
+	report.Body.Set(field.NewExecType(enum.ExecType_TRADE))
+	report.Body.Set(field.NewLastQty(d(lastQty), 4))
+	report.Body.Set(field.NewLastPx(d(lastPx), 4))
+
+	group := quickfix.NewRepeatingGroup(tag.NoLegs, quickfix.GroupTemplate{quickfix.GroupElement(tag.LegSymbol), quickfix.GroupElement(tag.LegLastPx)})
+	for _, symbol := range legs {
+		g := group.Add()
+		g.Set(field.NewLegSymbol(symbol))
+		g.Set(field.NewLegLastPx(d(legPx[symbol]), 4))
+	}
+	report.Body.SetGroup(group)
+
+	msg := quickfix.NewMessage()
+	if err := quickfix.ParseMessage(msg, bytes.NewBufferString(report.String())); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestStrategyOrdersFromExecutionReport(t *testing.T) {
+	d := decimal.RequireFromString
+	b := newTestStrategyBuilder()
+	// submitted far leg first and 2:-2, the venue has near first, 1:-1 and the side reversed
+	strategy := &Strategy{Type: StrategyType_CALENDAR, Legs: []StrategyLeg{
+		{Symbol: "BTC-20240628-50000C", Ratio: d("-2")},
+		{Symbol: "BTC-20240329-50000C", Ratio: d("2")},
+	}}
+	order := b.NewOrder("s1", strategy, enum.Side_SELL, d("1"), d("-300"))
+	orders := NewStrategyOrders()
+	orders.Add(order)
+
+	if order.Canonical.Side != enum.Side_SELL || !order.Canonical.Qty.Equal(d("2")) || !order.Canonical.Price.Equal(d("-150")) {
+		t.Fatalf("canonical %s %s @ %s", order.Canonical.Side, order.Canonical.Qty, order.Canonical.Price)
+	}
+
+	legPx := map[string]string{"BTC-20240329-50000C": "1000", "BTC-20240628-50000C": "1150"}
+	report := newTestStrategyReport(t, "s1", "e1", "2", "-150", legPx, "BTC-20240329-50000C", "BTC-20240628-50000C")
+	exec, ok := orders.FromExecutionReport(report)
+	if !ok {
+		t.Fatal("report not matched")
+	}
+	if !exec.LastQty.Equal(d("1")) || !exec.LastPx.Equal(d("-300")) {
+		t.Errorf("last %s @ %s, want 1 @ -300", exec.LastQty, exec.LastPx)
+	}
+
+	want := map[int]StrategyLegFill{
+		0: {Leg: 0, Symbol: "BTC-20240628-50000C", Side: enum.Side_BUY, Qty: d("2"), Price: d("1150")},
+		1: {Leg: 1, Symbol: "BTC-20240329-50000C", Side: enum.Side_SELL, Qty: d("2"), Price: d("1000")},
+	}
+	if len(exec.LegFills) != 2 {
+		t.Fatalf("leg fills %v", exec.LegFills)
+	}
+	for _, fill := range exec.LegFills {
+		w := want[fill.Leg]
+		if fill.Symbol != w.Symbol || fill.Side != w.Side || !fill.Qty.Equal(w.Qty) || !fill.Price.Equal(w.Price) {
+			t.Errorf("leg fill %+v, want %+v", fill, w)
+		}
+	}
+
+	if _, ok := orders.FromExecutionReport(report); ok {
+		t.Error("resent report not ignored")
+	}
+	for i, qty := range order.LegCumQty {
+		if !qty.Equal(d("2")) {
+			t.Errorf("leg %d cum qty %s, want 2", i, qty)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package fix
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+const (
+	throttleAllMsgTypes = "*"
+
+	throttleMinFactor     = 0.1 // rates don't adapt below 10% of the configured ones
+	throttleRecoverPerSec = 0.1 // share of the configured rates recovered every second without throttle rejects
+
+	// BusinessRejectReason of FIX 5.0 SP2, sent by venues on FIX 4.4 too
+	businessRejectReason_THROTTLE_LIMIT_EXCEEDED            = "8"
+	businessRejectReason_THROTTLE_LIMIT_EXCEEDED_DISCONNECT = "9"
+
+	throttleOutboxSize = 1024
+)
+
+var (
+	rateLimitCmd = flag.String("rate", "", "Rate limits of outbound messages per session 'MsgType=rate[/burst],...' in msgs/sec, '*' for all app messages, e.g. 'D=10/20,F=50,*=100' (default: RateLimit of the config)")
+
+	throttles   = make(map[quickfix.SessionID]*Throttle)
+	throttlesMu sync.RWMutex
+
+	errThrottleOutboxFull = errors.New("throttle: outbox full, message dropped")
+)
+
+// tokenBucket allows rate messages per second with bursts of burst messages
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, factor float64) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate*factor)
+	}
+	b.last = now
+}
+
+// wait is the time until a token is available
+func (b *tokenBucket) wait(factor float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / (b.rate * factor) * float64(time.Second))
+}
+
+type throttleWaiter struct {
+	buckets []*tokenBucket
+	ready   chan struct{}
+}
+
+type throttledSend struct {
+	msg       quickfix.Messagable
+	sessionID quickfix.SessionID
+}
+
+// Throttle paces outbound messages of a session with token buckets per MsgType and for all messages.
+// Cancels and mass cancels jump ahead of queued messages, and rates are halved on throttle rejects of the venue
+// and recover while there are none.
+type Throttle struct {
+	mu        sync.Mutex
+	buckets   map[enum.MsgType]*tokenBucket
+	all       *tokenBucket
+	byType    map[enum.MsgType][]*tokenBucket // buckets taken by a message type
+	queues    [2][]*throttleWaiter            // by priority, 0 is cancels
+	wake      chan struct{}
+	running   bool
+	factor    float64 // of the configured rates
+	adaptedAt time.Time
+	now       func() time.Time
+
+	outbox     chan throttledSend // messages of callbacks, see SendToTargetAsync
+	outboxOnce sync.Once
+}
+
+// parseRateLimits parses 'MsgType=rate[/burst],...', the burst is a second of the rate by default
+func parseRateLimits(limits string) (map[string]*tokenBucket, error) {
+	res := make(map[string]*tokenBucket)
+	for _, limit := range strings.Split(limits, ",") {
+		limit = strings.TrimSpace(limit)
+		if limit == "" {
+			continue
+		}
+		msgType, value, found := strings.Cut(limit, "=")
+		if !found || msgType == "" {
+			return nil, fmt.Errorf("rate limit '%s': expected MsgType=rate[/burst]", limit)
+		}
+		rateStr, burstStr, hasBurst := strings.Cut(value, "/")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("rate limit '%s': invalid rate", limit)
+		}
+		burst := max(rate, 1)
+		if hasBurst {
+			burst, err = strconv.ParseFloat(burstStr, 64)
+			if err != nil || burst < 1 {
+				return nil, fmt.Errorf("rate limit '%s': invalid burst", limit)
+			}
+		}
+		res[strings.TrimSpace(msgType)] = &tokenBucket{rate: rate, burst: burst, tokens: burst}
+	}
+	return res, nil
+}
+
+// NewThrottle returns nil for empty limits
+func NewThrottle(limits string) (*Throttle, error) {
+	buckets, err := parseRateLimits(limits)
+	if err != nil || len(buckets) == 0 {
+		return nil, err
+	}
+	t := &Throttle{
+		buckets: make(map[enum.MsgType]*tokenBucket),
+		byType:  make(map[enum.MsgType][]*tokenBucket),
+		wake:    make(chan struct{}, 1),
+		factor:  1,
+		now:     time.Now,
+	}
+	for msgType, bucket := range buckets {
+		if msgType == throttleAllMsgTypes {
+			t.all = bucket
+		} else {
+			t.buckets[enum.MsgType(msgType)] = bucket
+		}
+	}
+	return t, nil
+}
+
+func throttlePriority(msgType enum.MsgType) int {
+	switch msgType {
+	case enum.MsgType_ORDER_CANCEL_REQUEST, enum.MsgType_ORDER_MASS_CANCEL_REQUEST:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func (t *Throttle) bucketsOfLocked(msgType enum.MsgType) []*tokenBucket {
+	if buckets, found := t.byType[msgType]; found {
+		return buckets
+	}
+	buckets := make([]*tokenBucket, 0, 2)
+	if bucket := t.buckets[msgType]; bucket != nil {
+		buckets = append(buckets, bucket)
+	}
+	if t.all != nil {
+		buckets = append(buckets, t.all)
+	}
+	t.byType[msgType] = buckets
+	return buckets
+}
+
+// adaptLocked recovers the rates since the last adaptation
+func (t *Throttle) adaptLocked(now time.Time) {
+	if t.factor < 1 {
+		t.factor = min(1, t.factor+now.Sub(t.adaptedAt).Seconds()*throttleRecoverPerSec)
+	}
+	t.adaptedAt = now
+}
+
+// takeLocked takes a token of every bucket if all have one, else returns the longest wait
+func (t *Throttle) takeLocked(buckets []*tokenBucket, now time.Time) (wait time.Duration) {
+	for _, bucket := range buckets {
+		bucket.refill(now, t.factor)
+		wait = max(wait, bucket.wait(t.factor))
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return 0
+}
+
+// Wait blocks until a message of the type may be sent
+func (t *Throttle) Wait(msgType enum.MsgType) {
+	priority := throttlePriority(msgType)
+
+	t.mu.Lock()
+	now := t.now()
+	t.adaptLocked(now)
+	buckets := t.bucketsOfLocked(msgType)
+
+	queued := false
+	for p := 0; p <= priority; p++ {
+		queued = queued || len(t.queues[p]) > 0
+	}
+	if !queued && t.takeLocked(buckets, now) == 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	waiter := &throttleWaiter{buckets: buckets, ready: make(chan struct{})}
+	t.queues[priority] = append(t.queues[priority], waiter)
+	if !t.running {
+		t.running = true
+		go t.dispatch()
+	} else {
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+	t.mu.Unlock()
+
+	<-waiter.ready
+}
+
+// dispatch releases queued messages by priority until the queues are empty
+func (t *Throttle) dispatch() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		t.mu.Lock()
+		wait := t.releaseLocked(t.now())
+		if len(t.queues[0]) == 0 && len(t.queues[1]) == 0 {
+			t.running = false
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-t.wake:
+		}
+	}
+}
+
+// releaseLocked releases queued messages by priority while tokens are available, returns the wait for the next one
+func (t *Throttle) releaseLocked(now time.Time) (wait time.Duration) {
+	t.adaptLocked(now)
+
+	blocked := make(map[*tokenBucket]bool)
+	for p := range t.queues {
+		for len(t.queues[p]) > 0 {
+			waiter := t.queues[p][0]
+			// a bucket awaited by a higher priority message is not taken by a lower one
+			isBlocked := false
+			for _, bucket := range waiter.buckets {
+				isBlocked = isBlocked || blocked[bucket]
+			}
+			if isBlocked {
+				break
+			}
+			if w := t.takeLocked(waiter.buckets, now); w > 0 {
+				for _, bucket := range waiter.buckets {
+					blocked[bucket] = true
+				}
+				if wait == 0 || w < wait {
+					wait = w
+				}
+				break
+			}
+			t.queues[p] = t.queues[p][1:]
+			close(waiter.ready)
+		}
+	}
+	return wait
+}
+
+// OnReject halves the rates, they recover by 10% of the configured rates a second
+func (t *Throttle) OnReject() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.adaptLocked(t.now())
+	t.factor = max(throttleMinFactor, t.factor/2)
+	if t.all != nil {
+		t.all.tokens = min(t.all.tokens, 0)
+	}
+	for _, bucket := range t.buckets {
+		bucket.tokens = min(bucket.tokens, 0)
+	}
+	fmt.Printf("Throttle: venue throttle reject, rates at %.0f%%\n", t.factor*100)
+}
+
+// isThrottleReject recognizes rejects of the venue throttle by BusinessRejectReason or text
+func isThrottleReject(msg *quickfix.Message) bool {
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_BUSINESS_MESSAGE_REJECT:
+		reason := getString(msg.Body, tag.BusinessRejectReason)
+		if reason == businessRejectReason_THROTTLE_LIMIT_EXCEEDED || reason == businessRejectReason_THROTTLE_LIMIT_EXCEEDED_DISCONNECT {
+			return true
+		}
+	case enum.MsgType_EXECUTION_REPORT:
+		if enum.ExecType(getString(msg.Body, tag.ExecType)) != enum.ExecType_REJECTED {
+			return false
+		}
+	case enum.MsgType_ORDER_CANCEL_REJECT, enum.MsgType_REJECT:
+	default:
+		return false
+	}
+	text := strings.ToLower(getString(msg.Body, tag.Text))
+	return strings.Contains(text, "throttl") || strings.Contains(text, "rate limit") || strings.Contains(text, "too many")
+}
+
+// registerThrottles creates throttles of the sessions of settings from `-rate` or their RateLimit setting
+func registerThrottles(settings *quickfix.Settings) (bool, error) {
+	registered := false
+	for sessionID, sessionSettings := range settings.SessionSettings() {
+		limits := *rateLimitCmd
+		if limits == "" {
+			limits, _ = sessionSettings.Setting("RateLimit")
+		}
+		throttle, err := NewThrottle(limits)
+		if err != nil {
+			return false, err
+		}
+		if throttle == nil {
+			continue
+		}
+		throttlesMu.Lock()
+		throttles[sessionID] = throttle
+		throttlesMu.Unlock()
+		registered = true
+	}
+	return registered, nil
+}
+
+func throttleOf(sessionID quickfix.SessionID) *Throttle {
+	throttlesMu.RLock()
+	defer throttlesMu.RUnlock()
+	return throttles[sessionID]
+}
+
+// SendToTarget sends a message like quickfix.SendToTarget after waiting for the throttle of the session
+func SendToTarget(m quickfix.Messagable, sessionID quickfix.SessionID) error {
+	if throttle := throttleOf(sessionID); throttle != nil {
+		msgType, _ := m.ToMessage().MsgType()
+		throttle.Wait(enum.MsgType(msgType))
+	}
+	return quickfix.SendToTarget(m, sessionID)
+}
+
+// Send sends a message like quickfix.Send, to the session of its header, after waiting for the throttle of the session
+func Send(m quickfix.Messagable) error {
+	msg := m.ToMessage()
+	return SendToTarget(msg, sessionIDOf(msg))
+}
+
+func sessionIDOf(msg *quickfix.Message) quickfix.SessionID {
+	return quickfix.SessionID{
+		BeginString:  getString(&msg.Header, tag.BeginString),
+		SenderCompID: getString(&msg.Header, tag.SenderCompID),
+		TargetCompID: getString(&msg.Header, tag.TargetCompID),
+	}
+}
+
+// SendToTargetAsync sends a message like SendToTarget without waiting for the throttle, for quickfix callbacks
+// which would block the session. Throttled messages are sent in order by a goroutine of the session, their errors
+// are printed. A message is dropped with an error rather than blocking the callback when the outbox is full.
+func SendToTargetAsync(m quickfix.Messagable, sessionID quickfix.SessionID) error {
+	throttle := throttleOf(sessionID)
+	if throttle == nil {
+		return quickfix.SendToTarget(m, sessionID)
+	}
+
+	throttle.outboxOnce.Do(func() {
+		throttle.outbox = make(chan throttledSend, throttleOutboxSize)
+		go throttle.sendOutbox()
+	})
+	select {
+	case throttle.outbox <- throttledSend{m, sessionID}:
+		return nil
+	default:
+		return errThrottleOutboxFull
+	}
+}
+
+// SendAsync is SendToTargetAsync to the session of the header
+func SendAsync(m quickfix.Messagable) error {
+	msg := m.ToMessage()
+	return SendToTargetAsync(msg, sessionIDOf(msg))
+}
+
+func (t *Throttle) sendOutbox() {
+	for send := range t.outbox {
+		if err := SendToTarget(send.msg, send.sessionID); err != nil {
+			fmt.Printf("Send: %v\n", err)
+		}
+	}
+}
+
+// throttleApp adapts the throttles of sessions to their rejects
+type throttleApp struct {
+	ApplicationWithWait
+}
+
+func (a throttleApp) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	if throttle := throttleOf(sessionID); throttle != nil && isThrottleReject(msg) {
+		throttle.OnReject()
+	}
+	return a.ApplicationWithWait.FromAdmin(msg, sessionID)
+}
+
+func (a throttleApp) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	if throttle := throttleOf(sessionID); throttle != nil && isThrottleReject(msg) {
+		throttle.OnReject()
+	}
+	return a.ApplicationWithWait.FromApp(msg, sessionID)
+}
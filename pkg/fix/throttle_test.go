@@ -0,0 +1,196 @@
+package fix
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+)
+
+// testClock is the injected clock of a throttle
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestThrottle(t *testing.T, limits string) (*Throttle, *testClock) {
+	throttle, err := NewThrottle(limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	throttle.now = clock.Now
+	throttle.adaptedAt = clock.now
+	return throttle, clock
+}
+
+// take takes tokens for a message as Wait does when nothing is queued, returns the wait if there are none
+func (t *Throttle) take(msgType enum.MsgType) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.adaptLocked(now)
+	return t.takeLocked(t.bucketsOfLocked(msgType), now)
+}
+
+func (t *Throttle) enqueue(msgType enum.MsgType) *throttleWaiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	waiter := &throttleWaiter{buckets: t.bucketsOfLocked(msgType), ready: make(chan struct{})}
+	priority := throttlePriority(msgType)
+	t.queues[priority] = append(t.queues[priority], waiter)
+	return waiter
+}
+
+func (t *Throttle) release() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.releaseLocked(t.now())
+}
+
+func isReleased(waiter *throttleWaiter) bool {
+	select {
+	case <-waiter.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// rates and times of the tests are binary fractions, so that token arithmetic is exact
+func TestThrottleRefill(t *testing.T) {
+	throttle, clock := newTestThrottle(t, "D=4/2")
+
+	// the burst is available at once, then a token every 250ms
+	for i := 0; i < 2; i++ {
+		if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait != 0 {
+			t.Fatalf("burst message %d waits %v", i, wait)
+		}
+	}
+	if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait != 250*time.Millisecond {
+		t.Errorf("wait %v, want 250ms", wait)
+	}
+	clock.Add(125 * time.Millisecond)
+	if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait != 125*time.Millisecond {
+		t.Errorf("wait %v, want 125ms", wait)
+	}
+	clock.Add(125 * time.Millisecond)
+	if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait != 0 {
+		t.Errorf("wait %v after refill", wait)
+	}
+
+	// refill is capped by the burst
+	clock.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		throttle.take(enum.MsgType_ORDER_SINGLE)
+	}
+	if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait == 0 {
+		t.Error("more than the burst after an idle hour")
+	}
+
+	// other message types are not limited by D
+	if wait := throttle.take(enum.MsgType_ORDER_CANCEL_REQUEST); wait != 0 {
+		t.Errorf("F waits %v", wait)
+	}
+}
+
+func TestThrottleAllMessages(t *testing.T) {
+	throttle, _ := newTestThrottle(t, "D=4/4,*=1/1")
+	if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait != 0 {
+		t.Fatalf("wait %v", wait)
+	}
+	// the '*' bucket is shared by all types
+	if wait := throttle.take(enum.MsgType_ORDER_CANCEL_REQUEST); wait != time.Second {
+		t.Errorf("F waits %v, want 1s", wait)
+	}
+}
+
+func TestThrottleCancelsFirst(t *testing.T) {
+	throttle, clock := newTestThrottle(t, "*=1/1")
+	throttle.take(enum.MsgType_ORDER_SINGLE)
+
+	newOrder := throttle.enqueue(enum.MsgType_ORDER_SINGLE)
+	replace := throttle.enqueue(enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST)
+	cancel := throttle.enqueue(enum.MsgType_ORDER_CANCEL_REQUEST)
+	massCancel := throttle.enqueue(enum.MsgType_ORDER_MASS_CANCEL_REQUEST)
+
+	if wait := throttle.release(); wait != time.Second || isReleased(cancel) {
+		t.Fatalf("released without tokens, wait %v", wait)
+	}
+	for i, next := range []*throttleWaiter{cancel, massCancel, newOrder, replace} {
+		clock.Add(time.Second)
+		throttle.release()
+		if !isReleased(next) {
+			t.Fatalf("message %d not released", i)
+		}
+		for _, later := range []*throttleWaiter{cancel, massCancel, newOrder, replace}[i+1:] {
+			if isReleased(later) {
+				t.Fatalf("message after %d released early", i)
+			}
+		}
+	}
+}
+
+func TestThrottleOnReject(t *testing.T) {
+	throttle, clock := newTestThrottle(t, "D=4/4")
+
+	throttle.OnReject()
+	if throttle.factor != 0.5 {
+		t.Fatalf("factor %v, want 0.5", throttle.factor)
+	}
+	// tokens are dropped and refill at half the rate
+	if wait := throttle.take(enum.MsgType_ORDER_SINGLE); wait != 500*time.Millisecond {
+		t.Errorf("wait %v, want 500ms", wait)
+	}
+
+	// rates recover by 10% of the configured ones a second
+	clock.Add(2500 * time.Millisecond)
+	throttle.take(enum.MsgType_ORDER_SINGLE)
+	if throttle.factor != 0.75 {
+		t.Errorf("factor %v after 2.5s, want 0.75", throttle.factor)
+	}
+	clock.Add(time.Minute)
+	throttle.take(enum.MsgType_ORDER_SINGLE)
+	if throttle.factor != 1 {
+		t.Errorf("factor %v after a minute, want 1", throttle.factor)
+	}
+
+	for i := 0; i < 10; i++ {
+		throttle.OnReject()
+	}
+	if throttle.factor != throttleMinFactor {
+		t.Errorf("factor %v, want %v", throttle.factor, throttleMinFactor)
+	}
+}
+
+func TestSendToTargetAsyncOutboxFull(t *testing.T) {
+	throttle, _ := newTestThrottle(t, "D=1")
+	sessionID := quickfix.SessionID{BeginString: quickfix.BeginStringFIX44, SenderCompID: "outbox", TargetCompID: "PT"}
+	throttlesMu.Lock()
+	throttles[sessionID] = throttle
+	throttlesMu.Unlock()
+	defer func() {
+		throttlesMu.Lock()
+		delete(throttles, sessionID)
+		throttlesMu.Unlock()
+	}()
+
+	// an outbox of one message nobody sends
+	throttle.outboxOnce.Do(func() {
+		throttle.outbox = make(chan throttledSend, 1)
+	})
+	if err := SendToTargetAsync(quickfix.NewMessage(), sessionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendToTargetAsync(quickfix.NewMessage(), sessionID); err != errThrottleOutboxFull {
+		t.Errorf("error %v, want %v", err, errThrottleOutboxFull)
+	}
+}
@@ -0,0 +1,350 @@
+package fix
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/tradecapturereportrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+	"github.com/quickfixgo/tag"
+)
+
+const (
+	tradeHistoryTimeout = 30 * time.Second
+)
+
+var (
+	tradeHistoryFromCmd   = flag.String("thfrom", "", "Trade history: trades since (RFC3339 or 2006-01-02, default 1970-01-01 if -thto is set)")
+	tradeHistoryToCmd     = flag.String("thto", "", "Trade history: trades before (RFC3339 or 2006-01-02)")
+	tradeHistorySymCmd    = flag.String("ths", "", "Trade history: trades of symbol")
+	tradeHistoryOutCmd    = flag.String("tho", "-", "Trade history: output file ('-' for stdout, '' to skip)")
+	tradeHistoryFormatCmd = flag.String("thfmt", "csv", "Trade history: output format (csv/json)")
+
+	// tradeHistoryEpoch is the start of a window with an end only, the first date can't be left out
+	tradeHistoryEpoch = time.Unix(0, 0).UTC()
+)
+
+// TradeHistoryRequest selects trades, zero values match everything
+type TradeHistoryRequest struct {
+	From   time.Time
+	To     time.Time
+	Symbol string
+}
+
+// tradeHistoryPage collects TradeCaptureReports of one TradeCaptureReportRequest
+type tradeHistoryPage struct {
+	total    int // TotNumTradeReports, -1 until known
+	trades   []*journal.Execution
+	done     chan error
+	finished bool
+}
+
+func (p *tradeHistoryPage) finish(err error) {
+	if !p.finished {
+		p.finished = true
+		p.done <- err
+	}
+}
+
+// TradeHistoryClient downloads trades with TradeCaptureReportRequest
+type TradeHistoryClient struct {
+	*TradeClient
+	mu    sync.Mutex
+	pages map[string]*tradeHistoryPage // TradeRequestID -> page
+}
+
+func NewTradeHistoryClient(app *TradeClient) *TradeHistoryClient {
+	return &TradeHistoryClient{
+		TradeClient: app,
+		pages:       make(map[string]*tradeHistoryPage),
+	}
+}
+
+func (c *TradeHistoryClient) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	reject = c.TradeClient.FromApp(msg, sessionID)
+
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_TRADE_CAPTURE_REPORT_REQUEST_ACK:
+		c.onAck(msg)
+	case enum.MsgType_TRADE_CAPTURE_REPORT:
+		c.onReport(msg)
+	}
+	return
+}
+
+func (c *TradeHistoryClient) onAck(msg *quickfix.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	page := c.pages[getString(msg.Body, tag.TradeRequestID)]
+	if page == nil {
+		return
+	}
+
+	result := enum.TradeRequestResult(getString(msg.Body, tag.TradeRequestResult))
+	status := enum.TradeRequestStatus(getString(msg.Body, tag.TradeRequestStatus))
+	if status == enum.TradeRequestStatus_REJECTED || (result != "" && result != enum.TradeRequestResult_SUCCESSFUL) {
+		page.finish(fmt.Errorf("TradeCaptureReportRequest rejected: TradeRequestResult=%s %s", result, getString(msg.Body, tag.Text)))
+		return
+	}
+
+	if msg.Body.Has(tag.TotNumTradeReports) {
+		page.total = getInt(msg.Body, tag.TotNumTradeReports)
+	}
+	if page.total == 0 || (status == enum.TradeRequestStatus_COMPLETED && page.total > 0 && len(page.trades) >= page.total) {
+		page.finish(nil)
+	}
+}
+
+func (c *TradeHistoryClient) onReport(msg *quickfix.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	page := c.pages[getString(msg.Body, tag.TradeRequestID)]
+	if page == nil {
+		return
+	}
+
+	page.trades = append(page.trades, DecodeTradeCapture(msg, time.Now()))
+	if msg.Body.Has(tag.TotNumTradeReports) {
+		page.total = getInt(msg.Body, tag.TotNumTradeReports)
+	}
+
+	last, _ := msg.Body.GetBool(tag.LastRptRequested)
+	if last || (page.total > 0 && len(page.trades) >= page.total) {
+		page.finish(nil)
+	}
+}
+
+// requestPage sends one TradeCaptureReportRequest and waits for its last report
+func (c *TradeHistoryClient) requestPage(targetCompID string, req TradeHistoryRequest, timeout time.Duration) (*tradeHistoryPage, error) {
+	requestID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+
+	request := tradecapturereportrequest.New(
+		field.NewTradeRequestID(requestID),
+		field.NewTradeRequestType(enum.TradeRequestType_ALL_TRADES),
+	)
+	request.SetSubscriptionRequestType(enum.SubscriptionRequestType_SNAPSHOT)
+	if req.Symbol != "" {
+		request.SetSymbol(req.Symbol)
+	}
+	// first date is the start of the window, second one the end
+	if !req.From.IsZero() || !req.To.IsZero() {
+		from := req.From
+		if from.IsZero() {
+			from = tradeHistoryEpoch
+		}
+		dates := tradecapturereportrequest.NewNoDatesRepeatingGroup()
+		dates.Add().SetTransactTime(from)
+		if !req.To.IsZero() {
+			dates.Add().SetTransactTime(req.To)
+		}
+		request.SetNoDates(dates)
+	}
+
+	msg := request.ToMessage()
+	msg.Header.Set(field.NewSenderCompID(c.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(targetCompID))
+
+	page := &tradeHistoryPage{total: -1, done: make(chan error, 1)}
+	c.mu.Lock()
+	c.pages[requestID] = page
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pages, requestID)
+		c.mu.Unlock()
+	}()
+
+	err := Send(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case err = <-page.done:
+	case <-time.After(timeout):
+		err = fmt.Errorf("TradeCaptureReportRequest %s: no last report in %v", requestID, timeout)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return page, err
+}
+
+// Request downloads trades page by page: a request answered with less reports than TotNumTradeReports
+// is continued from the TransactTime of its last trade, trades seen already are skipped by ExecID
+func (c *TradeHistoryClient) Request(targetCompID string, req TradeHistoryRequest, timeout time.Duration) ([]*journal.Execution, error) {
+	trades := make([]*journal.Execution, 0)
+	seen := make(map[string]bool)
+
+	for {
+		page, err := c.requestPage(targetCompID, req, timeout)
+		if err != nil {
+			return trades, err
+		}
+
+		added := 0
+		for _, trade := range page.trades {
+			if seen[trade.ExecID] {
+				continue
+			}
+			seen[trade.ExecID] = true
+			trades = append(trades, trade)
+			added++
+			if trade.TransactTime.After(req.From) {
+				req.From = trade.TransactTime
+			}
+		}
+
+		if page.total < 0 || len(page.trades) >= page.total || added == 0 {
+			return trades, nil
+		}
+		fmt.Printf("Trade history: %d of %d reports received, requesting from %s\n",
+			len(page.trades), page.total, req.From.Format(time.RFC3339Nano))
+	}
+}
+
+// DecodeTradeCapture converts a TradeCaptureReport for the journal, side fields come from the first NoSides entry
+func DecodeTradeCapture(msg *quickfix.Message, receivedAt time.Time) *journal.Execution {
+	exec := &journal.Execution{
+		ExecID:     getString(msg.Body, tag.ExecID),
+		ExecType:   enum.ExecType_TRADE,
+		OrdStatus:  enum.OrdStatus(getString(msg.Body, tag.OrdStatus)),
+		Symbol:     getString(msg.Body, tag.Symbol),
+		LastQty:    getDecimal(msg.Body, tag.LastQty),
+		LastPx:     getDecimal(msg.Body, tag.LastPx),
+		ReceivedAt: receivedAt,
+
+		TradeCapture: true,
+	}
+	if exec.ExecID == "" {
+		exec.ExecID = getString(msg.Body, tag.TradeReportID)
+	}
+	exec.TransactTime, _ = msg.Body.GetTime(tag.TransactTime)
+
+	if sides, err := GetMessageGroup(msg, tag.NoSides); err == nil && sides.Len() > 0 {
+		side := sides.Get(0)
+		exec.Side = enum.Side(getString(side, tag.Side))
+		exec.OrderID = getString(side, tag.OrderID)
+		exec.ClOrdID = getString(side, tag.ClOrdID)
+		exec.Fee = getDecimal(side, tag.Commission)
+		exec.FeeCurrency = getString(side, tag.CommCurrency)
+	}
+
+	if legs, err := GetMessageGroup(msg, tag.NoLegs); err == nil {
+		for i := 0; i < legs.Len(); i++ {
+			exec.Legs = append(exec.Legs, decodeLegFill(legs.Get(i), exec))
+		}
+	}
+	return exec
+}
+
+func writeTradeHistory(trades []*journal.Execution) error {
+	fills := make([]*journal.Fill, 0, len(trades))
+	for _, trade := range trades {
+		fills = append(fills, trade.Fill())
+	}
+
+	write := journal.WriteCSV
+	switch strings.ToLower(*tradeHistoryFormatCmd) {
+	case "csv":
+	case "json":
+		write = journal.WriteJSON
+	default:
+		return fmt.Errorf("unknown trade history format '%s': csv/json", *tradeHistoryFormatCmd)
+	}
+
+	switch *tradeHistoryOutCmd {
+	case "":
+		return nil
+	case "-":
+		return write(os.Stdout, fills)
+	default:
+		out, err := os.Create(*tradeHistoryOutCmd)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		err = write(out, fills)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d trades to %s\n", len(fills), *tradeHistoryOutCmd)
+		return nil
+	}
+}
+
+func mergeTradeHistory(trades []*journal.Execution) error {
+	j, err := journal.Open(*journalCmd)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	recorded := 0
+	for _, trade := range trades {
+		ok, err := j.Record(trade)
+		if err != nil {
+			return err
+		}
+		if ok {
+			recorded++
+		}
+	}
+	fmt.Printf("Merged %d trades into %s, %d already journaled\n", recorded, *journalCmd, len(trades)-recorded)
+	return nil
+}
+
+// RunTradeHistory downloads trades of a time window or symbol, exports them and merges them into the journal if set (-j)
+func RunTradeHistory(cfgFileName string, apiKeyName string) error {
+	if *tradeHistoryOutCmd == "" && *journalCmd == "" {
+		return errors.New("nothing to do with trades: set output (-tho) or journal (-j)")
+	}
+
+	from, err := parseTimeFlag(*tradeHistoryFromCmd)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(*tradeHistoryToCmd)
+	if err != nil {
+		return err
+	}
+
+	tapp, err := NewTradeClient(cfgFileName, apiKeyName)
+	if err != nil {
+		return err
+	}
+	app := NewTradeHistoryClient(tapp)
+
+	err = StartConnection(app, app.Settings)
+	if err != nil {
+		return err
+	}
+	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
+
+	trades, err := app.Request(targetCompID, TradeHistoryRequest{From: from, To: to, Symbol: *tradeHistorySymCmd}, tradeHistoryTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = writeTradeHistory(trades)
+	if err != nil {
+		return err
+	}
+	if *journalCmd != "" {
+		return mergeTradeHistory(trades)
+	}
+	return nil
+}
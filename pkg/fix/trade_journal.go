@@ -0,0 +1,181 @@
+package fix
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	journalCmd     = flag.String("j", "", "Journal: SQLite file of drop-copy ExecutionReports")
+	journalFromCmd = flag.String("jfrom", "", "Journal: fills since (RFC3339 or 2006-01-02)")
+	journalToCmd   = flag.String("jto", "", "Journal: fills before (RFC3339 or 2006-01-02)")
+	journalSymCmd  = flag.String("js", "", "Journal: fills of symbol")
+	journalOrdCmd  = flag.String("jo", "", "Journal: fills of OrderID / ClOrdID")
+	journalCSVCmd  = flag.String("jcsv", "", "Journal: export fills to CSV file ('-' for stdout)")
+)
+
+// DecodeExecution converts an ExecutionReport for the journal
+func DecodeExecution(msg *quickfix.Message, receivedAt time.Time) *journal.Execution {
+	exec := &journal.Execution{
+		ExecID:       getString(msg.Body, tag.ExecID),
+		OrderID:      getString(msg.Body, tag.OrderID),
+		ClOrdID:      getString(msg.Body, tag.ClOrdID),
+		ExecType:     enum.ExecType(getString(msg.Body, tag.ExecType)),
+		OrdStatus:    enum.OrdStatus(getString(msg.Body, tag.OrdStatus)),
+		Symbol:       getString(msg.Body, tag.Symbol),
+		Side:         enum.Side(getString(msg.Body, tag.Side)),
+		OrdType:      enum.OrdType(getString(msg.Body, tag.OrdType)),
+		OrderQty:     getDecimal(msg.Body, tag.OrderQty),
+		Price:        getDecimal(msg.Body, tag.Price),
+		LastQty:      getDecimal(msg.Body, tag.LastQty),
+		LastPx:       getDecimal(msg.Body, tag.LastPx),
+		CumQty:       getDecimal(msg.Body, tag.CumQty),
+		LeavesQty:    getDecimal(msg.Body, tag.LeavesQty),
+		AvgPx:        getDecimal(msg.Body, tag.AvgPx),
+		Fee:          getDecimal(msg.Body, tag.Commission),
+		FeeCurrency:  getString(msg.Body, tag.CommCurrency),
+		OrdRejReason: getString(msg.Body, tag.OrdRejReason),
+		Text:         getString(msg.Body, tag.Text),
+		ReceivedAt:   receivedAt,
+	}
+	exec.TransactTime, _ = msg.Body.GetTime(tag.TransactTime)
+
+	// Fees may come as MiscFeesGrp instead of CommissionData, the currency of the 1st one is FeeCurrency
+	if !msg.Body.Has(tag.Commission) {
+		if fees, err := GetMessageGroup(msg, tag.NoMiscFees); err == nil {
+			for i := 0; i < fees.Len(); i++ {
+				amount := getDecimal(fees.Get(i), tag.MiscFeeAmt)
+				currency := getString(fees.Get(i), tag.MiscFeeCurr)
+				if i == 0 {
+					exec.FeeCurrency = currency
+				}
+				if currency == exec.FeeCurrency {
+					exec.Fee = exec.Fee.Add(amount)
+					continue
+				}
+				if exec.OtherFees == nil {
+					exec.OtherFees = make(map[string]decimal.Decimal)
+				}
+				exec.OtherFees[currency] = exec.OtherFees[currency].Add(amount)
+			}
+		}
+	}
+
+	if exec.IsFill() {
+		if legs, err := GetMessageGroup(msg, tag.NoLegs); err == nil {
+			for i := 0; i < legs.Len(); i++ {
+				exec.Legs = append(exec.Legs, decodeLegFill(legs.Get(i), exec))
+			}
+		}
+	}
+	return exec
+}
+
+func decodeLegFill(g *quickfix.Group, exec *journal.Execution) journal.LegFill {
+	ratio := getDecimal(g, tag.LegRatioQty)
+
+	leg := journal.LegFill{
+		Symbol: getString(g, tag.LegSymbol),
+		Side:   enum.Side(getString(g, tag.LegSide)),
+		Qty:    exec.LastQty.Mul(ratio.Abs()),
+		Px:     getDecimal(g, tag.LegLastPx),
+	}
+	if ratio.IsZero() {
+		leg.Qty = getDecimal(g, tag.LegQty)
+	}
+	if leg.Side == "" {
+		leg.Side = exec.Side
+		if ratio.IsNegative() {
+			leg.Side = oppositeSide(exec.Side)
+		}
+	}
+	return leg
+}
+
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time '%s': expected RFC3339 or 2006-01-02", value)
+	}
+	return t, nil
+}
+
+// RunJournal lists journaled fills by time range, symbol and order, or exports them to CSV
+func RunJournal() error {
+	if *journalCmd == "" {
+		return errors.New("journal file is not set (-j)")
+	}
+
+	from, err := parseTimeFlag(*journalFromCmd)
+	if err != nil {
+		return err
+	}
+	to, err := parseTimeFlag(*journalToCmd)
+	if err != nil {
+		return err
+	}
+
+	j, err := journal.Open(*journalCmd)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	fills, err := j.Fills(journal.FillFilter{
+		From:    from,
+		To:      to,
+		Symbol:  *journalSymCmd,
+		OrderID: *journalOrdCmd,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch *journalCSVCmd {
+	case "":
+	case "-":
+		return journal.WriteCSV(os.Stdout, fills)
+	default:
+		out, err := os.Create(*journalCSVCmd)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		err = journal.WriteCSV(out, fills)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d fills to %s\n", len(fills), *journalCSVCmd)
+		return nil
+	}
+
+	total := decimal.Zero
+	fmt.Printf("%-27s %-20s %-20s %-24s %-4s %14s %14s %12s\n", "TransactTime", "ExecID", "OrderID", "Symbol", "Side", "Qty", "Px", "Fee")
+	for _, fill := range fills {
+		fmt.Printf("%-27s %-20s %-20s %-24s %-4s %14s %14s %12s %s %s\n",
+			fill.TransactTime.Format(time.RFC3339Nano), fill.ExecID, fill.OrderID, fill.Symbol, fill.Side,
+			fill.LastQty, fill.LastPx, fill.Fee, fill.FeeCurrency, journal.FormatOtherFees(fill.OtherFees),
+		)
+		for _, leg := range fill.Legs {
+			fmt.Printf("%-27s %-20s %-20s   %-22s %-4s %14s %14s\n", "", "", "", leg.Symbol, leg.Side, leg.Qty, leg.Px)
+		}
+		total = total.Add(fill.LastQty)
+	}
+	fmt.Printf("Fills: %d, total qty: %s\n", len(fills), total)
+	return nil
+}
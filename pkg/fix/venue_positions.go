@@ -0,0 +1,443 @@
+package fix
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/journal"
+	"github.com/Power-Trade/fix-api-clients/pkg/fix/positions"
+	"github.com/Power-Trade/fix-api-clients/pkg/pt"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix44/requestforpositions"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/quickfix/config"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	venuePositionsTimeout = 10 * time.Second
+)
+
+var (
+	positionsAccountCmd   = flag.String("pa", "", "Positions: account of RequestForPositions")
+	positionsSubscribeCmd = flag.Bool("psub", false, "Positions: subscribe to position updates")
+)
+
+// PositionQty is an entry of the PositionQty component
+type PositionQty struct {
+	Type  enum.PosType
+	Long  decimal.Decimal
+	Short decimal.Decimal
+}
+
+// VenuePosition is a decoded PositionReport
+type VenuePosition struct {
+	ReportID             string
+	Account              string
+	Symbol               string
+	Currency             string
+	SettlPrice           decimal.Decimal
+	ClearingBusinessDate string
+	Quantities           []PositionQty
+	Amounts              map[enum.PosAmtType]decimal.Decimal
+	ReceivedAt           time.Time
+}
+
+// Qty is the signed net position, taken from the total transaction quantity if reported
+func (p VenuePosition) Qty() decimal.Decimal {
+	for _, q := range p.Quantities {
+		if q.Type == enum.PosType_TOTAL_TRANSACTION_QTY {
+			return q.Long.Sub(q.Short)
+		}
+	}
+	if len(p.Quantities) > 0 {
+		return p.Quantities[0].Long.Sub(p.Quantities[0].Short)
+	}
+	return decimal.Zero
+}
+
+// DecodePositionReport converts a PositionReport
+func DecodePositionReport(msg *quickfix.Message, receivedAt time.Time) VenuePosition {
+	p := VenuePosition{
+		ReportID:             getString(msg.Body, tag.PosMaintRptID),
+		Account:              getString(msg.Body, tag.Account),
+		Symbol:               getString(msg.Body, tag.Symbol),
+		Currency:             getString(msg.Body, tag.Currency),
+		SettlPrice:           getDecimal(msg.Body, tag.SettlPrice),
+		ClearingBusinessDate: getString(msg.Body, tag.ClearingBusinessDate),
+		Amounts:              make(map[enum.PosAmtType]decimal.Decimal),
+		ReceivedAt:           receivedAt,
+	}
+
+	if qtys, err := GetMessageGroup(msg, tag.NoPositions); err == nil {
+		for i := 0; i < qtys.Len(); i++ {
+			p.Quantities = append(p.Quantities, PositionQty{
+				Type:  enum.PosType(getString(qtys.Get(i), tag.PosType)),
+				Long:  getDecimal(qtys.Get(i), tag.LongQty),
+				Short: getDecimal(qtys.Get(i), tag.ShortQty),
+			})
+		}
+	}
+	if amounts, err := GetMessageGroup(msg, tag.NoPosAmt); err == nil {
+		for i := 0; i < amounts.Len(); i++ {
+			amtType := enum.PosAmtType(getString(amounts.Get(i), tag.PosAmtType))
+			p.Amounts[amtType] = getDecimal(amounts.Get(i), tag.PosAmt)
+		}
+	}
+	return p
+}
+
+// positionsRequest collects PositionReports of one RequestForPositions
+type positionsRequest struct {
+	total     int // TotalNumPosReports, -1 until known
+	positions []VenuePosition
+	done      chan error
+	finished  bool
+}
+
+func (r *positionsRequest) finish(err error) {
+	if !r.finished {
+		r.finished = true
+		r.done <- err
+	}
+}
+
+// PositionsClient requests positions with RequestForPositions and keeps the latest ones reported
+type PositionsClient struct {
+	*TradeClient
+	mu        sync.Mutex
+	requests  map[string]*positionsRequest // PosReqID -> request
+	positions map[string]VenuePosition     // account/symbol -> latest report
+	OnUpdate  func(p VenuePosition)        // called for reports of a subscription after its snapshot
+}
+
+func NewPositionsClient(app *TradeClient) *PositionsClient {
+	return &PositionsClient{
+		TradeClient: app,
+		requests:    make(map[string]*positionsRequest),
+		positions:   make(map[string]VenuePosition),
+	}
+}
+
+func (c *PositionsClient) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	reject = c.TradeClient.FromApp(msg, sessionID)
+
+	msgType, _ := msg.MsgType()
+	switch enum.MsgType(msgType) {
+	case enum.MsgType_REQUEST_FOR_POSITIONS_ACK:
+		c.onAck(msg)
+	case enum.MsgType_POSITION_REPORT:
+		c.onReport(msg)
+	}
+	return
+}
+
+func (c *PositionsClient) onAck(msg *quickfix.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request := c.requests[getString(msg.Body, tag.PosReqID)]
+	if request == nil {
+		return
+	}
+
+	result := enum.PosReqResult(getString(msg.Body, tag.PosReqResult))
+	status := enum.PosReqStatus(getString(msg.Body, tag.PosReqStatus))
+	switch {
+	case result == enum.PosReqResult_NO_POSITIONS_FOUND_THAT_MATCH_CRITERIA:
+		request.finish(nil)
+		return
+	case result != enum.PosReqResult_VALID_REQUEST || status == enum.PosReqStatus_REJECTED:
+		request.finish(fmt.Errorf("RequestForPositions rejected: PosReqResult=%s %s", result, getString(msg.Body, tag.Text)))
+		return
+	}
+
+	if msg.Body.Has(tag.TotalNumPosReports) {
+		request.total = getInt(msg.Body, tag.TotalNumPosReports)
+	}
+	if request.total >= 0 && len(request.positions) >= request.total {
+		request.finish(nil)
+	}
+}
+
+func (c *PositionsClient) onReport(msg *quickfix.Message) {
+	p := DecodePositionReport(msg, time.Now())
+
+	c.mu.Lock()
+	c.positions[p.Account+"/"+p.Symbol] = p
+
+	request := c.requests[getString(msg.Body, tag.PosReqID)]
+	if request == nil || request.finished {
+		c.mu.Unlock()
+		if c.OnUpdate != nil {
+			c.OnUpdate(p)
+		}
+		return
+	}
+	defer c.mu.Unlock()
+
+	request.positions = append(request.positions, p)
+	if msg.Body.Has(tag.TotalNumPosReports) {
+		request.total = getInt(msg.Body, tag.TotalNumPosReports)
+	}
+	if request.total >= 0 && len(request.positions) >= request.total {
+		request.finish(nil)
+	}
+}
+
+// Request returns positions of an account (all accounts if empty), with subscribe the venue keeps sending updates to OnUpdate
+func (c *PositionsClient) Request(targetCompID string, account string, subscribe bool, timeout time.Duration) ([]VenuePosition, error) {
+	requestID := fmt.Sprint(pt.DefaultTokenGenerator.Next())
+	now := time.Now().UTC()
+
+	request := requestforpositions.New(
+		field.NewPosReqID(requestID),
+		field.NewPosReqType(enum.PosReqType_POSITIONS),
+		field.NewAccount(account),
+		field.NewAccountType(enum.AccountType_ACCOUNT_IS_CARRIED_ON_CUSTOMER_SIDE_OF_THE_BOOKS),
+		field.NewClearingBusinessDate(now.Format("20060102")),
+		field.NewTransactTime(now),
+	)
+	if subscribe {
+		request.SetSubscriptionRequestType(enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES)
+	} else {
+		request.SetSubscriptionRequestType(enum.SubscriptionRequestType_SNAPSHOT)
+	}
+
+	msg := request.ToMessage()
+	if account == "" {
+		msg.Body.Remove(tag.Account)
+	}
+	msg.Header.Set(field.NewSenderCompID(c.SenderCompID))
+	msg.Header.Set(field.NewTargetCompID(targetCompID))
+
+	pending := &positionsRequest{total: -1, done: make(chan error, 1)}
+	c.mu.Lock()
+	c.requests[requestID] = pending
+	c.mu.Unlock()
+
+	err := Send(msg)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.requests, requestID)
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case err = <-pending.done:
+	case <-time.After(timeout):
+		err = fmt.Errorf("RequestForPositions %s: no response in %v", requestID, timeout)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending.finished = true
+	if !subscribe {
+		delete(c.requests, requestID)
+	}
+	sortVenuePositions(pending.positions)
+	return pending.positions, err
+}
+
+// Positions returns the latest report of each account and symbol
+func (c *PositionsClient) Positions() []VenuePosition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]VenuePosition, 0, len(c.positions))
+	for _, p := range c.positions {
+		result = append(result, p)
+	}
+	sortVenuePositions(result)
+	return result
+}
+
+func sortVenuePositions(list []VenuePosition) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Account != list[j].Account {
+			return list[i].Account < list[j].Account
+		}
+		return list[i].Symbol < list[j].Symbol
+	})
+}
+
+// FormatVenuePositions renders positions as a table per account and symbol
+func FormatVenuePositions(list []VenuePosition) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-20s %-24s %14s %14s %14s %14s %-8s\n", "Account", "Symbol", "Long", "Short", "Net", "SettlPx", "Currency")
+	for _, p := range list {
+		long, short := decimal.Zero, decimal.Zero
+		for _, q := range p.Quantities {
+			if q.Type == enum.PosType_TOTAL_TRANSACTION_QTY || len(p.Quantities) == 1 {
+				long, short = q.Long, q.Short
+			}
+		}
+		fmt.Fprintf(&b, "%-20s %-24s %14s %14s %14s %14s %-8s\n",
+			p.Account, p.Symbol, long, short, p.Qty(), p.SettlPrice, p.Currency,
+		)
+	}
+	fmt.Fprintf(&b, "Positions: %d\n", len(list))
+	return b.String()
+}
+
+// PositionDiff is a symbol where the venue and the local positions disagree
+type PositionDiff struct {
+	Symbol string
+	Venue  decimal.Decimal
+	Local  decimal.Decimal
+}
+
+func (d PositionDiff) String() string {
+	return fmt.Sprintf("%-24s venue=%s local=%s diff=%s", d.Symbol, d.Venue, d.Local, d.Venue.Sub(d.Local))
+}
+
+// DiffPositions compares net venue positions, summed over accounts, with a local snapshot
+func DiffPositions(venue []VenuePosition, local positions.Snapshot) []PositionDiff {
+	venueQty := make(map[string]decimal.Decimal)
+	for _, p := range venue {
+		venueQty[p.Symbol] = venueQty[p.Symbol].Add(p.Qty())
+	}
+	localQty := make(map[string]decimal.Decimal)
+	for _, p := range local.Positions {
+		localQty[p.Symbol] = p.Qty
+	}
+
+	symbols := make(map[string]bool)
+	for symbol := range venueQty {
+		symbols[symbol] = true
+	}
+	for symbol := range localQty {
+		symbols[symbol] = true
+	}
+
+	diffs := make([]PositionDiff, 0)
+	for symbol := range symbols {
+		if !venueQty[symbol].Equal(localQty[symbol]) {
+			diffs = append(diffs, PositionDiff{Symbol: symbol, Venue: venueQty[symbol], Local: localQty[symbol]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Symbol < diffs[j].Symbol
+	})
+	return diffs
+}
+
+// journalPositions keeps positions of journaled fills, each update applies only fills since the previous one
+type journalPositions struct {
+	mu      sync.Mutex
+	path    string
+	journal *journal.Journal
+	engine  *positions.Engine
+	from    time.Time // TransactTime of the last fill applied
+}
+
+func openJournalPositions(path string) (*journalPositions, error) {
+	j, err := journal.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// only quantities are compared, no marks
+	return &journalPositions{path: path, journal: j, engine: positions.NewEngine(positions.Method_FIFO, nil)}, nil
+}
+
+func (p *journalPositions) Close() error {
+	return p.journal.Close()
+}
+
+// update applies fills since the last one applied, fills of the same TransactTime are read again and skipped by ExecID
+func (p *journalPositions) update() (positions.Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fills, err := p.journal.Fills(journal.FillFilter{From: p.from})
+	if err != nil {
+		return positions.Snapshot{}, err
+	}
+	for _, fill := range fills {
+		exec := &journal.Execution{
+			ExecID:       fill.ExecID,
+			ExecType:     enum.ExecType_TRADE,
+			Symbol:       fill.Symbol,
+			Side:         fill.Side,
+			LastQty:      fill.LastQty,
+			LastPx:       fill.LastPx,
+			Fee:          fill.Fee,
+			TransactTime: fill.TransactTime,
+			Legs:         fill.Legs,
+		}
+		for _, f := range PositionFills(exec) {
+			p.engine.Apply(f)
+		}
+		if fill.TransactTime.After(p.from) {
+			p.from = fill.TransactTime
+		}
+	}
+	return p.engine.Snapshot(), nil
+}
+
+func (p *journalPositions) printDiffs(venue []VenuePosition) {
+	local, err := p.update()
+	if err != nil {
+		fmt.Printf("Positions: %v\n", err)
+		return
+	}
+
+	diffs := DiffPositions(venue, local)
+	fmt.Printf("Positions differing from journal %s: %d\n", p.path, len(diffs))
+	for _, diff := range diffs {
+		fmt.Printf("\t%s\n", diff)
+	}
+}
+
+// RunPositions prints venue positions, compares them with journaled fills if set (-j) and optionally follows updates
+func RunPositions(cfgFileName string, apiKeyName string) error {
+	tapp, err := NewTradeClient(cfgFileName, apiKeyName)
+	if err != nil {
+		return err
+	}
+	var local *journalPositions
+	if *journalCmd != "" {
+		local, err = openJournalPositions(*journalCmd)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+	}
+
+	app := NewPositionsClient(tapp)
+	app.OnUpdate = func(p VenuePosition) {
+		fmt.Printf("\nPosition update:\n%s\n", FormatVenuePositions([]VenuePosition{p}))
+		if local != nil {
+			local.printDiffs(app.Positions())
+		}
+	}
+
+	err = StartConnection(app, app.Settings)
+	if err != nil {
+		return err
+	}
+	targetCompID, _ := app.Settings.GlobalSettings().Setting(config.TargetCompID)
+
+	list, err := app.Request(targetCompID, *positionsAccountCmd, *positionsSubscribeCmd, venuePositionsTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n%s\n", FormatVenuePositions(list))
+	if local != nil {
+		local.printDiffs(list)
+	}
+
+	if !*positionsSubscribeCmd {
+		return nil
+	}
+	for {
+		time.Sleep(time.Second)
+	}
+}
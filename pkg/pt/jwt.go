@@ -3,22 +3,28 @@ package pt
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// ReadKeys reads the keys of keyFilename from ./keys
 func ReadKeys(keyFilename string) (apiKey string, privateKey []byte, err error) {
-	path := "./keys"
-	apiKeyB, err := os.ReadFile(fmt.Sprintf("%s/%v.api", path, keyFilename))
+	return ReadKeysFrom("./keys", keyFilename)
+}
+
+// ReadKeysFrom reads `<dir>/<keyFilename>.api` with the api key and `<dir>/<keyFilename>.pem` with the private key
+func ReadKeysFrom(dir string, keyFilename string) (apiKey string, privateKey []byte, err error) {
+	apiKeyB, err := os.ReadFile(filepath.Join(dir, keyFilename+".api"))
 	if err != nil {
-		panic("Can't read api key")
+		return "", nil, fmt.Errorf("can't read api key: %v", err)
 	}
 	apiKey = strings.Split(string(apiKeyB), "\n")[0]
-	privateKey, err = os.ReadFile(fmt.Sprintf("%s/%v.pem", path, keyFilename))
+	privateKey, err = os.ReadFile(filepath.Join(dir, keyFilename+".pem"))
 	if err != nil {
-		panic("Can't read private key")
+		return "", nil, fmt.Errorf("can't read private key: %v", err)
 	}
 	return
 }
@@ -0,0 +1,7 @@
+// Package spec embeds the PowerTrade dictionary and FIX configs, so that binaries run without the repository
+package spec
+
+import "embed"
+
+//go:embed *.xml *.cfg
+var FS embed.FS